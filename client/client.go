@@ -0,0 +1,317 @@
+// Package client embeds dynamic DNS updates in other programs. Everything is configured through the options
+// given to New. Nothing is read from flags, environment variables or config files, nothing is logged through
+// the global logger, and errors are always returned rather than exiting.
+//
+//	c, err := client.New(
+//		client.WithCloudFlare(conf.Credentials{Token: token}),
+//		client.WithRecords(conf.RecordConfig{Domain: "example.com", Record: "home.example.com"}),
+//		client.WithLogger(logger),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	return c.Run(ctx, time.Minute, 10*time.Second)
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+	"github.com/mattolenik/cloudflare-ddns-client/ip"
+	"github.com/mattolenik/cloudflare-ddns-client/providers"
+	"github.com/mattolenik/cloudflare-ddns-client/task"
+	"github.com/rs/zerolog"
+)
+
+// Option configures a Client, see New
+type Option func(o *options) error
+
+type options struct {
+	credentials *conf.Credentials
+	provider    ddns.DDNSProvider
+	targets     map[string]ddns.Target
+	ipProvider  ddns.IPProvider
+	staticIP    string
+	sources     []string
+	stunServers []string
+	records     []conf.RecordConfig
+	logger      zerolog.Logger
+	clock       ddns.Clock
+	httpClient  *http.Client
+	damping     ddns.Damping
+	verifier    ddns.Verifier
+}
+
+// WithCloudFlare updates records through the CloudFlare API, authenticating with creds.
+// Records of type conf.TypeIPList and conf.TypeLBOrigin are supported as well.
+func WithCloudFlare(creds conf.Credentials) Option {
+	return func(o *options) error {
+		if err := creds.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+		o.credentials = &creds
+		return nil
+	}
+}
+
+// WithProvider updates DNS records through provider, instead of CloudFlare
+func WithProvider(provider ddns.DDNSProvider) Option {
+	return func(o *options) error {
+		if provider == nil {
+			return errors.New("provider must not be nil")
+		}
+		o.provider = provider
+		return nil
+	}
+}
+
+// WithTarget handles records of the given type with target, replacing any target set up by WithCloudFlare
+func WithTarget(recordType string, target ddns.Target) Option {
+	return func(o *options) error {
+		if target == nil {
+			return errors.New("target must not be nil")
+		}
+		o.targets[recordType] = target
+		return nil
+	}
+}
+
+// WithRecords sets the records to keep pointed at the public IP, they can be replaced later with SetRecords
+func WithRecords(records ...conf.RecordConfig) Option {
+	return func(o *options) error {
+		o.records = append(o.records, records...)
+		return nil
+	}
+}
+
+// WithIPSources sets where to look for the public IP, tried in order, see ip.Sources. Defaults to ip.DefaultSources.
+func WithIPSources(sources ...string) Option {
+	return func(o *options) error {
+		if err := ip.ValidateSources(sources); err != nil {
+			return errors.Trace(err)
+		}
+		o.sources = sources
+		return nil
+	}
+}
+
+// WithSTUNServers sets the servers, as host:port, that are asked for the public IP by ip.SourceSTUN
+func WithSTUNServers(servers ...string) Option {
+	return func(o *options) error {
+		o.stunServers = servers
+		return nil
+	}
+}
+
+// WithStaticIP publishes an already known IP instead of looking it up
+func WithStaticIP(addr string) Option {
+	return func(o *options) error {
+		o.staticIP = addr
+		return nil
+	}
+}
+
+// WithIPProvider looks up the public IP with provider, instead of the sources given by WithIPSources
+func WithIPProvider(provider ddns.IPProvider) Option {
+	return func(o *options) error {
+		if provider == nil {
+			return errors.New("IP provider must not be nil")
+		}
+		o.ipProvider = provider
+		return nil
+	}
+}
+
+// WithLogger sets where logs are written, nothing is logged by default
+func WithLogger(logger zerolog.Logger) Option {
+	return func(o *options) error {
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithClock replaces the system clock, which is used to tell the time and to wait between checks
+func WithClock(clock ddns.Clock) Option {
+	return func(o *options) error {
+		if clock == nil {
+			return errors.New("clock must not be nil")
+		}
+		o.clock = clock
+		return nil
+	}
+}
+
+// WithHTTPClient makes all HTTP requests, to CloudFlare and to look up the public IP, with client
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) error {
+		o.httpClient = client
+		return nil
+	}
+}
+
+// WithDamping holds back changes of the public IP, see ddns.Damping
+func WithDamping(damping ddns.Damping) Option {
+	return func(o *options) error {
+		o.damping = damping
+		return nil
+	}
+}
+
+// WithVerifier checks that each update has propagated, e.g. with a propagation.Verifier
+func WithVerifier(verifier ddns.Verifier) Option {
+	return func(o *options) error {
+		o.verifier = verifier
+		return nil
+	}
+}
+
+// Client keeps records pointed at the public IP, either once with Update or continually with Run
+type Client struct {
+	daemon         *ddns.DDNSDaemon
+	configProvider *ddns.DefaultConfigProvider
+	logger         zerolog.Logger
+	cancel         context.CancelFunc
+}
+
+// New creates a Client from the given options. A provider, from WithCloudFlare or WithProvider,
+// and at least one record are required.
+func New(opts ...Option) (*Client, error) {
+	o := &options{targets: map[string]ddns.Target{}, logger: zerolog.Nop()}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, errors.Annotate(err, "invalid option")
+		}
+	}
+	if err := conf.ValidateRecords(o.records); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// Cancelled once the context given to Run is done, so that no request to CloudFlare holds it up
+	ctx, cancel := context.WithCancel(context.Background())
+	provider := o.provider
+	targets := map[string]ddns.Target{}
+	if o.credentials != nil {
+		cf, err := providers.NewCloudFlareProvider(ctx, *o.credentials, o.httpClient)
+		if err != nil {
+			cancel()
+			return nil, errors.Trace(err)
+		}
+		cf.SetLogger(o.logger)
+		if provider == nil {
+			provider = cf
+		}
+		targets[conf.TypeIPList] = providers.NewIPListTarget(cf)
+		targets[conf.TypeLBOrigin] = providers.NewLBOriginTarget(cf)
+	}
+	if provider == nil {
+		cancel()
+		return nil, errors.New("no DNS provider, use WithCloudFlare or WithProvider")
+	}
+	for recordType, target := range o.targets {
+		targets[recordType] = target
+	}
+
+	ipProvider := o.ipProvider
+	if o.staticIP != "" {
+		static, err := ddns.NewStaticIPProvider(o.staticIP)
+		if err != nil {
+			cancel()
+			return nil, errors.Trace(err)
+		}
+		ipProvider = static
+	}
+	if ipProvider == nil {
+		resolver := ddns.NewIPProvider(&ip.Resolver{
+			Sources:     o.sources,
+			STUNServers: o.stunServers,
+			HTTPClient:  o.httpClient,
+			Logger:      &o.logger,
+		})
+		if o.clock != nil {
+			resolver.SetClock(o.clock)
+		}
+		ipProvider = resolver
+	}
+
+	configProvider := ddns.NewDefaultConfigProvider()
+	configProvider.Set(o.records)
+	daemon := ddns.NewDefaultDaemon(provider, ipProvider, configProvider)
+	for recordType, target := range targets {
+		daemon.SetTarget(recordType, target)
+	}
+	if o.clock != nil {
+		daemon.SetClock(o.clock)
+	}
+	daemon.SetDamping(o.damping)
+	if o.verifier != nil {
+		daemon.SetVerifier(o.verifier)
+	}
+	return &Client{daemon: daemon, configProvider: configProvider, logger: o.logger, cancel: cancel}, nil
+}
+
+// Update points every record at the public IP once
+func (c *Client) Update() error {
+	return errors.Trace(c.daemon.Update())
+}
+
+// Run keeps every record pointed at the public IP, checking every updatePeriod, or every retryDelay after a failure.
+// It returns nil once ctx is done, or an error if the daemon had to stop, e.g. because the credentials were rejected.
+// Once ctx is done, requests in flight and waits between retries are abandoned so that Run returns promptly, which
+// means that records' on_shutdown actions can't be carried out through CloudFlare. Use Stop to shut down gracefully.
+// Run can only be called once.
+func (c *Client) Run(ctx context.Context, updatePeriod, retryDelay time.Duration) error {
+	logs := c.daemon.Events().Subscribe("client", 100, task.DropOldest)
+	c.daemon.Start(updatePeriod, retryDelay)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.cancel()
+			c.daemon.Stop()
+		case <-done:
+		}
+	}()
+	for status := range logs.Events() {
+		status.Log(&c.logger)
+		if status.Type == task.Fatal {
+			return status.Error
+		}
+	}
+	return nil
+}
+
+// Stop makes Run return once the current check, if any, is finished and each record's on_shutdown action has been
+// carried out
+func (c *Client) Stop() {
+	c.daemon.Stop()
+}
+
+// SetRecords replaces the records kept up to date, they are picked up on the next check
+func (c *Client) SetRecords(records ...conf.RecordConfig) error {
+	if err := conf.ValidateRecords(records); err != nil {
+		return errors.Trace(err)
+	}
+	c.configProvider.Set(records)
+	c.daemon.Trigger()
+	return nil
+}
+
+// Trigger requests an immediate check while running
+func (c *Client) Trigger() {
+	c.daemon.Trigger()
+}
+
+// State returns a snapshot of what the client is currently doing
+func (c *Client) State() ddns.DaemonState {
+	return c.daemon.State()
+}
+
+// Events returns the bus that progress is published to while running, subscribe before calling Run
+func (c *Client) Events() *task.Bus {
+	return c.daemon.Events()
+}
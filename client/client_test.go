@@ -0,0 +1,134 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+	"github.com/mattolenik/cloudflare-ddns-client/ip"
+	"github.com/mattolenik/cloudflare-ddns-client/task"
+	"github.com/mattolenik/cloudflare-ddns-client/test"
+	"github.com/rs/zerolog"
+)
+
+func TestNewValidatesOptions(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	record := conf.RecordConfig{Domain: "abc.com", Record: "xyz.abc.com"}
+	provider := ddns.NewMockDDNSProvider(ctrl)
+
+	_, err := New(WithRecords(record))
+	assert.Error(err, "expected a provider to be required")
+
+	_, err = New(WithProvider(provider))
+	assert.Error(err, "expected at least one record to be required")
+
+	_, err = New(WithProvider(provider), WithRecords(record, record))
+	assert.Error(err, "expected duplicate records to be invalid")
+
+	_, err = New(WithCloudFlare(conf.Credentials{Method: conf.AuthKey, Key: "key"}), WithRecords(record))
+	assert.Error(err, "expected incomplete credentials to be invalid")
+
+	_, err = New(WithProvider(provider), WithRecords(record), WithIPSources("carrier-pigeon"))
+	assert.Error(err, "expected unknown IP source to be invalid")
+
+	_, err = New(WithProvider(provider), WithRecords(record), WithStaticIP("1.1.1"))
+	assert.Error(err, "expected malformed IP to be invalid")
+
+	_, err = New(WithProvider(provider), WithRecords(record))
+	assert.NoError(err)
+}
+
+func TestUpdate(t *testing.T) {
+	_, require, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	provider := ddns.NewMockDDNSProvider(ctrl)
+	provider.EXPECT().Get("abc.com", "xyz.abc.com").Return("", nil)
+	provider.EXPECT().Update("abc.com", "xyz.abc.com", "1.1.1.1").Return(nil).Times(1)
+	c, err := New(
+		WithProvider(provider),
+		WithRecords(conf.RecordConfig{Domain: "abc.com", Record: "xyz.abc.com"}),
+		WithStaticIP("1.1.1.1"),
+	)
+	require.NoError(err)
+	require.NoError(c.Update())
+}
+
+func TestRun(t *testing.T) {
+	assert, require, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	provider := ddns.NewMockDDNSProvider(ctrl)
+	ipProvider := ddns.NewMockIPProvider(ctrl)
+	provider.EXPECT().Get("abc.com", "xyz.abc.com").Return("", nil).AnyTimes()
+	provider.EXPECT().Update("abc.com", "xyz.abc.com", "1.1.1.1").Return(nil).Times(1)
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+
+	var logs bytes.Buffer
+	c, err := New(
+		WithProvider(provider),
+		WithIPProvider(ipProvider),
+		WithRecords(conf.RecordConfig{Domain: "abc.com", Record: "xyz.abc.com"}),
+		WithLogger(zerolog.New(&logs)),
+	)
+	require.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := c.Events().Subscribe("test", 100, task.DropOldest)
+	go func() {
+		for s := range events.Events() {
+			if s.Kind == task.RecordUpdated {
+				cancel()
+			}
+		}
+	}()
+	assert.NoError(c.Run(ctx, time.Hour, time.Hour))
+	assert.Contains(logs.String(), `"event":"record_updated"`, "events must be logged to the given logger")
+}
+
+// frozenClock never lets any time pass, so that any wait can only end by being cancelled
+type frozenClock struct{}
+
+func (frozenClock) Now() time.Time                         { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+func (frozenClock) After(d time.Duration) <-chan time.Time { return make(chan time.Time) }
+
+func TestRunCancelled(t *testing.T) {
+	assert, require, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	// Nothing listens on the port, so every attempt to find the public IP fails straight away and is retried
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(err)
+	closed := conn.LocalAddr().String()
+	conn.Close()
+
+	provider := ddns.NewMockDDNSProvider(ctrl)
+	c, err := New(
+		WithProvider(provider),
+		WithRecords(conf.RecordConfig{Domain: "abc.com", Record: "xyz.abc.com"}),
+		WithIPSources(ip.SourceSTUN),
+		WithSTUNServers(closed),
+		WithClock(frozenClock{}),
+	)
+	require.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan error, 1)
+	go func() { returned <- c.Run(ctx, time.Hour, time.Hour) }()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-returned:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run must return promptly once its context is cancelled, even while retrying to find the public IP")
+	}
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/control"
+	"github.com/spf13/cobra"
+)
+
+// Ctl sends commands to a running daemon over its control socket
+var Ctl = &cobra.Command{
+	Use:       fmt.Sprintf("ctl {%s}", strings.Join(control.Commands, "|")),
+	Short:     "Control a running daemon through its control socket",
+	Args:      cobra.ExactValidArgs(1),
+	ValidArgs: control.Commands,
+	Long: `Sends a command to a daemon started with --daemon and --control-socket.
+
+Commands:
+    check-now  check for a new IP right away
+    status     print the daemon's current state as JSON
+    pause      stop checking for IP changes until resumed
+    resume     continue checking for IP changes
+    reload     re-read the configuration file
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		socket := conf.ControlSocket.Get()
+		if socket == "" {
+			return errors.New("the --control-socket of the running daemon must be specified")
+		}
+		resp, err := control.Send(socket, args[0])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !resp.OK {
+			return errors.Errorf("daemon rejected command '%s': %s", args[0], resp.Error)
+		}
+		out, err := json.MarshalIndent(resp.State, "", "  ")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(out))
+		return nil
+	},
+}
+
+func init() {
+	Root.AddCommand(Ctl)
+}
@@ -0,0 +1,343 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+	"github.com/mattolenik/cloudflare-ddns-client/providers"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// How many times an interactive user may re-enter credentials that CloudFlare rejects
+const maxCredentialAttempts = 3
+
+var (
+	initOutput         string
+	initRecords        []string
+	initNonInteractive bool
+	initForce          bool
+)
+
+// Init creates a config file, asking for anything that wasn't given as a flag
+var Init = &cobra.Command{
+	Use:   "init",
+	Short: "Create a config file, checking the credentials and zone with CloudFlare",
+	Long: `Creates a config file, asking for the CloudFlare credentials, the zone and the records to keep
+up to date. The credentials are verified, the zones they can access are listed to choose from,
+and the public IP is detected before anything is written. The file is only readable by its owner.
+
+Every answer can be given as a flag instead, e.g. to run from a provisioning script:
+    cloudflare-ddns init --non-interactive --token <api-token> --domain example.com --record home.example.com
+`,
+	Args: cobra.NoArgs,
+	// Don't read an existing config file, it may be the one being replaced and may not be valid
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		initEnv()
+		setLogLevel()
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p := &prompter{in: bufio.NewReader(cmd.InOrStdin()), out: cmd.OutOrStdout(), interactive: !initNonInteractive}
+		if f, ok := cmd.InOrStdin().(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+			fd := int(f.Fd())
+			p.readSecret = func() ([]byte, error) { return term.ReadPassword(fd) }
+		}
+		return errors.Trace(runInit(cmd.Context(), p))
+	},
+}
+
+func init() {
+	f := Init.Flags()
+	f.StringVarP(&initOutput, "output", "o", "", "Path of the config file to create, the format is chosen by its extension (default $HOME/.config/cloudflare-ddns.toml)")
+	f.StringSliceVar(&initRecords, "records", nil, "Records to keep up to date, in addition to --record")
+	f.BoolVar(&initNonInteractive, "non-interactive", false, "Don't ask for anything, fail if an answer is missing from the flags")
+	f.BoolVar(&initForce, "force", false, "Replace the config file if it already exists")
+	Root.AddCommand(Init)
+}
+
+// runInit asks for everything needed in a config file, checks it with CloudFlare and writes it
+func runInit(ctx context.Context, p *prompter) error {
+	settings := &conf.Settings{Credentials: conf.Credentials{
+		Method: conf.AuthMethod.Get(),
+		Token:  conf.Token.Get(),
+		Key:    conf.APIKey.Get(),
+		Email:  conf.Email.Get(),
+	}}
+	provider, err := verifyCredentials(ctx, p, &settings.Credentials)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	zones, err := provider.Zones()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(zones) == 0 {
+		return &conf.ConfigError{Err: errors.Errorf("the %s can't access any zones", settings.Credentials)}
+	}
+	zone, err := p.choose("Zone", zones, conf.Domain.Get())
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	existing, err := provider.Records(zone)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	names := initRecords
+	if record := conf.Record.Get(); record != "" {
+		names = append([]string{record}, names...)
+	}
+	if len(names) == 0 {
+		if len(existing) > 0 && p.interactive {
+			p.printf("Existing A records in '%s':\n", zone)
+			p.list(existing)
+		}
+		answer, err := p.ask("Records to keep up to date, as numbers from the list or names, separated by commas", "")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, name := range strings.Split(answer, ",") {
+			names = append(names, pick(strings.TrimSpace(name), existing))
+		}
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		// Allow names relative to the zone, e.g. home for home.example.com
+		if name != zone && !strings.HasSuffix(name, "."+zone) {
+			name += "." + zone
+		}
+		settings.Records = append(settings.Records, conf.RecordConfig{Domain: zone, Record: name})
+	}
+	if err := settings.Validate(); err != nil {
+		return &conf.ConfigError{Err: err}
+	}
+
+	checkPublicIP(ctx, p, settings.Records)
+
+	path, err := outputPath(p)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Annotatef(err, "unable to create directory for config file '%s'", path)
+	}
+	if err := conf.WriteConfigFile(path, settings); err != nil {
+		return &conf.ConfigError{Err: err}
+	}
+	p.printf("Wrote config file '%s'. To update the records now, run:\n    cloudflare-ddns --config '%s'\n", path, path)
+	return nil
+}
+
+// verifyCredentials asks for any missing credentials and checks them with CloudFlare, asking again if they are rejected
+func verifyCredentials(ctx context.Context, p *prompter, creds *conf.Credentials) (*providers.CloudFlareProvider, error) {
+	for attempt := 1; ; attempt++ {
+		if creds.Method == conf.AuthKey {
+			if creds.Key == "" {
+				key, err := p.askSecret("CloudFlare Global API Key")
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				creds.Key = key
+			}
+			if creds.Email == "" {
+				email, err := p.ask("Email of the CloudFlare account", "")
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				creds.Email = email
+			}
+		} else if creds.Token == "" {
+			token, err := p.askSecret("CloudFlare API token, with permissions Zone:Zone:Read and Zone:DNS:Edit")
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			creds.Token = token
+		}
+		if err := creds.Validate(); err != nil {
+			return nil, &conf.ConfigError{Err: err}
+		}
+		provider, err := providers.NewCloudFlareProvider(ctx, *creds, nil, cloudflareOptions()...)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		err = provider.Verify()
+		if err == nil {
+			p.printf("CloudFlare accepted the %s\n", creds)
+			return provider, nil
+		}
+		if class, _ := ddns.Classify(err); class != ddns.ClassAuth || !p.interactive || attempt == maxCredentialAttempts {
+			return nil, errors.Trace(err)
+		}
+		p.printf("%v\n", err)
+		creds.Token, creds.Key, creds.Email = "", "", ""
+	}
+}
+
+// checkPublicIP shows the public IP that the records would be pointed at, warning about anything that would prevent it.
+// Problems only result in warnings, the IP may well be different wherever the config file is going to be used.
+func checkPublicIP(ctx context.Context, p *prompter, records []conf.RecordConfig) {
+	var ipProvider ddns.IPProvider = ddns.NewDefaultIPProvider()
+	if addr := conf.IP.Get(); addr != "" {
+		static, err := ddns.NewStaticIPProvider(addr)
+		if err != nil {
+			p.printf("Warning: %v\n", err)
+			return
+		}
+		ipProvider = static
+	}
+	addr, source, err := ipProvider.Get(ctx)
+	if err != nil {
+		p.printf("Warning: unable to detect the public IP, the records can't be updated from here: %v\n", err)
+		return
+	}
+	p.printf("The public IP is '%s', found with %s\n", addr, source)
+	for _, r := range records {
+		policy, err := r.Policy()
+		if err != nil {
+			continue
+		}
+		if err := policy.Check(addr); err != nil {
+			p.printf("Warning: record '%s' would not be updated: %v\n", r.Record, err)
+		}
+	}
+}
+
+// outputPath returns where the config file is to be written, checking that nothing is replaced without permission
+func outputPath(p *prompter) (string, error) {
+	path := initOutput
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Annotate(err, "unable to find home directory, use --output")
+		}
+		path = filepath.Join(home, ".config", conf.DefaultConfigName+".toml")
+		if path, err = p.ask("Config file to write", path); err != nil {
+			return "", errors.Trace(err)
+		}
+	}
+	if _, err := os.Stat(path); err != nil || initForce {
+		return path, nil
+	}
+	if !p.interactive {
+		return "", &conf.ConfigError{Err: errors.Errorf("config file '%s' already exists, use --force to replace it", path)}
+	}
+	answer, err := p.ask(fmt.Sprintf("Config file '%s' already exists, replace it? (y/n)", path), "n")
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if !strings.HasPrefix(strings.ToLower(answer), "y") {
+		return "", errors.New("not replacing existing config file")
+	}
+	return path, nil
+}
+
+// pick returns the option numbered by answer, counting from 1, or answer itself if it isn't a number in range
+func pick(answer string, options []string) string {
+	if i, err := strconv.Atoi(answer); err == nil && i >= 1 && i <= len(options) {
+		return options[i-1]
+	}
+	return answer
+}
+
+// prompter asks the user questions. When not interactive, questions with a default get it as the answer
+// and questions without one are an error.
+type prompter struct {
+	in          *bufio.Reader
+	out         io.Writer
+	interactive bool
+	// readSecret, if set, reads a line from the terminal without echoing it
+	readSecret func() ([]byte, error)
+}
+
+func (p *prompter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(p.out, format, args...)
+}
+
+// list prints the options numbered from 1
+func (p *prompter) list(options []string) {
+	for i, option := range options {
+		p.printf("  %d) %s\n", i+1, option)
+	}
+}
+
+// ask returns the answer to question, or def if the answer is empty
+func (p *prompter) ask(question, def string) (string, error) {
+	if !p.interactive {
+		if def == "" {
+			return "", &conf.ConfigError{Err: errors.Errorf("missing answer in non-interactive mode: %s", question)}
+		}
+		return def, nil
+	}
+	for {
+		if def != "" {
+			p.printf("%s [%s]: ", question, def)
+		} else {
+			p.printf("%s: ", question)
+		}
+		line, err := p.in.ReadString('\n')
+		answer := strings.TrimSpace(line)
+		if err != nil && (err != io.EOF || answer == "") {
+			return "", errors.Annotatef(err, "no answer to: %s", question)
+		}
+		if answer == "" {
+			answer = def
+		}
+		if answer != "" {
+			return answer, nil
+		}
+	}
+}
+
+// askSecret is the same as ask without a default, but the answer isn't shown as it is typed if it comes from a terminal
+func (p *prompter) askSecret(question string) (string, error) {
+	if !p.interactive || p.readSecret == nil {
+		return p.ask(question, "")
+	}
+	for {
+		p.printf("%s: ", question)
+		secret, err := p.readSecret()
+		// The newline typed by the user wasn't echoed either
+		p.printf("\n")
+		if err != nil {
+			return "", errors.Annotatef(err, "no answer to: %s", question)
+		}
+		if answer := strings.TrimSpace(string(secret)); answer != "" {
+			return answer, nil
+		}
+	}
+}
+
+// choose returns one of options, given, if not empty, or else chosen by the user by number or name
+func (p *prompter) choose(question string, options []string, given string) (string, error) {
+	if given == "" {
+		if len(options) == 1 {
+			given = options[0]
+		} else {
+			p.list(options)
+			answer, err := p.ask(question, "")
+			if err != nil {
+				return "", errors.Trace(err)
+			}
+			given = pick(answer, options)
+		}
+	}
+	for _, option := range options {
+		if option == given {
+			return given, nil
+		}
+	}
+	return "", &conf.ConfigError{Err: errors.Errorf("'%s' is not one of: %s", given, strings.Join(options, ", "))}
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAskSecret(t *testing.T) {
+	var out strings.Builder
+	secrets := []string{"", "  secret-token \r"}
+	p := &prompter{in: bufio.NewReader(strings.NewReader("")), out: &out, interactive: true, readSecret: func() ([]byte, error) {
+		secret := secrets[0]
+		secrets = secrets[1:]
+		return []byte(secret), nil
+	}}
+	answer, err := p.askSecret("API token")
+	require.NoError(t, err)
+	assert.Equal(t, "secret-token", answer, "an empty answer must be asked again")
+	assert.Equal(t, "API token: \nAPI token: \n", out.String(), "the secret must not be written out")
+
+	// Without a terminal, the answer is read like any other
+	out.Reset()
+	p = &prompter{in: bufio.NewReader(strings.NewReader("piped-token\n")), out: &out, interactive: true}
+	answer, err = p.askSecret("API token")
+	require.NoError(t, err)
+	assert.Equal(t, "piped-token", answer)
+}
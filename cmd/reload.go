@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+	"github.com/mattolenik/cloudflare-ddns-client/providers"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// How long the config file must be left alone before a change is reloaded, editors often write files in several steps
+const configSettleTime = 1 * time.Second
+
+// reloader applies changes to the config files to a running daemon. A new configuration
+// is validated before it is applied, if it is invalid the daemon keeps the old one.
+type reloader struct {
+	mu             sync.Mutex
+	current        *conf.Settings
+	path           string
+	files          *conf.ConfigFiles
+	flags          *pflag.FlagSet
+	provider       *providers.CloudFlareProvider
+	configProvider *ddns.DefaultConfigProvider
+	daemon         ddns.Daemon
+	// The options only read when the daemon started, see startupOptions
+	started map[string]interface{}
+}
+
+// newReloader creates a reloader for the config file or directory at path, whose content at startup was files.
+// Both are empty if no config file is used. flags take precedence over the config files, as they did at startup.
+func newReloader(path string, files *conf.ConfigFiles, flags *pflag.FlagSet, settings *conf.Settings, provider *providers.CloudFlareProvider, configProvider *ddns.DefaultConfigProvider, daemon ddns.Daemon) *reloader {
+	return &reloader{
+		current:        settings,
+		path:           path,
+		files:          files,
+		flags:          flags,
+		provider:       provider,
+		configProvider: configProvider,
+		daemon:         daemon,
+		started:        startupOptions(conf.V()),
+	}
+}
+
+// startupOptions returns the values in v of the options that are only read when the daemon starts, by name.
+// Reloading only applies the records and credentials, changes to any of these need a restart.
+func startupOptions(v *viper.Viper) map[string]interface{} {
+	options := map[string]interface{}{}
+	for _, name := range []string{
+		conf.ControlSocket.Name, conf.HeartbeatTemplate.Name, conf.IP.Name, conf.Lease.Name, conf.LeaseFile.Name,
+		conf.InstanceID.Name, conf.JSONOutput.Name, conf.MetricsAddress.Name, conf.StateFile.Name,
+	} {
+		options[name] = v.GetString(name)
+	}
+	for _, name := range []string{
+		conf.PollInterval.Name, conf.FallbackInterval.Name, conf.ConfirmDuration.Name, conf.PropagationTimeout.Name,
+		conf.HeartbeatInterval.Name, conf.LeaseTTL.Name,
+	} {
+		options[name] = v.GetDuration(name)
+	}
+	for _, name := range []string{
+		conf.WatchNetwork.Name, conf.WatchConfig.Name, conf.VerifyPropagation.Name, conf.ReadViaDNS.Name, conf.Verbose.Name,
+	} {
+		options[name] = v.GetBool(name)
+	}
+	for _, name := range []string{conf.ConfirmChecks.Name, conf.MaxChangesPerHour.Name} {
+		options[name] = v.GetInt(name)
+	}
+	for _, name := range []string{conf.STUNServers.Name, conf.IPSources.Name} {
+		options[name] = v.GetStringSlice(name)
+	}
+	return options
+}
+
+// needRestart returns the names of the options whose values differ from those the daemon started with, sorted
+func (r *reloader) needRestart(options map[string]interface{}) []string {
+	var changed []string
+	for name, value := range options {
+		if !reflect.DeepEqual(value, r.started[name]) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Reload re-reads the config files and applies them if valid. The new configuration is read and validated
+// separately from the one in use, which is only replaced once it is known to be good. Only the records and
+// credentials are applied, a warning is logged for any other option that was changed.
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.files == nil {
+		return errors.New("not using a config file, there is nothing to reload")
+	}
+	files, err := conf.LoadConfigFiles(r.path)
+	if err != nil {
+		return errors.Annotatef(err, "unable to read configuration from '%s', keeping previous configuration", r.path)
+	}
+	v, err := files.Viper(r.flags)
+	if err != nil {
+		return errors.Annotatef(err, "unable to read configuration from '%s', keeping previous configuration", r.path)
+	}
+	settings, err := conf.LoadSettingsFrom(v)
+	if err != nil {
+		return errors.Annotatef(err, "configuration in '%s' is invalid, keeping previous configuration", r.path)
+	}
+	if settings.Credentials != r.current.Credentials {
+		if err := r.provider.SetCredentials(settings.Credentials); err != nil {
+			return errors.Annotate(err, "unable to use new credentials, keeping previous configuration")
+		}
+		log.Info().Msgf("Now authenticating with CloudFlare using new %s", settings.Credentials)
+	}
+	options := startupOptions(v)
+	for _, name := range r.needRestart(options) {
+		log.Warn().Msgf("Option '%s' was changed to '%v', but the daemon keeps using '%v' until it is restarted", name, options[name], r.started[name])
+	}
+	conf.Use(v)
+	r.configProvider.Set(settings.Records)
+	r.current = settings
+	r.files = files
+	log.Info().Msgf("Reloaded configuration from '%s'", r.path)
+	r.daemon.Trigger()
+	return nil
+}
+
+// configFiles returns the config files currently in use
+func (r *reloader) configFiles() *conf.ConfigFiles {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.files
+}
+
+// Watch reloads the config files whenever one of them changes, or a file is added to an included directory, until ctx is done
+func (r *reloader) Watch(ctx context.Context) error {
+	files := r.configFiles()
+	if files == nil {
+		return errors.New("not using a config file, there is nothing to watch")
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Annotate(err, "unable to watch config file")
+	}
+	// Watch directories rather than files, editors and config management often replace files instead of writing to them
+	for _, dir := range files.Dirs() {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return errors.Annotatef(err, "unable to watch config directory '%s'", dir)
+		}
+	}
+	go func() {
+		defer watcher.Close()
+		var pending *time.Timer
+		for {
+			select {
+			case <-ctx.Done():
+				if pending != nil {
+					pending.Stop()
+				}
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !r.configFiles().Includes(event.Name) || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				if pending != nil {
+					pending.Stop()
+				}
+				changed := event.Name
+				pending = time.AfterFunc(configSettleTime, func() {
+					log.Info().Msgf("Config file '%s' changed, reloading", changed)
+					if err := r.Reload(); err != nil {
+						log.Error().Msg(err.Error())
+						return
+					}
+					// Newly included files may be in directories that aren't watched yet
+					for _, dir := range r.configFiles().Dirs() {
+						if err := watcher.Add(dir); err != nil {
+							log.Error().Msgf("Unable to watch config directory '%s': %v", dir, err)
+						}
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error().Msgf("Error while watching config file: %v", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+	"github.com/mattolenik/cloudflare-ddns-client/providers"
+	"github.com/mattolenik/cloudflare-ddns-client/test"
+	"github.com/spf13/viper"
+)
+
+func TestReload(t *testing.T) {
+	assert, require, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+	defer conf.Use(viper.GetViper())
+
+	path := filepath.Join(t.TempDir(), "cloudflare-ddns.toml")
+	write := func(content string) {
+		require.NoError(os.WriteFile(path, []byte(content), 0600))
+	}
+	write("token = \"abc\"\n[[records]]\ndomain = \"example.com\"\nrecord = \"a.example.com\"\n")
+	flags := Root.PersistentFlags()
+	files, err := conf.LoadConfigFiles(path)
+	require.NoError(err)
+	require.NoError(files.Apply(flags))
+	settings, err := conf.LoadSettings()
+	require.NoError(err)
+	assert.Equal(time.Minute, conf.LeaseTTL.Get(), "options not in the config files must keep their defaults")
+	provider, err := providers.NewCloudFlareProvider(context.Background(), settings.Credentials, nil)
+	require.NoError(err)
+	configProvider := ddns.NewDefaultConfigProvider()
+	configProvider.Set(settings.Records)
+	daemon := ddns.NewMockDaemon(ctrl)
+	r := newReloader(path, files, flags, settings, provider, configProvider, daemon)
+
+	// The configuration is read while it is reloaded, it must never be seen half applied
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if token := conf.Token.Get(); token != "abc" && token != "def" {
+				assert.Fail("unexpected token", "token was '%s'", token)
+				return
+			}
+		}
+	}()
+
+	write("token = \"def\"\n[[records]]\ndomain = \"example.com\"\nrecord = \"a.example.com\"\ninterval = \"-1m\"\n")
+	assert.Error(r.Reload(), "an invalid configuration must not be applied")
+	assert.Equal("abc", conf.Token.Get(), "the previous configuration must be kept")
+	records, err := configProvider.Get()
+	require.NoError(err)
+	assert.Equal(settings.Records, records)
+
+	write("token = \"def\"\n[[records]]\ndomain = \"example.com\"\nrecord = \"a.example.com\"\n[[records]]\ndomain = \"example.com\"\nrecord = \"b.example.com\"\n")
+	daemon.EXPECT().Trigger().Times(1)
+	assert.NoError(r.Reload())
+	assert.Equal("def", conf.Token.Get())
+	records, err = configProvider.Get()
+	require.NoError(err)
+	assert.Len(records, 2)
+	assert.Empty(r.needRestart(startupOptions(conf.V())), "options left alone must not be reported as changed")
+
+	write("token = \"def\"\npoll-interval = \"1h\"\nstun-servers = [\"stun.example.com:3478\"]\n[[records]]\ndomain = \"example.com\"\nrecord = \"a.example.com\"\n")
+	daemon.EXPECT().Trigger().Times(1)
+	assert.NoError(r.Reload(), "changes to options that need a restart must not stop the records from being reloaded")
+	assert.Equal([]string{"poll-interval", "stun-servers"}, r.needRestart(startupOptions(conf.V())))
+	records, err = configProvider.Get()
+	require.NoError(err)
+	assert.Len(records, 1)
+
+	close(done)
+	wg.Wait()
+}
@@ -2,16 +2,23 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"strings"
-	"time"
+	"syscall"
 
+	"github.com/cloudflare/cloudflare-go"
 	"github.com/juju/errors"
 	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/control"
 	"github.com/mattolenik/cloudflare-ddns-client/ddns"
 	"github.com/mattolenik/cloudflare-ddns-client/errhandler"
+	"github.com/mattolenik/cloudflare-ddns-client/ip"
 	"github.com/mattolenik/cloudflare-ddns-client/meta"
+	"github.com/mattolenik/cloudflare-ddns-client/metrics"
+	"github.com/mattolenik/cloudflare-ddns-client/netwatch"
+	"github.com/mattolenik/cloudflare-ddns-client/propagation"
 	"github.com/mattolenik/cloudflare-ddns-client/providers"
 	"github.com/mattolenik/cloudflare-ddns-client/task"
 	"github.com/rs/zerolog"
@@ -33,15 +40,75 @@ For example:
     DOMAIN=mydomain.com RECORD=sub.mydomain.com TOKEN=<api-token> cloudflare-ddns
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		provider, err := providers.NewCloudFlareProvider(context.Background(), conf.Token.Get())
+		settings, err := conf.LoadSettings()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if servers := conf.STUNServers.Get(); len(servers) > 0 {
+			ip.STUNServers = servers
+		}
+		if sources := conf.IPSources.Get(); len(sources) > 0 {
+			if err := ip.ValidateSources(sources); err != nil {
+				return &conf.ConfigError{Err: err}
+			}
+			ip.Sources = sources
+		}
+		provider, err := providers.NewCloudFlareProvider(context.Background(), settings.Credentials, nil, cloudflareOptions()...)
 		if err != nil {
 			return errors.Annotatef(err, "failed to configure DDNS provider")
 		}
-		daemon := ddns.NewDefaultDaemon(provider, ddns.NewDefaultIPProvider(), ddns.NewDefaultConfigProvider())
+		log.Info().Msgf("Authenticating with CloudFlare using %s", settings.Credentials)
+		configProvider := ddns.NewDefaultConfigProvider()
+		configProvider.Set(settings.Records)
+		// Lookups over DNS are abandoned as soon as the daemon is told to stop, rather than holding up shutdown
+		lookups, stopLookups := context.WithCancel(cmd.Context())
+		defer stopLookups()
+		var ddnsProvider ddns.DDNSProvider = provider
+		if conf.ReadViaDNS.Get() {
+			ddnsProvider = providers.NewDNSReader(lookups, provider, propagation.NewVerifier(conf.PropagationTimeout.Get()).Lookup)
+		}
+		var ipProvider ddns.IPProvider = ddns.NewDefaultIPProvider()
+		if addr := conf.IP.Get(); addr != "" {
+			static, err := ddns.NewStaticIPProvider(addr)
+			if err != nil {
+				return &conf.ConfigError{Err: err}
+			}
+			ipProvider = static
+		}
+		daemon := ddns.NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+		daemon.SetTarget(conf.TypeIPList, providers.NewIPListTarget(provider))
+		daemon.SetTarget(conf.TypeLBOrigin, providers.NewLBOriginTarget(provider))
+		daemon.SetDamping(ddns.Damping{
+			ConfirmChecks:     conf.ConfirmChecks.Get(),
+			ConfirmDuration:   conf.ConfirmDuration.Get(),
+			MaxChangesPerHour: conf.MaxChangesPerHour.Get(),
+		})
+		if interval := conf.HeartbeatInterval.Get(); interval > 0 {
+			tmpl, err := ddns.ParseHeartbeatTemplate(conf.HeartbeatTemplate.Get())
+			if err != nil {
+				return &conf.ConfigError{Err: err}
+			}
+			daemon.SetHeartbeat(ddns.Heartbeat{Provider: provider, Interval: interval, Template: tmpl})
+		}
+		if store, err := openStore(); err != nil {
+			log.Warn().Msgf("%v, records with a force_update_interval may be rewritten sooner than needed", err)
+		} else {
+			daemon.SetStore(store)
+		}
+		if conf.Lease.Get() != "" {
+			leadership, err := newLeadership(provider)
+			if err != nil {
+				return &conf.ConfigError{Err: err}
+			}
+			daemon.SetLeadership(leadership)
+		}
+		if conf.VerifyPropagation.Get() {
+			daemon.SetVerifier(propagation.NewVerifier(conf.PropagationTimeout.Get()))
+		}
 		if conf.Daemon.Get() {
-			return errors.Trace(runDaemon(provider, daemon))
+			return errors.Trace(runDaemon(daemon, newReloader(configPath, configFiles, cmd.PersistentFlags(), settings, provider, configProvider, daemon), stopLookups))
 		}
-		return errors.Trace(daemon.Update())
+		return errors.Trace(runOnce(cmd.Context(), daemon))
 	},
 	Version: meta.Version,
 }
@@ -57,71 +124,265 @@ func init() {
 	conf.Config.BindVar(f, &conf.ConfigFile)
 	conf.Domain.Bind(f).WithDefault()
 	conf.IP.Bind(f)
+	conf.STUNServers.Bind(f)
+	conf.IPSources.Bind(f)
 	conf.Record.Bind(f).WithDefault()
 	conf.Token.Bind(f).WithDefault()
+	conf.AuthMethod.Bind(f).WithDefault()
+	conf.APIKey.Bind(f).WithDefault()
+	conf.Email.Bind(f).WithDefault()
 	conf.JSONOutput.Bind(f).WithDefault()
 	conf.Verbose.Bind(f).WithDefault()
 	conf.Daemon.Bind(f).WithDefault()
+	conf.PollInterval.Bind(f).WithDefault()
+	conf.WatchNetwork.Bind(f).WithDefault()
+	conf.FallbackInterval.Bind(f).WithDefault()
+	conf.ControlSocket.Bind(f).WithDefault()
+	conf.WatchConfig.Bind(f).WithDefault()
+	conf.ConfirmChecks.Bind(f).WithDefault()
+	conf.ConfirmDuration.Bind(f).WithDefault()
+	conf.MaxChangesPerHour.Bind(f).WithDefault()
+	conf.VerifyPropagation.Bind(f).WithDefault()
+	conf.PropagationTimeout.Bind(f).WithDefault()
+	conf.ReadViaDNS.Bind(f).WithDefault()
+	conf.HeartbeatInterval.Bind(f).WithDefault()
+	conf.HeartbeatTemplate.Bind(f).WithDefault()
+	conf.MetricsAddress.Bind(f).WithDefault()
+	conf.ExitCodeOnChange.Bind(f).WithDefault()
+	conf.StateFile.Bind(f).WithDefault()
+	conf.Lease.Bind(f).WithDefault()
+	conf.LeaseFile.Bind(f).WithDefault()
+	conf.LeaseTTL.Bind(f).WithDefault()
+	conf.InstanceID.Bind(f).WithDefault()
+	conf.CloudFlareAPIURL.Bind(f).WithDefault()
+	// Only meant for tests, anyone given it would receive the credentials
+	f.MarkHidden(conf.CloudFlareAPIURL.Name)
 	Root.SetVersionTemplate("{{.Version}}\n")
-
-	cobra.OnInitialize(initConfig)
+	Root.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		return errors.Trace(initConfig())
+	}
+	Root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &conf.ConfigError{Err: err}
+	})
 }
 
-func initConfig() {
-	viper.AutomaticEnv()
-	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+// The config file or directory in use, and what was read from it, both empty if there is none
+var (
+	configPath  string
+	configFiles *conf.ConfigFiles
+)
+
+// initEnv reads options from environment variables and sets up the log format
+func initEnv() {
+	conf.ReadEnv(viper.GetViper())
 	// TODO: use enums/string consts instead of hardcoded string "json"
 	if conf.JSONOutput.Get() != "json" {
 		writer := zerolog.ConsoleWriter{Out: os.Stderr}
 		log.Logger = log.Output(writer)
 	}
+}
+
+// setLogLevel sets the log level from the verbose option
+func setLogLevel() {
+	if conf.Verbose.Get() {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	}
+}
+
+// initConfig sets up logging and reads the config files, returning a *conf.ConfigError if they can't be read
+func initConfig() error {
+	initEnv()
 	if conf.ConfigFile != "" {
-		log.Info().Msgf("Using configuration from file '%s'", conf.ConfigFile)
-		// Use config file from the flag.
-		viper.SetConfigFile(conf.ConfigFile)
+		log.Info().Msgf("Using configuration from '%s'", conf.ConfigFile)
+		configPath = conf.ConfigFile
 	} else {
-		viper.AddConfigPath(meta.ProgramDir)
-		viper.AddConfigPath("$HOME/.config")
-		viper.AddConfigPath("/etc/")
-		viper.SetConfigName(conf.DefaultConfigFilename)
+		// Config file is optional, continue if not found, unless config was specified by user
+		configPath = conf.FindConfigFile()
+	}
+	if configPath != "" {
+		files, err := conf.LoadConfigFiles(configPath)
+		if err != nil {
+			return &conf.ConfigError{Err: errors.Annotate(err, "unable to read config file")}
+		}
+		if err := files.Apply(Root.PersistentFlags()); err != nil {
+			return &conf.ConfigError{Err: errors.Annotate(err, "unable to read config file")}
+		}
+		configFiles = files
+	}
+	setLogLevel()
+	if configFiles != nil {
+		for _, path := range configFiles.Paths {
+			log.Debug().Msgf("Read config file '%s'", path)
+		}
+	}
+	return nil
+}
+
+// cloudflareOptions returns the options for the CloudFlare API client given by flags
+func cloudflareOptions() []cloudflare.Option {
+	var opts []cloudflare.Option
+	if url := conf.CloudFlareAPIURL.Get(); url != "" {
+		opts = append(opts, cloudflare.BaseURL(url))
+	}
+	return opts
+}
+
+// openStore opens the state file given by --state-file, or the default one in the user's cache directory
+func openStore() (*ddns.FileStore, error) {
+	path := conf.StateFile.Get()
+	if path == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, errors.Annotate(err, "unable to find a directory for the state file, use --state-file")
+		}
+		path = filepath.Join(dir, conf.DefaultConfigName, "state.json")
+	}
+	store, err := ddns.OpenFileStore(path)
+	return store, errors.Trace(err)
+}
+
+// newLeadership returns how this instance coordinates with others, as given by --lease and related flags
+func newLeadership(provider *providers.CloudFlareProvider) (ddns.Leadership, error) {
+	l := ddns.Leadership{Holder: conf.InstanceID.Get(), TTL: conf.LeaseTTL.Get()}
+	if l.TTL <= 0 {
+		return l, errors.Errorf("invalid --%s '%s', it must be positive", conf.LeaseTTL.Name, l.TTL)
+	}
+	if l.Holder == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return l, errors.Annotatef(err, "unable to find hostname, use --%s", conf.InstanceID.Name)
+		}
+		l.Holder = fmt.Sprintf("%s-%d", hostname, os.Getpid())
 	}
+	switch kind := conf.Lease.Get(); kind {
+	case "txt":
+		l.Lease = &ddns.TXTLease{Provider: provider}
+	case "file":
+		path := conf.LeaseFile.Get()
+		if path == "" {
+			path = filepath.Join(os.TempDir(), conf.DefaultConfigName+".lease")
+		}
+		l.Lease = ddns.NewFileLease(path)
+	default:
+		return l, errors.Errorf("unknown --%s '%s', expected 'txt' or 'file'", conf.Lease.Name, kind)
+	}
+	return l, nil
+}
 
-	// Config file is optional, ignore errors
-	err := viper.ReadInConfig()
+// changedKey holds a *bool in the command's context, which runOnce sets if it changed any record
+type changedKey struct{}
+
+// Execute runs the program and returns its exit code, see errhandler for the codes
+func Execute() int {
+	changed := false
+	err := Root.ExecuteContext(context.WithValue(context.Background(), changedKey{}, &changed))
 	if err != nil {
-		errhandler.Handle(err)
+		errhandler.Log(err)
+		return errhandler.ExitCode(err)
 	}
-	if conf.Verbose.Get() {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	} else {
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if changed && conf.ExitCodeOnChange.Get() {
+		return errhandler.ExitChanged
+	}
+	return errhandler.ExitOK
+}
+
+// runOnce updates every record once, recording in ctx whether any were changed
+func runOnce(ctx context.Context, daemon *ddns.DDNSDaemon) error {
+	result, err := daemon.UpdateRecords()
+	for _, r := range result.Unchanged {
+		log.Info().Msgf("Record '%s' is already set to IP '%s'", r.Record, result.IP)
+	}
+	for _, r := range result.Forced {
+		log.Info().Msgf("Record '%s' was rewritten with unchanged IP '%s', its force_update_interval has passed", r.Record, result.IP)
+	}
+	for _, warning := range result.Warnings {
+		log.Warn().Msgf("%v, records with a force_update_interval may be rewritten sooner than needed", warning)
 	}
-	// Config file is optional, continue if not found, unless config was specified by user and still not found
-	_, notFound := err.(viper.ConfigFileNotFoundError)
-	if !(notFound && conf.ConfigFile == "") {
-		errhandler.Handle(err)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if changed, ok := ctx.Value(changedKey{}).(*bool); ok && len(result.Updated) > 0 {
+		*changed = true
 	}
+	return nil
 }
 
-func runDaemon(provider ddns.DDNSProvider, daemon *ddns.DDNSDaemon) error {
-	statusChan := daemon.StartWithDefaults()
-	for {
-		select {
-		case status := <-statusChan:
-			switch status.Type {
-			case task.Info:
-				log.Info().Msg(status.Message)
-			case task.Error:
-				log.Error().Msg(status.Message)
-			case task.Fatal:
-				log.Error().Msg("FATAL: " + status.Message)
-				return status.Error
-			}
-			if status.IsDone {
-				return nil
+// runDaemon runs daemon until it stops, calling onStop once it has been told to
+func runDaemon(daemon *ddns.DDNSDaemon, reloader *reloader, onStop func()) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updatePeriod := conf.PollInterval.Get()
+	if conf.WatchNetwork.Get() {
+		events, err := netwatch.Watch(ctx)
+		if err != nil {
+			return errors.Annotate(err, "unable to watch for network changes")
+		}
+		go func() {
+			for range netwatch.Debounce(ctx, events, netwatch.DefaultQuietPeriod) {
+				log.Info().Msg("Network change detected, checking for new IP")
+				daemon.Trigger()
 			}
-		default:
-			time.Sleep(1 * time.Second)
+		}()
+		updatePeriod = conf.FallbackInterval.Get()
+	}
+	stopOnSignal(ctx, daemon, onStop)
+	notifyOnCheckSignal(ctx, daemon)
+	notifyOnReloadSignal(ctx, reloader.Reload)
+	if conf.WatchConfig.Get() {
+		if err := reloader.Watch(ctx); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if socket := conf.ControlSocket.Get(); socket != "" {
+		server, err := control.Listen(socket, daemon, reloader.Reload)
+		if err != nil {
+			return errors.Trace(err)
 		}
+		defer server.Close()
+		go server.Serve()
+		log.Info().Msgf("Accepting control commands on '%s'", socket)
 	}
+	if addr := conf.MetricsAddress.Get(); addr != "" {
+		server, err := metrics.Listen(addr)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer server.Close()
+		go server.Serve()
+		go metrics.Collect(daemon.Events().Subscribe("metrics", 100, task.DropOldest))
+		log.Info().Msgf("Serving metrics on 'http://%s/debug/vars'", server.Addr())
+	}
+	// Log every event. Other consumers can subscribe to daemon.Events() independently, each with
+	// their own buffer, without being able to hold up the daemon or each other.
+	logs := daemon.Events().Subscribe("log", 100, task.DropOldest)
+	daemon.Start(updatePeriod, conf.PollInterval.Get())
+	for status := range logs.Events() {
+		status.Log(&log.Logger)
+		if status.Type == task.Fatal {
+			return status.Error
+		}
+	}
+	if dropped := logs.Dropped(); dropped > 0 {
+		log.Warn().Msgf("%d log events were dropped because logging could not keep up", dropped)
+	}
+	return nil
+}
+
+// stopOnSignal stops the daemon gracefully on SIGINT or SIGTERM, so that each record's on_shutdown action is carried out.
+// A second signal kills the program as usual, in case shutting down hangs. onStop is called after the daemon is told to stop.
+func stopOnSignal(ctx context.Context, daemon ddns.Daemon, onStop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case <-ctx.Done():
+		case sig := <-signals:
+			log.Info().Msgf("Received %s, stopping", sig)
+			daemon.Stop()
+			onStop()
+		}
+	}()
 }
@@ -0,0 +1,51 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+	"github.com/rs/zerolog/log"
+)
+
+// notifyOnCheckSignal runs an immediate check whenever SIGUSR1 is received
+func notifyOnCheckSignal(ctx context.Context, daemon ddns.Daemon) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				log.Info().Msg("Received SIGUSR1, checking for new IP")
+				daemon.Trigger()
+			}
+		}
+	}()
+}
+
+// notifyOnReloadSignal reloads the configuration whenever SIGHUP is received
+func notifyOnReloadSignal(ctx context.Context, reload func() error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				log.Info().Msg("Received SIGHUP, reloading configuration")
+				if err := reload(); err != nil {
+					log.Error().Msg(err.Error())
+				}
+			}
+		}
+	}()
+}
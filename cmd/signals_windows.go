@@ -0,0 +1,13 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+)
+
+// notifyOnCheckSignal does nothing on Windows, which has no SIGUSR1. Use the control socket instead.
+func notifyOnCheckSignal(ctx context.Context, daemon ddns.Daemon) {}
+
+// notifyOnReloadSignal does nothing on Windows, which has no SIGHUP. Use the control socket or --watch-config instead.
+func notifyOnReloadSignal(ctx context.Context, reload func() error) {}
@@ -2,23 +2,122 @@ package conf
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/mattolenik/cloudflare-ddns-client/meta"
 )
 
 var (
-	ConfigFile            string // Path to the config file, if any
-	DefaultConfigFilename = "cloudflare-ddns.toml"
+	ConfigFile        string // Path to the config file or directory, if any
+	DefaultConfigName = "cloudflare-ddns"
 
 	Config = StringOption{
 		Name:        "config",
-		Description: fmt.Sprintf("Path to config file. If not specified will look for %s in the program dir (%s), $HOME/.config, or /etc, in that order", DefaultConfigFilename, meta.ProgramDir),
+		Description: fmt.Sprintf("Path to a TOML, YAML or JSON config file, or a directory of them. If not specified will look for %s.toml, .yaml, .yml or .json in the program dir (%s), $HOME/.config, or /etc, in that order", DefaultConfigName, meta.ProgramDir),
 	}
 	Daemon = BoolOption{
 		Name:        "daemon",
 		Default:     false,
 		Description: "Run as a service, continually monitoring for IP changes",
 	}
+	PollInterval = DurationOption{
+		Name:        "poll-interval",
+		Default:     10 * time.Second,
+		Description: "How often to check for IP changes when running as a daemon",
+	}
+	WatchNetwork = BoolOption{
+		Name:        "watch-network",
+		Default:     false,
+		Description: "Linux only. Check for IP changes as soon as network addresses or routes change, polling only every --fallback-interval",
+	}
+	FallbackInterval = DurationOption{
+		Name:        "fallback-interval",
+		Default:     30 * time.Minute,
+		Description: "How often to poll for IP changes when --watch-network is enabled",
+	}
+	ControlSocket = StringOption{
+		Name:        "control-socket",
+		Description: "Path of a Unix domain socket for controlling a running daemon, disabled if not set",
+	}
+	WatchConfig = BoolOption{
+		Name:        "watch-config",
+		Default:     false,
+		Description: "Reload the config file when it changes while running as a daemon, it is always reloaded on SIGHUP",
+	}
+	ConfirmChecks = IntOption{
+		Name:        "confirm-checks",
+		Default:     0,
+		Description: "Only update DNS once a new IP has been seen on this many consecutive checks, 0 or 1 updates right away",
+	}
+	ConfirmDuration = DurationOption{
+		Name:        "confirm-duration",
+		Default:     0,
+		Description: "Only update DNS once a new IP has been stable for this long, also satisfied by --confirm-checks if set",
+	}
+	MaxChangesPerHour = IntOption{
+		Name:        "max-changes-per-hour",
+		Default:     0,
+		Description: "Hold back further IP changes once this many have been made in the last hour, 0 for no limit",
+	}
+	VerifyPropagation = BoolOption{
+		Name:        "verify-propagation",
+		Default:     false,
+		Description: "After updating a record, query the zone's authoritative nameservers until they all serve the new IP",
+	}
+	PropagationTimeout = DurationOption{
+		Name:        "propagation-timeout",
+		Default:     2 * time.Minute,
+		Description: "How long to wait for an update to reach the authoritative nameservers with --verify-propagation",
+	}
+	ReadViaDNS = BoolOption{
+		Name:        "read-via-dns",
+		Default:     false,
+		Description: "Read current record values from the zone's authoritative nameservers, only using the CloudFlare API to update them or if DNS fails",
+	}
+	HeartbeatInterval = DurationOption{
+		Name:        "heartbeat-interval",
+		Default:     0,
+		Description: "How often to write a heartbeat TXT record named _ddns.<record> next to each record, disabled if 0",
+	}
+	HeartbeatTemplate = StringOption{
+		Name:        "heartbeat-template",
+		Default:     "host={{.Hostname}} version={{.Version}} checked={{.Timestamp}} source={{.Source}}",
+		Description: "Go template for the content of heartbeat TXT records, fields are Hostname, Version, Timestamp, Time, Domain, Record, IP and Source",
+	}
+	MetricsAddress = StringOption{
+		Name:        "metrics-address",
+		Description: "Serve daemon metrics over HTTP at /debug/vars on this address, e.g. localhost:9090, disabled if not set",
+	}
+	ExitCodeOnChange = BoolOption{
+		Name:        "exit-code-on-change",
+		Default:     false,
+		Description: "When not running as a daemon, exit with code 6 instead of 0 if any record was changed",
+	}
+	Lease = StringOption{
+		Name:        "lease",
+		Description: "Coordinate with other instances so that only the holder of a lease updates each record: 'txt' for a TXT record named _ddns-lease.<record>, or 'file' for --lease-file on the same host. Disabled if not set",
+	}
+	LeaseFile = StringOption{
+		Name:        "lease-file",
+		Description: "File shared by instances on the same host with --lease file (default cloudflare-ddns.lease in the temp directory)",
+	}
+	LeaseTTL = DurationOption{
+		Name:        "lease-ttl",
+		Default:     time.Minute,
+		Description: "How long a lease lasts without being renewed, a standby instance takes over once it expires",
+	}
+	InstanceID = StringOption{
+		Name:        "instance-id",
+		Description: "Identifies this instance in leases, it must be different for every instance (default <hostname>-<pid>)",
+	}
+	StateFile = StringOption{
+		Name:        "state-file",
+		Description: "File to remember when records with a force_update_interval were last written (default cloudflare-ddns/state.json in the user's cache directory)",
+	}
+	CloudFlareAPIURL = StringOption{
+		Name:        "cloudflare-api-url",
+		Description: "Base URL of the CloudFlare API, only for testing against a fake server",
+	}
 	Domain = StringOption{
 		Name:        "domain",
 		Description: "Domain name in CloudFlare, e.g. example.com",
@@ -27,6 +126,14 @@ var (
 		Name:        "ip",
 		Description: "An already known WAN IP, will not perform lookup",
 	}
+	STUNServers = StringSliceOption{
+		Name:        "stun-servers",
+		Description: "STUN servers (host:port) to ask for the public IP when DNS and HTTP lookups fail, comma separated",
+	}
+	IPSources = StringSliceOption{
+		Name:        "ip-sources",
+		Description: "Where to look for the public IP, tried in order, comma separated. Any of dns, http, stun, nat-pmp (also tries PCP), upnp. Defaults to dns,http,stun",
+	}
 	Record = StringOption{
 		Name:        "record",
 		Description: "DNS record name in CloudFlare, may be subdomain or same as domain",
@@ -35,6 +142,19 @@ var (
 		Name:        "token",
 		Description: "CloudFlare API token with permissions Zone:Zone:Read and Zone:DNS:Edit",
 	}
+	AuthMethod = StringOption{
+		Name:        "auth-method",
+		Default:     AuthToken,
+		Description: "How to authenticate with CloudFlare, either token, or key to use a legacy Global API Key given by --api-key and --email",
+	}
+	APIKey = StringOption{
+		Name:        "api-key",
+		Description: "CloudFlare Global API Key, only used with --auth-method key",
+	}
+	Email = StringOption{
+		Name:        "email",
+		Description: "Email address of the CloudFlare account that owns the Global API Key, only used with --auth-method key",
+	}
 	JSONOutput = StringOption{
 		Name:        "log-format",
 		Default:     "pretty",
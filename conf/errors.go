@@ -0,0 +1,14 @@
+package conf
+
+// ConfigError means the configuration, from flags, environment variables or the config file, is invalid
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration: " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
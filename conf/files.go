@@ -0,0 +1,291 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/meta"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// IncludeKey is the config file key listing further files, directories or glob patterns to read.
+// Relative paths are relative to the directory of the file that includes them.
+const IncludeKey = "include"
+
+// ConfigExts are the supported config file formats, chosen by file extension
+var ConfigExts = []string{"toml", "yaml", "yml", "json"}
+
+// IsConfigFile returns true if path has the extension of a supported config file format
+func IsConfigFile(path string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	for _, supported := range ConfigExts {
+		if strings.EqualFold(ext, supported) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindConfigFile returns the first config file named DefaultConfigName, in any supported format,
+// in the program dir, $HOME/.config or /etc, or "" if there is none
+func FindConfigFile() string {
+	dirs := []string{meta.ProgramDir}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".config"))
+	}
+	dirs = append(dirs, "/etc")
+	for _, dir := range dirs {
+		for _, ext := range ConfigExts {
+			path := filepath.Join(dir, DefaultConfigName+"."+ext)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// ConfigFiles is the merged content of a config file, or a directory of them, and every file they include
+type ConfigFiles struct {
+	// Paths of every file that was read, in the order they were merged
+	Paths []string
+	// Sources are the directories and glob patterns that files were found with, so that files added later can be picked up
+	Sources []string
+	values  map[string]interface{}
+}
+
+// LoadConfigFiles reads the config file at path, or every config file in it if it is a directory, followed by any files
+// they include. Settings in later files override those in earlier ones, except for records, which are combined into
+// one list. A record defined in more than one file is an error. Nothing is applied to viper until Apply is called.
+func LoadConfigFiles(path string) (*ConfigFiles, error) {
+	c := &ConfigFiles{}
+	queue, err := c.expand(path, true)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	merged := viper.New()
+	var records []interface{}
+	definedIn := map[string]string{}
+	read := map[string]bool{}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		if read[file] {
+			continue
+		}
+		read[file] = true
+		c.Paths = append(c.Paths, file)
+
+		v := viper.New()
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, errors.Annotatef(err, "unable to read config file '%s'", file)
+		}
+		var fileRecords []RecordConfig
+		if err := v.UnmarshalKey(RecordsKey, &fileRecords); err != nil {
+			return nil, errors.Annotatef(err, "unable to read '%s' from config file '%s'", RecordsKey, file)
+		}
+		for _, r := range fileRecords {
+			// Duplicates within one file are reported by ValidateRecords
+			if other, ok := definedIn[r.Key()]; ok && other != file {
+				return nil, errors.Errorf("record '%s' is defined in both '%s' and '%s'", r.Record, other, file)
+			}
+			definedIn[r.Key()] = file
+		}
+		for _, include := range v.GetStringSlice(IncludeKey) {
+			if !filepath.IsAbs(include) {
+				include = filepath.Join(filepath.Dir(file), include)
+			}
+			included, err := c.expand(include, false)
+			if err != nil {
+				return nil, errors.Annotatef(err, "invalid %s in config file '%s'", IncludeKey, file)
+			}
+			queue = append(queue, included...)
+		}
+
+		settings := v.AllSettings()
+		records = append(records, list(settings[RecordsKey])...)
+		delete(settings, RecordsKey)
+		delete(settings, IncludeKey)
+		if err := merged.MergeConfigMap(settings); err != nil {
+			return nil, errors.Annotatef(err, "unable to merge config file '%s'", file)
+		}
+	}
+	c.values = merged.AllSettings()
+	if len(records) > 0 {
+		c.values[RecordsKey] = records
+	}
+	return c, nil
+}
+
+// Viper returns a new viper instance holding the content of the files, with flags and environment variables
+// taking precedence over it. It is independent of the configuration in use, so it can be checked before Use.
+func (c *ConfigFiles) Viper(flags *pflag.FlagSet) (*viper.Viper, error) {
+	v := viper.New()
+	ReadEnv(v)
+	if flags != nil {
+		if err := v.BindPFlags(flags); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if err := v.MergeConfigMap(c.values); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return v, nil
+}
+
+// Apply makes the content of the files, under flags and environment variables, the configuration in use
+func (c *ConfigFiles) Apply(flags *pflag.FlagSet) error {
+	v, err := c.Viper(flags)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	Use(v)
+	return nil
+}
+
+// Includes returns true if the file at path is, or would be, read when the files are loaded again
+func (c *ConfigFiles) Includes(path string) bool {
+	path = filepath.Clean(path)
+	for _, p := range c.Paths {
+		if p == path {
+			return true
+		}
+	}
+	if !IsConfigFile(path) {
+		return false
+	}
+	for _, source := range c.Sources {
+		if !strings.ContainsAny(source, "*?[") && source == filepath.Dir(path) {
+			return true
+		}
+		if ok, _ := filepath.Match(source, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Dirs returns every directory holding a file that was read, or that a source looks in
+func (c *ConfigFiles) Dirs() []string {
+	seen := map[string]bool{}
+	var dirs []string
+	add := func(dir string) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, p := range c.Paths {
+		add(filepath.Dir(p))
+	}
+	for _, source := range c.Sources {
+		if info, err := os.Stat(source); err == nil && info.IsDir() {
+			add(source)
+		} else {
+			add(filepath.Dir(source))
+		}
+	}
+	return dirs
+}
+
+// expand returns the config files at path, which may be a file, a directory of config files, or a glob pattern.
+// Files in a directory or matching a pattern are returned in lexical order, and only if they have a supported extension.
+// A path that matches nothing is an error, unless it is a pattern.
+func (c *ConfigFiles) expand(path string, explicit bool) ([]string, error) {
+	path = filepath.Clean(path)
+	if info, err := os.Stat(path); err == nil {
+		if !info.IsDir() {
+			return []string{path}, nil
+		}
+		c.Sources = append(c.Sources, path)
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to read config directory '%s'", path)
+		}
+		var files []string
+		for _, entry := range entries {
+			if !entry.IsDir() && IsConfigFile(entry.Name()) {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+		return files, nil
+	}
+	if explicit || !strings.ContainsAny(path, "*?[") {
+		return nil, errors.Errorf("config file '%s' does not exist", path)
+	}
+	c.Sources = append(c.Sources, path)
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid pattern '%s'", path)
+	}
+	sort.Strings(matches)
+	var files []string
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && !info.IsDir() && IsConfigFile(match) {
+			files = append(files, match)
+		}
+	}
+	return files, nil
+}
+
+// list returns value as a list, whatever type of list the config file format decoded it as
+func list(value interface{}) []interface{} {
+	switch l := value.(type) {
+	case []interface{}:
+		return l
+	case []map[string]interface{}:
+		items := make([]interface{}, len(l))
+		for i, item := range l {
+			items[i] = item
+		}
+		return items
+	default:
+		return nil
+	}
+}
+
+// WriteConfigFile validates settings and writes them to a new config file at path, in the format given by its
+// extension. The file is only readable by its owner, since it holds credentials. An existing file is replaced.
+func WriteConfigFile(path string, settings *Settings) error {
+	if err := settings.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	if !IsConfigFile(path) {
+		return errors.Errorf("config file '%s' must have one of the extensions %s", path, strings.Join(ConfigExts, ", "))
+	}
+	v := viper.New()
+	v.SetConfigPermissions(0600)
+	if settings.Credentials.Method == AuthKey {
+		v.Set(AuthMethod.Name, AuthKey)
+		v.Set(APIKey.Name, settings.Credentials.Key)
+		v.Set(Email.Name, settings.Credentials.Email)
+	} else {
+		v.Set(Token.Name, settings.Credentials.Token)
+	}
+	records := make([]map[string]interface{}, len(settings.Records))
+	for i, r := range settings.Records {
+		records[i] = r.toMap()
+	}
+	v.Set(RecordsKey, records)
+
+	// Write a temporary file next to the final one and move it into place, so that the file is either
+	// complete or not there at all, and never readable by others while being replaced
+	ext := filepath.Ext(path)
+	tmp := filepath.Join(filepath.Dir(path), "."+strings.TrimSuffix(filepath.Base(path), ext)+".new"+ext)
+	// Permissions are only set when a file is created, so don't reuse one left behind
+	os.Remove(tmp)
+	if err := v.WriteConfigAs(tmp); err != nil {
+		os.Remove(tmp)
+		return errors.Annotatef(err, "unable to write config file '%s'", path)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return errors.Annotatef(err, "unable to write config file '%s'", path)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func TestLoadConfigFilesFormats(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.toml": "token = \"abc\"\n[[records]]\ndomain = \"example.com\"\nrecord = \"a.example.com\"\n",
+		"config.yaml": "token: abc\nrecords:\n  - domain: example.com\n    record: a.example.com\n",
+		"config.json": `{"token": "abc", "records": [{"domain": "example.com", "record": "a.example.com"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			writeFile(t, path, content)
+			files, err := LoadConfigFiles(path)
+			require.NoError(t, err)
+			require.NoError(t, files.Apply(nil))
+			defer Use(viper.GetViper())
+
+			records, err := Records()
+			require.NoError(t, err)
+			assert.Equal(t, []RecordConfig{{Domain: "example.com", Record: "a.example.com"}}, records)
+			assert.Equal(t, "abc", V().GetString("token"))
+		})
+	}
+}
+
+func TestLoadConfigFilesIncludes(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	main := filepath.Join(dir, "cloudflare-ddns.toml")
+	writeFile(t, main, "token = \"abc\"\ninclude = [\"conf.d\", \"extra/*.json\"]\n[[records]]\ndomain = \"example.com\"\nrecord = \"example.com\"\n")
+	writeFile(t, filepath.Join(dir, "conf.d", "b.yaml"), "records:\n  - domain: example.com\n    record: b.example.com\n")
+	writeFile(t, filepath.Join(dir, "conf.d", "a.toml"), "token = \"def\"\n[[records]]\ndomain = \"example.com\"\nrecord = \"a.example.com\"\n")
+	writeFile(t, filepath.Join(dir, "conf.d", "notes.txt"), "not a config file")
+	writeFile(t, filepath.Join(dir, "extra", "c.json"), `{"records": [{"domain": "example.org", "record": "c.example.org"}]}`)
+
+	files, err := LoadConfigFiles(main)
+	require.NoError(t, err)
+	require.NoError(t, files.Apply(nil))
+	defer Use(viper.GetViper())
+
+	assert.Equal([]string{
+		main,
+		filepath.Join(dir, "conf.d", "a.toml"),
+		filepath.Join(dir, "conf.d", "b.yaml"),
+		filepath.Join(dir, "extra", "c.json"),
+	}, files.Paths)
+	records, err := Records()
+	require.NoError(t, err)
+	var names []string
+	for _, r := range records {
+		names = append(names, r.Record)
+	}
+	assert.Equal([]string{"example.com", "a.example.com", "b.example.com", "c.example.org"}, names, "records from every file must be combined in order")
+	assert.Equal("def", V().GetString("token"), "later files must override other settings")
+
+	assert.True(files.Includes(filepath.Join(dir, "conf.d", "new.yml")), "new files in an included directory must be picked up")
+	assert.True(files.Includes(filepath.Join(dir, "extra", "new.json")))
+	assert.False(files.Includes(filepath.Join(dir, "extra", "new.toml")), "files not matching the pattern must be ignored")
+	assert.False(files.Includes(filepath.Join(dir, "conf.d", "notes.txt")))
+	assert.ElementsMatch([]string{dir, filepath.Join(dir, "conf.d"), filepath.Join(dir, "extra")}, files.Dirs())
+}
+
+func TestLoadConfigFilesDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "1.toml"), "[[records]]\ndomain = \"example.com\"\nrecord = \"a.example.com\"\n")
+	writeFile(t, filepath.Join(dir, "2.yml"), "records:\n  - domain: example.com\n    record: b.example.com\n")
+	files, err := LoadConfigFiles(dir)
+	require.NoError(t, err)
+	assert.Len(t, files.Paths, 2)
+}
+
+func TestLoadConfigFilesErrors(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.toml"), "[[records]]\ndomain = \"example.com\"\nrecord = \"a.example.com\"\n")
+	writeFile(t, filepath.Join(dir, "b.yaml"), "records:\n  - domain: example.com\n    record: a.example.com\n")
+	_, err := LoadConfigFiles(dir)
+	if assert.Error(err, "expected a record defined in two files to be an error") {
+		assert.Contains(err.Error(), "a.toml")
+		assert.Contains(err.Error(), "b.yaml")
+	}
+
+	_, err = LoadConfigFiles(filepath.Join(dir, "missing.toml"))
+	assert.Error(err, "expected a missing config file to be an error")
+
+	main := filepath.Join(t.TempDir(), "main.toml")
+	writeFile(t, main, "include = [\"missing.toml\"]\n")
+	_, err = LoadConfigFiles(main)
+	assert.Error(err, "expected a missing included file to be an error")
+
+	writeFile(t, main, "include = [\"conf.d/*.toml\", \"main.toml\"]\n")
+	_, err = LoadConfigFiles(main)
+	assert.NoError(err, "a pattern matching nothing, or a file including itself, must not be an error")
+}
+
+func TestWriteConfigFile(t *testing.T) {
+	assert := assert.New(t)
+	settings := &Settings{
+		Credentials: Credentials{Token: "abc"},
+		Records: []RecordConfig{
+			{Domain: "example.com", Record: "a.example.com", Allow: []string{"10.0.0.0/8"}, OnShutdown: ShutdownFallback, Fallback: "192.0.2.10", FallbackAfter: 10 * time.Minute},
+			{Domain: "example.com", Record: "b.example.com", Interval: 5 * time.Minute, Windows: []string{"Mon-Fri 09:00-17:00"}},
+			{Domain: "example.com", Record: "c.example.com", Cron: "@hourly", ForceUpdateInterval: 24 * time.Hour},
+		},
+	}
+	for _, ext := range ConfigExts {
+		path := filepath.Join(t.TempDir(), "config."+ext)
+		require.NoError(t, os.WriteFile(path, []byte("old"), 0644))
+		require.NoError(t, WriteConfigFile(path, settings))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(os.FileMode(0600), info.Mode().Perm(), "replaced files must only be readable by their owner too")
+
+		files, err := LoadConfigFiles(path)
+		require.NoError(t, err)
+		require.NoError(t, files.Apply(nil))
+		records, err := Records()
+		require.NoError(t, err)
+		assert.Equal(settings.Records, records, "records must survive a round trip through %s", ext)
+		assert.Equal("abc", V().GetString(Token.Name))
+		Use(viper.GetViper())
+	}
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	assert.Error(WriteConfigFile(path, &Settings{Credentials: Credentials{Token: "abc"}}), "expected invalid settings not to be written")
+	assert.NoFileExists(path)
+	assert.Error(WriteConfigFile(filepath.Join(t.TempDir(), "config.ini"), settings), "expected unsupported format to be an error")
+}
@@ -1,10 +1,38 @@
 package conf
 
 import (
+	"strings"
+	"sync/atomic"
+	"time"
+
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
+// config is the viper instance that options are read from. It is replaced as a whole by Use rather than
+// changed, so that the configuration can be reloaded while other goroutines read it.
+var config atomic.Pointer[viper.Viper]
+
+func init() {
+	config.Store(viper.GetViper())
+}
+
+// V returns the viper instance holding the configuration in use, the global one until Use is called
+func V() *viper.Viper {
+	return config.Load()
+}
+
+// Use makes v the configuration in use. v must not be changed afterwards.
+func Use(v *viper.Viper) {
+	config.Store(v)
+}
+
+// ReadEnv makes v read options from environment variables of the same name, with - replaced by _
+func ReadEnv(v *viper.Viper) {
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+}
+
 type StringOption struct {
 	Name        string
 	Description string
@@ -13,7 +41,7 @@ type StringOption struct {
 }
 
 func (o *StringOption) Get() string {
-	return viper.GetString(o.Name)
+	return V().GetString(o.Name)
 }
 
 func (o *StringOption) Bind(flags *pflag.FlagSet) *StringOption {
@@ -47,7 +75,7 @@ type StringOptionP struct {
 }
 
 func (o *StringOptionP) Get() string {
-	return viper.GetString(o.Name)
+	return V().GetString(o.Name)
 }
 
 func (o *StringOptionP) Bind(flags *pflag.FlagSet) *StringOptionP {
@@ -80,7 +108,7 @@ type BoolOption struct {
 }
 
 func (o *BoolOption) Get() bool {
-	return viper.GetBool(o.Name)
+	return V().GetBool(o.Name)
 }
 
 func (o *BoolOption) Bind(flags *pflag.FlagSet) *BoolOption {
@@ -114,7 +142,7 @@ type BoolOptionP struct {
 }
 
 func (o *BoolOptionP) Get() bool {
-	return viper.GetBool(o.Name)
+	return V().GetBool(o.Name)
 }
 
 func (o *BoolOptionP) Bind(flags *pflag.FlagSet) *BoolOptionP {
@@ -138,3 +166,102 @@ func (o *BoolOptionP) WithDefault() *BoolOptionP {
 	viper.SetDefault(o.Name, o.Default)
 	return o
 }
+
+type DurationOption struct {
+	Name        string
+	Description string
+	Default     time.Duration
+	flags       *pflag.FlagSet
+}
+
+func (o *DurationOption) Get() time.Duration {
+	return V().GetDuration(o.Name)
+}
+
+func (o *DurationOption) Bind(flags *pflag.FlagSet) *DurationOption {
+	o.flags = flags
+	flags.Duration(o.Name, o.Default, o.Description)
+	viper.BindPFlag(o.Name, o.flags.Lookup(o.Name))
+	return o
+}
+
+func (o *DurationOption) BindVar(flags *pflag.FlagSet, v *time.Duration) *DurationOption {
+	o.flags = flags
+	flags.DurationVar(v, o.Name, o.Default, o.Description)
+	viper.BindPFlag(o.Name, o.flags.Lookup(o.Name))
+	return o
+}
+
+func (o *DurationOption) WithDefault() *DurationOption {
+	if o.flags == nil {
+		panic("Must call Bind or BindVar before WithDefault")
+	}
+	viper.SetDefault(o.Name, o.Default)
+	return o
+}
+
+type StringSliceOption struct {
+	Name        string
+	Description string
+	Default     []string
+	flags       *pflag.FlagSet
+}
+
+func (o *StringSliceOption) Get() []string {
+	return V().GetStringSlice(o.Name)
+}
+
+func (o *StringSliceOption) Bind(flags *pflag.FlagSet) *StringSliceOption {
+	o.flags = flags
+	flags.StringSlice(o.Name, o.Default, o.Description)
+	viper.BindPFlag(o.Name, o.flags.Lookup(o.Name))
+	return o
+}
+
+func (o *StringSliceOption) BindVar(flags *pflag.FlagSet, v *[]string) *StringSliceOption {
+	o.flags = flags
+	flags.StringSliceVar(v, o.Name, o.Default, o.Description)
+	viper.BindPFlag(o.Name, o.flags.Lookup(o.Name))
+	return o
+}
+
+func (o *StringSliceOption) WithDefault() *StringSliceOption {
+	if o.flags == nil {
+		panic("Must call Bind or BindVar before WithDefault")
+	}
+	viper.SetDefault(o.Name, o.Default)
+	return o
+}
+
+type IntOption struct {
+	Name        string
+	Description string
+	Default     int
+	flags       *pflag.FlagSet
+}
+
+func (o *IntOption) Get() int {
+	return V().GetInt(o.Name)
+}
+
+func (o *IntOption) Bind(flags *pflag.FlagSet) *IntOption {
+	o.flags = flags
+	flags.Int(o.Name, o.Default, o.Description)
+	viper.BindPFlag(o.Name, o.flags.Lookup(o.Name))
+	return o
+}
+
+func (o *IntOption) BindVar(flags *pflag.FlagSet, v *int) *IntOption {
+	o.flags = flags
+	flags.IntVar(v, o.Name, o.Default, o.Description)
+	viper.BindPFlag(o.Name, o.flags.Lookup(o.Name))
+	return o
+}
+
+func (o *IntOption) WithDefault() *IntOption {
+	if o.flags == nil {
+		panic("Must call Bind or BindVar before WithDefault")
+	}
+	viper.SetDefault(o.Name, o.Default)
+	return o
+}
@@ -0,0 +1,319 @@
+package conf
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/ip"
+	"github.com/mattolenik/cloudflare-ddns-client/schedule"
+	"github.com/spf13/viper"
+)
+
+// RecordsKey is the config file key holding the list of records to manage
+const RecordsKey = "records"
+
+// Types of records, i.e. what is kept pointed at the public IP
+const (
+	TypeDNS      = "dns"
+	TypeIPList   = "ip_list"
+	TypeLBOrigin = "lb_origin"
+)
+
+// RecordConfig is a single DNS record, or other target selected by Type, kept up to date by this program
+type RecordConfig struct {
+	// Type is TypeDNS if empty
+	Type   string `mapstructure:"type"`
+	Domain string `mapstructure:"domain"`
+	// Record is the DNS record name, or for other types the name of this host's entry,
+	// i.e. the comment of an IP list item or the name of a load balancer origin
+	Record string `mapstructure:"record"`
+	// Account is the CloudFlare account ID that owns account level targets such as IP lists
+	Account string `mapstructure:"account"`
+	// List is the name of the IP list for TypeIPList
+	List string `mapstructure:"list"`
+	// Pool is the name of the load balancer pool for TypeLBOrigin
+	Pool string `mapstructure:"pool"`
+	// Allow and Deny are CIDRs limiting which addresses may be published to the record, see ip.Policy
+	Allow []string `mapstructure:"allow"`
+	Deny  []string `mapstructure:"deny"`
+	// Interval, Cron and Windows say when the record is checked, see Schedule
+	Interval time.Duration `mapstructure:"interval"`
+	Cron     string        `mapstructure:"cron"`
+	Windows  []string      `mapstructure:"windows"`
+	// ForceUpdateInterval is how often the record is rewritten even if it already points at the IP, never if 0
+	ForceUpdateInterval time.Duration `mapstructure:"force_update_interval"`
+	// OnShutdown is what happens to the record when the daemon stops gracefully, one of the ShutdownActions
+	OnShutdown string `mapstructure:"on_shutdown"`
+	// Fallback is the address the record is pointed at once no public IP has been found for FallbackAfter,
+	// and on shutdown if OnShutdown is ShutdownFallback
+	Fallback      string        `mapstructure:"fallback"`
+	FallbackAfter time.Duration `mapstructure:"fallback_after"`
+}
+
+// What can happen to a record when the daemon stops, see RecordConfig.OnShutdown
+const (
+	ShutdownKeep     = "keep"
+	ShutdownDelete   = "delete"
+	ShutdownFallback = "fallback"
+)
+
+// Key uniquely identifies the record
+func (r RecordConfig) Key() string {
+	switch r.Type {
+	case TypeIPList:
+		return r.Record + "@" + r.Type + ":" + r.Account + "/" + r.List
+	case TypeLBOrigin:
+		return r.Record + "@" + r.Type + ":" + r.Account + "/" + r.Pool
+	default:
+		return r.Record + "@" + r.Domain
+	}
+}
+
+// toMap returns the record as it is written in a config file, leaving out empty fields
+func (r RecordConfig) toMap() map[string]interface{} {
+	m := map[string]interface{}{}
+	for key, value := range map[string]string{"type": r.Type, "domain": r.Domain, "record": r.Record, "account": r.Account, "list": r.List, "pool": r.Pool} {
+		if value != "" {
+			m[key] = value
+		}
+	}
+	if len(r.Allow) > 0 {
+		m["allow"] = r.Allow
+	}
+	if len(r.Deny) > 0 {
+		m["deny"] = r.Deny
+	}
+	if r.Interval != 0 {
+		m["interval"] = r.Interval.String()
+	}
+	if r.Cron != "" {
+		m["cron"] = r.Cron
+	}
+	if len(r.Windows) > 0 {
+		m["windows"] = r.Windows
+	}
+	if r.ForceUpdateInterval != 0 {
+		m["force_update_interval"] = r.ForceUpdateInterval.String()
+	}
+	if r.OnShutdown != "" {
+		m["on_shutdown"] = r.OnShutdown
+	}
+	if r.Fallback != "" {
+		m["fallback"] = r.Fallback
+	}
+	if r.FallbackAfter != 0 {
+		m["fallback_after"] = r.FallbackAfter.String()
+	}
+	return m
+}
+
+// IsDNS returns true if the record is a DNS record rather than another type of target
+func (r RecordConfig) IsDNS() bool {
+	return r.Type == "" || r.Type == TypeDNS
+}
+
+// Validate checks that the record is complete and that it belongs to its domain
+func (r RecordConfig) Validate() error {
+	if _, err := r.Policy(); err != nil {
+		return errors.Annotatef(err, "record '%s'", r.Record)
+	}
+	if _, err := r.Schedule(time.Minute); err != nil {
+		return errors.Annotatef(err, "record '%s'", r.Record)
+	}
+	if r.ForceUpdateInterval < 0 {
+		return errors.Errorf("record '%s' has a negative force_update_interval '%s'", r.Record, r.ForceUpdateInterval)
+	}
+	if err := r.validateLifecycle(); err != nil {
+		return errors.Annotatef(err, "record '%s'", r.Record)
+	}
+	switch r.Type {
+	case "", TypeDNS:
+		return errors.Trace(r.validateDNS())
+	case TypeIPList:
+		if r.Record == "" {
+			return errors.Errorf("%s entry in list '%s' has no record, which names the item by its comment", r.Type, r.List)
+		}
+		if r.Account == "" || r.List == "" {
+			return errors.Errorf("%s entry '%s' needs both an account and a list", r.Type, r.Record)
+		}
+		return nil
+	case TypeLBOrigin:
+		if r.Record == "" {
+			return errors.Errorf("%s entry in pool '%s' has no record, which names the origin", r.Type, r.Pool)
+		}
+		if r.Account == "" || r.Pool == "" {
+			return errors.Errorf("%s entry '%s' needs both an account and a pool", r.Type, r.Record)
+		}
+		return nil
+	default:
+		return errors.Errorf("record '%s' has unknown type '%s'", r.Record, r.Type)
+	}
+}
+
+func (r RecordConfig) validateDNS() error {
+	if r.Domain == "" {
+		return errors.Errorf("record '%s' has no domain", r.Record)
+	}
+	if r.Record == "" {
+		return errors.Errorf("record for domain '%s' has no name", r.Domain)
+	}
+	if r.Record != r.Domain && !strings.HasSuffix(r.Record, "."+r.Domain) {
+		return errors.Errorf("record '%s' is not within domain '%s', it must be a full name such as 'sub.%s'", r.Record, r.Domain, r.Domain)
+	}
+	return nil
+}
+
+// validateLifecycle checks the settings for what happens to the record on shutdown or when no public IP is found
+func (r RecordConfig) validateLifecycle() error {
+	if r.Fallback != "" && net.ParseIP(r.Fallback) == nil {
+		return errors.Errorf("invalid fallback address '%s'", r.Fallback)
+	}
+	if r.FallbackAfter < 0 {
+		return errors.Errorf("fallback_after '%s' must not be negative", r.FallbackAfter)
+	}
+	if r.FallbackAfter > 0 && r.Fallback == "" {
+		return errors.New("fallback_after needs a fallback address")
+	}
+	switch r.OnShutdown {
+	case "", ShutdownKeep:
+	case ShutdownDelete:
+		if !r.IsDNS() {
+			return errors.Errorf("on_shutdown '%s' is only supported for DNS records", r.OnShutdown)
+		}
+	case ShutdownFallback:
+		if r.Fallback == "" {
+			return errors.Errorf("on_shutdown '%s' needs a fallback address", r.OnShutdown)
+		}
+	default:
+		return errors.Errorf("unknown on_shutdown '%s', expected one of %s, %s or %s", r.OnShutdown, ShutdownKeep, ShutdownDelete, ShutdownFallback)
+	}
+	return nil
+}
+
+// Schedule returns when the record is checked: at the times given by Cron, or else every Interval, or every
+// defaultInterval if it is not set, limited to the Windows if there are any
+func (r RecordConfig) Schedule(defaultInterval time.Duration) (schedule.Schedule, error) {
+	return schedule.New(r.Interval, r.Cron, r.Windows, defaultInterval)
+}
+
+// Policy returns the rules for which addresses may be published to the record
+func (r RecordConfig) Policy() (ip.Policy, error) {
+	return ip.NewPolicy(r.Allow, r.Deny)
+}
+
+// Ways of authenticating with CloudFlare
+const (
+	AuthToken = "token"
+	AuthKey   = "key"
+)
+
+// Credentials authenticate with CloudFlare using either an API token, or a legacy Global API Key and the email of its account
+type Credentials struct {
+	// Method is AuthToken if empty
+	Method string
+	Token  string
+	Key    string
+	Email  string
+}
+
+// Validate checks that everything needed by the auth method is present
+func (c Credentials) Validate() error {
+	switch c.Method {
+	case "", AuthToken:
+		if c.Token == "" {
+			return errors.New("missing CloudFlare API token")
+		}
+	case AuthKey:
+		if c.Key == "" || c.Email == "" {
+			return errors.Errorf("auth method '%s' needs both a Global API Key and the email of its account", AuthKey)
+		}
+	default:
+		return errors.Errorf("unknown auth method '%s', expected '%s' or '%s'", c.Method, AuthToken, AuthKey)
+	}
+	return nil
+}
+
+// String describes the auth method without revealing any secrets
+func (c Credentials) String() string {
+	if c.Method == AuthKey {
+		return "Global API Key of '" + c.Email + "'"
+	}
+	return "API token"
+}
+
+// Settings is a complete snapshot of the configuration needed to run
+type Settings struct {
+	Credentials Credentials
+	Records     []RecordConfig
+}
+
+// Validate checks that the settings are complete and consistent
+func (s *Settings) Validate() error {
+	if err := s.Credentials.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(ValidateRecords(s.Records))
+}
+
+// ValidateRecords checks that there is at least one record, that each is valid, and that none are duplicated
+func ValidateRecords(records []RecordConfig) error {
+	if len(records) == 0 {
+		return errors.New("no records configured, specify a domain and record")
+	}
+	seen := map[string]bool{}
+	for _, r := range records {
+		if err := r.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+		if seen[r.Key()] {
+			return errors.Errorf("record '%s' is defined more than once", r.Record)
+		}
+		seen[r.Key()] = true
+	}
+	return nil
+}
+
+// Records returns all configured records. The record given by the domain and record options,
+// if any, comes first, followed by any defined in the records list of the config file.
+func Records() ([]RecordConfig, error) {
+	return records(V())
+}
+
+func records(v *viper.Viper) ([]RecordConfig, error) {
+	var records []RecordConfig
+	if err := v.UnmarshalKey(RecordsKey, &records); err != nil {
+		return nil, errors.Annotatef(err, "unable to read '%s' from configuration", RecordsKey)
+	}
+	if domain, record := v.GetString(Domain.Name), v.GetString(Record.Name); domain != "" || record != "" {
+		records = append([]RecordConfig{{Domain: domain, Record: record}}, records...)
+	}
+	return records, nil
+}
+
+// LoadSettings reads and validates the current configuration, returning a *ConfigError if it is invalid
+func LoadSettings() (*Settings, error) {
+	return LoadSettingsFrom(V())
+}
+
+// LoadSettingsFrom is the same as LoadSettings, but reads the configuration from v instead of the one in use
+func LoadSettingsFrom(v *viper.Viper) (*Settings, error) {
+	records, err := records(v)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	settings := &Settings{
+		Credentials: Credentials{
+			Method: v.GetString(AuthMethod.Name),
+			Token:  v.GetString(Token.Name),
+			Key:    v.GetString(APIKey.Name),
+			Email:  v.GetString(Email.Name),
+		},
+		Records: records,
+	}
+	if err := settings.Validate(); err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	return settings, nil
+}
@@ -0,0 +1,141 @@
+package conf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettingsValidate(t *testing.T) {
+	assert := assert.New(t)
+	valid := func() *Settings {
+		return &Settings{
+			Credentials: Credentials{Token: "abc"},
+			Records: []RecordConfig{
+				{Domain: "example.com", Record: "example.com"},
+				{Domain: "example.com", Record: "sub.example.com"},
+			},
+		}
+	}
+	assert.NoError(valid().Validate())
+
+	s := valid()
+	s.Credentials.Token = ""
+	assert.Error(s.Validate(), "expected missing token to be invalid")
+
+	s = valid()
+	s.Credentials = Credentials{Method: AuthKey, Key: "key", Email: "me@example.com"}
+	assert.NoError(s.Validate())
+
+	s = valid()
+	s.Credentials = Credentials{Method: AuthKey, Key: "key"}
+	assert.Error(s.Validate(), "expected Global API Key without email to be invalid")
+
+	s = valid()
+	s.Credentials = Credentials{Method: AuthKey, Token: "abc", Email: "me@example.com"}
+	assert.Error(s.Validate(), "expected key auth without a key to be invalid, even with a token")
+
+	s = valid()
+	s.Credentials.Method = "password"
+	assert.Error(s.Validate(), "expected unknown auth method to be invalid")
+
+	s = valid()
+	s.Records = nil
+	assert.Error(s.Validate(), "expected no records to be invalid")
+
+	s = valid()
+	s.Records[1].Record = "sub"
+	assert.Error(s.Validate(), "expected record outside of domain to be invalid")
+
+	s = valid()
+	s.Records[1].Record = "subexample.com"
+	assert.Error(s.Validate(), "expected record that only shares a suffix with the domain to be invalid")
+
+	s = valid()
+	s.Records[1].Domain = ""
+	assert.Error(s.Validate(), "expected record without domain to be invalid")
+
+	s = valid()
+	s.Records = append(s.Records, s.Records[0])
+	assert.Error(s.Validate(), "expected duplicate record to be invalid")
+
+	s = valid()
+	s.Records[1].Allow = []string{"10.0.0.0/8"}
+	s.Records[1].Deny = []string{"10.9.0.0/16"}
+	assert.NoError(s.Validate())
+
+	s = valid()
+	s.Records[1].Deny = []string{"10.9.0.0"}
+	assert.Error(s.Validate(), "expected record with malformed CIDR to be invalid")
+
+	s = valid()
+	s.Records = append(s.Records, RecordConfig{Type: TypeIPList, Record: "office", Account: "123", List: "allow"})
+	assert.NoError(s.Validate())
+
+	s = valid()
+	s.Records = append(s.Records, RecordConfig{Type: TypeIPList, Record: "office", Account: "123"})
+	assert.Error(s.Validate(), "expected IP list entry without a list to be invalid")
+
+	s = valid()
+	s.Records = append(s.Records, RecordConfig{Type: TypeLBOrigin, Record: "home", Account: "123", Pool: "web"})
+	assert.NoError(s.Validate())
+
+	s = valid()
+	s.Records = append(s.Records, RecordConfig{Type: TypeLBOrigin, Record: "home", Account: "123"})
+	assert.Error(s.Validate(), "expected load balancer origin without a pool to be invalid")
+
+	s = valid()
+	s.Records[0].Interval = 5 * time.Minute
+	s.Records[0].Windows = []string{"Mon-Fri 09:00-17:00"}
+	s.Records[1].Cron = "*/15 * * * *"
+	assert.NoError(s.Validate())
+
+	s = valid()
+	s.Records[1].Cron = "*/15 * * * *"
+	s.Records[1].Interval = time.Minute
+	assert.Error(s.Validate(), "expected record with both cron and interval to be invalid")
+
+	s = valid()
+	s.Records[1].Windows = []string{"9am-5pm"}
+	assert.Error(s.Validate(), "expected record with malformed window to be invalid")
+
+	s = valid()
+	s.Records[1].ForceUpdateInterval = 24 * time.Hour
+	assert.NoError(s.Validate())
+
+	s = valid()
+	s.Records[1].ForceUpdateInterval = -time.Hour
+	assert.Error(s.Validate(), "expected negative force_update_interval to be invalid")
+
+	s = valid()
+	s.Records[0].OnShutdown = ShutdownDelete
+	s.Records[1].OnShutdown = ShutdownFallback
+	s.Records[1].Fallback = "192.0.2.10"
+	s.Records[1].FallbackAfter = 10 * time.Minute
+	assert.NoError(s.Validate())
+
+	s = valid()
+	s.Records[1].OnShutdown = ShutdownFallback
+	assert.Error(s.Validate(), "expected on_shutdown fallback without a fallback address to be invalid")
+
+	s = valid()
+	s.Records[1].FallbackAfter = 10 * time.Minute
+	assert.Error(s.Validate(), "expected fallback_after without a fallback address to be invalid")
+
+	s = valid()
+	s.Records[1].Fallback = "maintenance"
+	assert.Error(s.Validate(), "expected malformed fallback address to be invalid")
+
+	s = valid()
+	s.Records[1].OnShutdown = "explode"
+	assert.Error(s.Validate(), "expected unknown on_shutdown to be invalid")
+
+	s = valid()
+	s.Records = append(s.Records, RecordConfig{Type: TypeIPList, Record: "office", Account: "123", List: "allow", OnShutdown: ShutdownDelete})
+	assert.Error(s.Validate(), "expected on_shutdown delete of an IP list entry to be invalid")
+
+	s = valid()
+	s.Records[1].Type = "carrier-pigeon"
+	assert.Error(s.Validate(), "expected unknown type to be invalid")
+}
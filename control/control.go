@@ -0,0 +1,145 @@
+// Package control implements a Unix domain socket that allows a running daemon to be
+// inspected and controlled, e.g. from a router script that knows the IP just changed.
+//
+// The protocol is one command per connection. The client writes the command name
+// followed by a newline, the server replies with a single line of JSON.
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	CheckNow = "check-now"
+	Status   = "status"
+	Pause    = "pause"
+	Resume   = "resume"
+	Reload   = "reload"
+)
+
+// Commands lists all commands understood by the control socket
+var Commands = []string{CheckNow, Status, Pause, Resume, Reload}
+
+// How long a client has to send a command, or the server to answer, before the connection is dropped
+const ioTimeout = 10 * time.Second
+
+// Response is the reply to a command
+type Response struct {
+	OK    bool              `json:"ok"`
+	Error string            `json:"error,omitempty"`
+	State *ddns.DaemonState `json:"state,omitempty"`
+}
+
+// ReloadFunc reloads the program's configuration
+type ReloadFunc func() error
+
+// Server accepts commands on a Unix domain socket and applies them to a daemon
+type Server struct {
+	path     string
+	daemon   ddns.Daemon
+	reload   ReloadFunc
+	listener net.Listener
+}
+
+// Listen creates the control socket at path, replacing any stale socket left behind by a previous run.
+// The socket is only accessible by the current user.
+func Listen(path string, daemon ddns.Daemon, reload ReloadFunc) (*Server, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Annotatef(err, "unable to remove stale control socket '%s'", path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to listen on control socket '%s'", path)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, errors.Annotatef(err, "unable to set permissions of control socket '%s'", path)
+	}
+	return &Server{path: path, daemon: daemon, reload: reload, listener: listener}, nil
+}
+
+// Serve accepts connections until Close is called
+func (s *Server) Serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Error().Msgf("Control socket failed to accept connection: %v", err)
+			continue
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close stops accepting commands and removes the socket
+func (s *Server) Close() error {
+	return errors.Trace(s.listener.Close())
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ioTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		log.Debug().Msgf("Unable to read from control socket client: %v", err)
+		return
+	}
+	command := strings.TrimSpace(line)
+	log.Info().Msgf("Received control command '%s'", command)
+	resp := s.execute(command)
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Debug().Msgf("Unable to write control socket response: %v", err)
+	}
+}
+
+func (s *Server) execute(command string) Response {
+	switch command {
+	case CheckNow:
+		s.daemon.Trigger()
+	case Status:
+	case Pause:
+		s.daemon.Pause()
+	case Resume:
+		s.daemon.Resume()
+	case Reload:
+		if s.reload == nil {
+			return Response{Error: "reloading is not supported"}
+		}
+		if err := s.reload(); err != nil {
+			return Response{Error: err.Error()}
+		}
+	default:
+		return Response{Error: "unknown command '" + command + "', expected one of: " + strings.Join(Commands, ", ")}
+	}
+	state := s.daemon.State()
+	return Response{OK: true, State: &state}
+}
+
+// Send connects to the control socket at path, sends a command, and returns the response
+func Send(path, command string) (*Response, error) {
+	conn, err := net.DialTimeout("unix", path, ioTimeout)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to connect to control socket '%s', is the daemon running?", path)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ioTimeout))
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return nil, errors.Annotate(err, "unable to send command")
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, errors.Annotate(err, "unable to read response")
+	}
+	return &resp, nil
+}
@@ -0,0 +1,60 @@
+package control
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+	"github.com/mattolenik/cloudflare-ddns-client/test"
+)
+
+func TestControlSocket(t *testing.T) {
+	assert, require, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	daemon := ddns.NewMockDaemon(ctrl)
+	reloaded := false
+	reload := func() error {
+		if reloaded {
+			return errors.New("bad config")
+		}
+		reloaded = true
+		return nil
+	}
+	socket := filepath.Join(t.TempDir(), "ddns.sock")
+	server, err := Listen(socket, daemon, reload)
+	require.NoError(err)
+	defer server.Close()
+	go server.Serve()
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(socket)
+		require.NoError(err)
+		assert.Equal(os.FileMode(0600), info.Mode().Perm(), "the socket must only be accessible by its owner")
+	}
+
+	state := ddns.DaemonState{LastIP: "1.1.1.1"}
+	daemon.EXPECT().State().Return(state).AnyTimes()
+	daemon.EXPECT().Trigger().Times(1)
+	daemon.EXPECT().Pause().Times(1)
+	daemon.EXPECT().Resume().Times(1)
+
+	for _, command := range []string{CheckNow, Status, Pause, Resume, Reload} {
+		resp, err := Send(socket, command)
+		require.NoErrorf(err, "expected command '%s' to be sent", command)
+		assert.Truef(resp.OK, "expected command '%s' to succeed, got error: %s", command, resp.Error)
+		assert.Equal(state, *resp.State)
+	}
+	assert.True(reloaded, "expected reload to be called")
+
+	resp, err := Send(socket, Reload)
+	require.NoError(err)
+	assert.False(resp.OK, "expected failed reload to be reported")
+	assert.Equal("bad config", resp.Error)
+
+	resp, err = Send(socket, "explode")
+	require.NoError(err)
+	assert.False(resp.OK, "expected unknown command to be rejected")
+}
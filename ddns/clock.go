@@ -0,0 +1,19 @@
+package ddns
+
+import "time"
+
+// Clock tells the time and waits for it to pass. The daemon uses the system clock unless given another with SetClock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
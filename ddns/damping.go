@@ -0,0 +1,76 @@
+package ddns
+
+import (
+	"fmt"
+	"time"
+)
+
+// Damping holds back IP changes that may only be temporary, e.g. from a link that keeps failing over.
+// The zero value applies no damping.
+type Damping struct {
+	// ConfirmChecks is how many consecutive checks must see a new IP before it is published
+	ConfirmChecks int
+	// ConfirmDuration is how long a new IP must be seen for before it is published.
+	// If both are set, meeting either one is enough.
+	ConfirmDuration time.Duration
+	// MaxChangesPerHour limits how often the published IP may change, 0 for no limit
+	MaxChangesPerHour int
+}
+
+// damper applies Damping to the IPs seen by successive checks
+type damper struct {
+	Damping
+	confirmed string
+	candidate string
+	seen      int
+	since     time.Time
+	changes   []time.Time
+}
+
+// observe records the IP found by a check and returns the IP that should be published.
+// If a new IP is being held back, the previously confirmed IP is returned along with the reason.
+// The first IP ever seen is accepted immediately as there is nothing to flap from.
+func (dp *damper) observe(ip string, now time.Time) (string, string) {
+	if dp.confirmed == "" || ip == dp.confirmed {
+		dp.confirmed = ip
+		dp.candidate, dp.seen = "", 0
+		return ip, ""
+	}
+	if ip != dp.candidate {
+		dp.candidate, dp.seen, dp.since = ip, 0, now
+	}
+	dp.seen++
+	stable := now.Sub(dp.since)
+	byChecks := dp.ConfirmChecks > 1 && dp.seen >= dp.ConfirmChecks
+	byDuration := dp.ConfirmDuration > 0 && stable >= dp.ConfirmDuration
+	if (dp.ConfirmChecks > 1 || dp.ConfirmDuration > 0) && !byChecks && !byDuration {
+		return dp.confirmed, dp.pendingReason(stable)
+	}
+
+	hourAgo := now.Add(-time.Hour)
+	for len(dp.changes) > 0 && !dp.changes[0].After(hourAgo) {
+		dp.changes = dp.changes[1:]
+	}
+	if dp.MaxChangesPerHour > 0 && len(dp.changes) >= dp.MaxChangesPerHour {
+		return dp.confirmed, fmt.Sprintf("already changed %d times in the last hour, the limit is %d, next change allowed in %s",
+			len(dp.changes), dp.MaxChangesPerHour, dp.changes[0].Add(time.Hour).Sub(now).Round(time.Second))
+	}
+	dp.changes = append(dp.changes, now)
+	dp.confirmed = ip
+	dp.candidate, dp.seen = "", 0
+	return ip, ""
+}
+
+func (dp *damper) pendingReason(stable time.Duration) string {
+	var reason string
+	if dp.ConfirmChecks > 1 {
+		reason = fmt.Sprintf("seen on %d of %d checks", dp.seen, dp.ConfirmChecks)
+	}
+	if dp.ConfirmDuration > 0 {
+		if reason != "" {
+			reason += ", "
+		}
+		reason += fmt.Sprintf("stable for %s of %s", stable.Round(time.Second), dp.ConfirmDuration)
+	}
+	return "waiting for confirmation, " + reason
+}
@@ -0,0 +1,80 @@
+package ddns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDampingDisabled(t *testing.T) {
+	assert := assert.New(t)
+	dp := &damper{}
+	now := time.Now()
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2", "1.1.1.1"} {
+		published, held := dp.observe(ip, now)
+		assert.Equal(ip, published)
+		assert.Empty(held)
+	}
+}
+
+func TestDampingConfirmChecks(t *testing.T) {
+	assert := assert.New(t)
+	dp := &damper{Damping: Damping{ConfirmChecks: 3}}
+	now := time.Now()
+
+	published, _ := dp.observe("1.1.1.1", now)
+	assert.Equal("1.1.1.1", published, "first IP is accepted right away")
+
+	// Bouncing between two addresses never confirms the new one
+	for i := 0; i < 5; i++ {
+		published, held := dp.observe("2.2.2.2", now)
+		assert.Equal("1.1.1.1", published)
+		assert.Contains(held, "seen on 1 of 3 checks")
+		published, held = dp.observe("1.1.1.1", now)
+		assert.Equal("1.1.1.1", published)
+		assert.Empty(held)
+	}
+
+	dp.observe("2.2.2.2", now)
+	dp.observe("2.2.2.2", now)
+	published, held := dp.observe("2.2.2.2", now)
+	assert.Equal("2.2.2.2", published)
+	assert.Empty(held)
+}
+
+func TestDampingConfirmDuration(t *testing.T) {
+	assert := assert.New(t)
+	dp := &damper{Damping: Damping{ConfirmDuration: time.Minute}}
+	now := time.Now()
+
+	dp.observe("1.1.1.1", now)
+	published, held := dp.observe("2.2.2.2", now)
+	assert.Equal("1.1.1.1", published)
+	assert.Contains(held, "stable for 0s of 1m0s")
+	published, _ = dp.observe("2.2.2.2", now.Add(30*time.Second))
+	assert.Equal("1.1.1.1", published)
+	published, held = dp.observe("2.2.2.2", now.Add(time.Minute))
+	assert.Equal("2.2.2.2", published)
+	assert.Empty(held)
+}
+
+func TestDampingMaxChangesPerHour(t *testing.T) {
+	assert := assert.New(t)
+	dp := &damper{Damping: Damping{MaxChangesPerHour: 2}}
+	now := time.Now()
+
+	dp.observe("1.1.1.1", now)
+	published, _ := dp.observe("2.2.2.2", now)
+	assert.Equal("2.2.2.2", published)
+	published, _ = dp.observe("3.3.3.3", now.Add(time.Minute))
+	assert.Equal("3.3.3.3", published)
+	published, held := dp.observe("4.4.4.4", now.Add(2*time.Minute))
+	assert.Equal("3.3.3.3", published)
+	assert.Contains(held, "already changed 2 times in the last hour")
+
+	// The oldest change has aged out of the window
+	published, held = dp.observe("4.4.4.4", now.Add(time.Hour+time.Second))
+	assert.Equal("4.4.4.4", published)
+	assert.Empty(held)
+}
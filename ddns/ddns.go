@@ -3,64 +3,234 @@ package ddns
 //go:generate mockgen -destination=../mocks/mock_ddns.go -package=mocks -source=ddns.go
 
 import (
+	"context"
+	stderrors "errors"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/juju/errors"
 	"github.com/mattolenik/cloudflare-ddns-client/conf"
 	"github.com/mattolenik/cloudflare-ddns-client/ip"
+	"github.com/mattolenik/cloudflare-ddns-client/schedule"
 	"github.com/mattolenik/cloudflare-ddns-client/task"
 )
 
 type DDNSProvider interface {
 	Get(domain, record string) (string, error)
 	Update(domain, record, ip string) error
+	// ForceUpdate is the same as Update, but writes the record even if it already points at ip
+	ForceUpdate(domain, record, ip string) error
+	// Delete removes the record, it is not an error if it doesn't exist
+	Delete(domain, record string) error
+}
+
+// HeartbeatProvider writes TXT records, see Heartbeat
+type HeartbeatProvider interface {
+	UpdateTXT(domain, record, content string) error
+}
+
+// TXTRecord is one of the TXT records with a given name
+type TXTRecord struct {
+	ID      string
+	Content string
+}
+
+// LeaseProvider reads and writes TXT records, see TXTLease. UpdateTXT must replace the record with the lowest ID
+// if there are several with the name.
+type LeaseProvider interface {
+	HeartbeatProvider
+	// ListTXT returns every TXT record with the given name, ordered by ID
+	ListTXT(domain, record string) ([]TXTRecord, error)
+	// DeleteTXT deletes the TXT record with the given name and ID
+	DeleteTXT(domain, record, id string) error
+}
+
+// Lease decides which of several instances acts on a record, only the holder of an unexpired lease does, see Leadership
+type Lease interface {
+	// Acquire takes the lease on the record for holder until expires, or extends it if holder already has it.
+	// It returns false, changing nothing, if another holder's lease hasn't expired by now. Any wait is cut
+	// short once ctx is done.
+	Acquire(ctx context.Context, r conf.RecordConfig, holder string, now, expires time.Time) (bool, error)
+	// Release gives up holder's lease on the record, if it has it, so that another instance can take over straight away
+	Release(r conf.RecordConfig, holder string) error
 }
 
 type IPProvider interface {
-	Get() (ip string, err error)
+	// Get returns the public IP and a short description of where it came from, giving up once ctx is done
+	Get(ctx context.Context) (ip, source string, err error)
 }
 
-type DefaultIPProvider struct{}
+type DefaultIPProvider struct {
+	resolver *ip.Resolver
+	clock    Clock
+}
 
-func (p *DefaultIPProvider) Get() (string, error) {
-	ip, err := ip.GetPublicIPWithRetry(10, 5*time.Second)
+func (p *DefaultIPProvider) Get(ctx context.Context) (string, string, error) {
+	resolver := p.resolver
+	if resolver == nil {
+		resolver = &ip.Resolver{Sources: ip.Sources, STUNServers: ip.STUNServers}
+	}
+	clock := p.clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+	ip, source, err := resolver.GetPublicIPWithRetryContext(ctx, 10, 5*time.Second, clock.After)
 	if err != nil {
-		return "", errors.Trace(err)
+		return "", "", errors.Trace(err)
 	}
-	return ip, nil
+	return ip, source, nil
 }
 
+// SetClock replaces the system clock, which is used to wait between attempts
+func (p *DefaultIPProvider) SetClock(clock Clock) {
+	p.clock = clock
+}
+
+// NewDefaultIPProvider creates an IPProvider that uses the sources and servers configured in the ip package
 func NewDefaultIPProvider() *DefaultIPProvider {
 	return &DefaultIPProvider{}
 }
 
+// NewIPProvider creates an IPProvider that looks up the public IP with resolver
+func NewIPProvider(resolver *ip.Resolver) *DefaultIPProvider {
+	return &DefaultIPProvider{resolver: resolver}
+}
+
+// StaticIPProvider always provides the same, already known, IP
+type StaticIPProvider struct {
+	ip string
+}
+
+func (p *StaticIPProvider) Get(ctx context.Context) (string, string, error) {
+	return p.ip, SourceStatic, nil
+}
+
+// SourceStatic is the source reported by StaticIPProvider
+const SourceStatic = "static"
+
+// NewStaticIPProvider creates an IPProvider that always provides addr
+func NewStaticIPProvider(addr string) (*StaticIPProvider, error) {
+	if net.ParseIP(addr) == nil {
+		return nil, errors.Errorf("invalid IP address: %q", addr)
+	}
+	return &StaticIPProvider{ip: addr}, nil
+}
+
 type ConfigProvider interface {
-	Get() (domain, record string, err error)
+	Get() (records []conf.RecordConfig, err error)
 }
 
-type DefaultConfigProvider struct{}
+// DefaultConfigProvider provides the records from the program's configuration.
+// Records can be replaced while the daemon is running, see Set.
+type DefaultConfigProvider struct {
+	mu      sync.Mutex
+	records []conf.RecordConfig
+}
 
-func (p *DefaultConfigProvider) Get() (domain, record string, err error) {
-	return conf.Domain.Get(), conf.Record.Get(), nil
+func (p *DefaultConfigProvider) Get() ([]conf.RecordConfig, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.records != nil {
+		return p.records, nil
+	}
+	return conf.Records()
+}
+
+// Set replaces the records that are provided, they will be picked up by the daemon on its next check
+func (p *DefaultConfigProvider) Set(records []conf.RecordConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.records = records
 }
 
 func NewDefaultConfigProvider() *DefaultConfigProvider {
 	return &DefaultConfigProvider{}
 }
 
+// Verifier checks that an updated record is being served, returning how long that took
+type Verifier interface {
+	Verify(ctx context.Context, domain, record, ip string) (time.Duration, error)
+}
+
+// ProxyAware is implemented by DDNSProviders that know whether a record is proxied. The nameservers of a proxied
+// record answer with the proxy's addresses, so its propagation can't be verified. known is false if the provider
+// hasn't seen the record yet.
+type ProxyAware interface {
+	Proxied(domain, record string) (proxied, known bool)
+}
+
+// Target keeps something other than a DNS record pointed at the public IP, selected by conf.RecordConfig.Type
+type Target interface {
+	Get(r conf.RecordConfig) (string, error)
+	Update(r conf.RecordConfig, ip string) error
+	// ForceUpdate is the same as Update, but writes to the target even if it already points at ip
+	ForceUpdate(r conf.RecordConfig, ip string) error
+}
+
+// Store remembers when records were last written, so that force_update_interval is honoured across restarts
+type Store interface {
+	LastPush(key string) time.Time
+	SetLastPush(key string, t time.Time) error
+}
+
 type Daemon interface {
 	Update() error
-	Start(updatePeriod, retryDelay time.Duration) chan task.Status
+	Start(updatePeriod, retryDelay time.Duration)
+	Events() *task.Bus
 	Stop()
+	Trigger()
+	Pause()
+	Resume()
+	State() DaemonState
+}
+
+// DaemonState is a snapshot of what the daemon is currently doing
+type DaemonState struct {
+	Paused    bool          `json:"paused"`
+	LastIP    string        `json:"last_ip,omitempty"`
+	PendingIP string        `json:"pending_ip,omitempty"`
+	LastCheck time.Time     `json:"last_check,omitempty"`
+	LastError string        `json:"last_error,omitempty"`
+	Records   []RecordState `json:"records,omitempty"`
+}
+
+// RecordState is the last known state of a single managed record
+type RecordState struct {
+	Domain     string    `json:"domain"`
+	Record     string    `json:"record"`
+	IP         string    `json:"ip,omitempty"`
+	LastUpdate time.Time `json:"last_update,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+	// Standby is true if another instance holds the record's lease, see Leadership
+	Standby bool `json:"standby,omitempty"`
 }
 
 type DDNSDaemon struct {
 	Daemon
-	shouldRun      bool
 	ddnsProvider   DDNSProvider
 	ipProvider     IPProvider
 	configProvider ConfigProvider
+	trigger        chan struct{}
+	stop           chan struct{}
+	stopOnce       sync.Once
+	events         *task.Bus
+	damping        Damping
+	verifier       Verifier
+	heartbeat      Heartbeat
+	leadership     Leadership
+	leaseMu        sync.Mutex
+	leases         map[string]time.Time
+	leaseChanged   chan struct{}
+	renewing       sync.WaitGroup
+	verifying      sync.WaitGroup
+	targets        map[string]Target
+	clock          Clock
+	store          Store
+	ctx            context.Context
+	cancel         context.CancelFunc
+	mu             sync.Mutex
+	state          DaemonState
 }
 
 // NewDefaultDaemon creates a new DDNSDaemon
@@ -74,117 +244,921 @@ func NewDefaultDaemon(ddnsProvider DDNSProvider, ipProvider IPProvider, configPr
 	if configProvider == nil {
 		panic("configProvider must not be nil")
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &DDNSDaemon{
-		shouldRun:      true,
+		ctx:            ctx,
+		cancel:         cancel,
 		ddnsProvider:   ddnsProvider,
 		ipProvider:     ipProvider,
 		configProvider: configProvider,
+		trigger:        make(chan struct{}, 1),
+		leaseChanged:   make(chan struct{}, 1),
+		leases:         map[string]time.Time{},
+		stop:           make(chan struct{}),
+		events:         task.NewBus(),
+		targets:        map[string]Target{},
+		clock:          systemClock{},
 	}
 }
 
-// Update performs a one time DDNS update.
+// Update performs a one time DDNS update of all configured records. Failures are returned as an
+// *IPDetectionError if no usable public IP was found, or a *ProviderError if a record couldn't be updated.
 func (d *DDNSDaemon) Update() error {
-	var err error
-	var ip string
-	confIP := conf.IP.Get()
-	if confIP == "" {
-		ip, err = d.ipProvider.Get()
+	_, err := d.UpdateRecords()
+	return err
+}
+
+// UpdateResult lists which records were changed by UpdateRecords, and which already pointed at the IP
+type UpdateResult struct {
+	IP        string
+	Updated   []conf.RecordConfig
+	Unchanged []conf.RecordConfig
+	// Forced lists records that already pointed at the IP, but were rewritten as their force_update_interval had passed
+	Forced []conf.RecordConfig
+	// Warnings are problems that didn't stop any record from being updated, such as failing to save the Store
+	Warnings []error
+}
+
+// UpdateRecords is the same as Update, but also reports which records were changed. Records are only
+// updated if they don't already point at the public IP, or if their force_update_interval has passed
+// since they were last written according to the Store.
+func (d *DDNSDaemon) UpdateRecords() (UpdateResult, error) {
+	var result UpdateResult
+	ip, _, err := d.ipProvider.Get(d.ctx)
+	if err != nil {
+		return result, &IPDetectionError{Err: err}
+	}
+	result.IP = ip
+	records, err := d.configProvider.Get()
+	if err != nil {
+		return result, errors.Annotate(err, "unable to find domain or record in configuration")
+	}
+	var failed []error
+	for _, r := range records {
+		if err := checkPolicy(r, ip); err != nil {
+			failed = append(failed, &IPDetectionError{Err: errors.Annotatef(err, "record '%s'", r.Record)})
+			continue
+		}
+		current, err := d.get(r)
 		if err != nil {
-			return errors.Annotate(err, "unable to retrieve public IP")
+			failed = append(failed, asProviderError(errors.Annotatef(err, "record '%s'", r.Record)))
+			continue
+		}
+		now := d.clock.Now()
+		forced := current == ip
+		if forced && !forceDue(r, d.lastPush(r), now) {
+			result.Unchanged = append(result.Unchanged, r)
+			continue
+		}
+		if err := d.update(r, ip, forced); err != nil {
+			failed = append(failed, asProviderError(errors.Annotatef(err, "record '%s'", r.Record)))
+			continue
+		}
+		if forced {
+			result.Forced = append(result.Forced, r)
+		} else {
+			result.Updated = append(result.Updated, r)
 		}
-	} else {
-		if net.ParseIP(confIP) == nil {
-			return errors.Errorf("invalid IP address: %q", confIP)
+		if err := d.pushed(r, now); err != nil {
+			// The record was updated, it may just be rewritten sooner than needed on the next run
+			result.Warnings = append(result.Warnings, err)
 		}
-		ip = confIP
 	}
-	domain, record, err := d.configProvider.Get()
-	if err != nil {
-		return errors.Annotate(err, "unable to find domain or record in configuration")
+	if len(failed) > 0 {
+		return result, errors.Annotate(stderrors.Join(failed...), "failed to update DNS")
 	}
-	err = d.ddnsProvider.Update(domain, record, ip)
-	return errors.Annotatef(err, "failed to update DNS")
+	return result, nil
+}
+
+// recordState tracks a managed record between checks
+type recordState struct {
+	conf.RecordConfig
+	schedule     schedule.Schedule
+	due          time.Time
+	lastIP       string
+	lastIPUpdate time.Time
+	lastPush     time.Time
+	parked       bool
+	lastError    error
+	attempt      int
+	lastBeat     time.Time
 }
 
 // Start continually keeps DDNS up to date.
-// updatePeriod - how often to check for updates
+// updatePeriod - how often to check records that don't have a schedule of their own
 // retryDelay   - how long to wait until retry after a failure
-func (d *DDNSDaemon) Start(updatePeriod, retryDelay time.Duration) (status chan task.Status) {
+// Each record is checked on its own schedule, see conf.RecordConfig.Schedule. Records that are due at the
+// same time are checked together, detecting the public IP only once for all of them.
+// Records are first checked straight away, unless their schedule doesn't allow a check at the time, e.g. outside
+// of their windows. A check of every record that its schedule allows can also be requested with Trigger.
+// The records are read from the ConfigProvider before every check, so records can be added or removed while running.
+// Progress is published to Events, subscribe before calling Start to receive every event.
+// Records with a fallback address are pointed at it while no public IP has been found for their fallback_after,
+// and once Stop is called each record's on_shutdown action is carried out, see conf.RecordConfig.
+// With SetLeadership, only records that this instance holds an unexpired lease on are acted on, and the leases
+// are renewed separately from the checks, so that a slow check doesn't let them lapse.
+// The event bus is closed once the daemon has stopped.
+func (d *DDNSDaemon) Start(updatePeriod, retryDelay time.Duration) {
+	states := map[string]*recordState{}
+	dp := &damper{Damping: d.damping}
+
 	var lastIP string
-	var lastIPUpdate time.Time
+	var nextBeat time.Time
+	var records []conf.RecordConfig
+	ipAttempt := 0
+	// When a usable public IP was last found
+	confirmed := d.clock.Now()
 
-	status <- task.InfoStatusf("Daemon running, will now monitor for IP updates every %d seconds", int(updatePeriod.Seconds()))
+	d.publish(task.InfoStatusf("Daemon running, will now monitor for IP updates every %d seconds by default", int(updatePeriod.Seconds())).
+		WithKind(task.DaemonStarted))
 
-	func() {
-		defer close(status)
-		for d.shouldRun {
-			domain, record, err := d.configProvider.Get()
+	if d.leadership.enabled() {
+		d.renewing.Add(1)
+		go d.renewLeases()
+	}
+
+	go func() {
+		defer d.events.Close()
+		defer d.verifying.Wait()
+		defer d.renewing.Wait()
+		// A fatal error ends the daemon too, leases must not be renewed for records that it no longer acts on
+		defer d.Stop()
+		triggered := false
+		for !d.stopped() {
+			if d.State().Paused {
+				triggered = d.wait(updatePeriod)
+				continue
+			}
+			var err error
+			records, err = d.configProvider.Get()
 			if err != nil {
-				status <- task.FatalStatusWrap(err, "unable to find domain or record in configuration")
+				d.publish(task.FatalStatusWrap(err, "unable to find domain or record in configuration"))
 				return
 			}
-			dnsRecordIP, err := d.ddnsProvider.Get(domain, record)
-			if err != nil {
-				status <- task.ErrorStatusf("Unable to look up current DNS record, will retry in %d seconds. Error was:\n%v", int(updatePeriod.Seconds()), err)
-				time.Sleep(retryDelay)
-				continue
+			d.syncRecords(states, records, updatePeriod)
+			d.publishRecords(states, records)
+
+			now := d.clock.Now()
+			var due []conf.RecordConfig
+			for _, r := range records {
+				rs := states[r.Key()]
+				if !d.leads(r) {
+					// The leader is responsible for the record, including any fallback
+					rs.parked = false
+					continue
+				}
+				// A trigger doesn't override the record's schedule, e.g. a check outside of its windows
+				if !rs.due.After(now) || (triggered && rs.schedule.Allows(now)) {
+					due = append(due, r)
+				}
 			}
-			newIP, err := d.ipProvider.Get()
-			if err != nil {
-				status <- task.ErrorStatusf("Unable to retrieve public IP, will retry in %d seconds. Error was:\n%v", int(updatePeriod.Seconds()), err)
-				time.Sleep(retryDelay)
+			if len(due) == 0 && (nextBeat.IsZero() || nextBeat.After(now)) {
+				triggered = d.wait(shortest(d.untilDue(states, records, now, updatePeriod), durationUntil(nextBeat, now)))
 				continue
 			}
+			d.setState(func(s *DaemonState) { s.LastCheck = now })
 
-			// Nothing has changed, log and move on
-			if newIP == lastIP && newIP == dnsRecordIP {
-				status <- task.InfoStatusf(
-					"No IP change detected since %s (%d seconds ago)",
-					lastIPUpdate.Format(time.RFC1123Z),
-					int(time.Since(lastIPUpdate).Seconds()))
-				time.Sleep(updatePeriod)
+			started := d.clock.Now()
+			newIP, source, err := d.ipProvider.Get(d.ctx)
+			if err != nil {
+				ipAttempt++
+				d.setError(err)
+				d.publish(task.ErrorStatusf("Unable to retrieve public IP, will retry in %d seconds. Error was:\n%v", int(retryDelay.Seconds()), err).
+					WithKind(task.IPDetectionFailed).
+					WithAttempt(ipAttempt))
+				untilFallback := d.park(states, records, d.clock.Now().Sub(confirmed))
+				// Records that were triggered stay due until they have been checked
+				triggered = d.wait(shortest(retryDelay, untilFallback)) || triggered
 				continue
 			}
+			triggered = false
+			confirmed = d.clock.Now()
+			ipAttempt = 0
+			// Parked records are restored as soon as there is a public IP again, whatever their schedule
+			for _, r := range records {
+				if rs := states[r.Key()]; rs.parked && d.leads(r) && rs.due.After(now) {
+					rs.due = now
+					due = append(due, r)
+				}
+			}
+			d.setState(func(s *DaemonState) { s.LastIP, s.LastError = newIP, "" })
 
 			// IP has changed, log depending on how it has changed
 			if lastIP == "" {
 				// Log line for first time
-				status <- task.InfoStatusf("Found public IP '%s'", newIP)
+				d.publish(task.InfoStatusf("Found public IP '%s'", newIP).
+					WithKind(task.IPDetected).
+					WithIPs("", newIP).
+					WithSource(source).
+					WithDuration(d.clock.Now().Sub(started)))
 			} else if newIP != lastIP {
 				// Log line for IP change
-				status <- task.InfoStatusf("Detected new public IP address, it changed from '%s' to '%s'", lastIP, newIP)
-			} else if dnsRecordIP != newIP {
-				// Log line for no new IP, but mismatch with DNS record
-				status <- task.InfoStatusf("Public IP address did not change, but DNS record did match, is '%s' but expected '%s', correcting", dnsRecordIP, newIP)
+				d.publish(task.InfoStatusf("Detected new public IP address, it changed from '%s' to '%s'", lastIP, newIP).
+					WithKind(task.IPChanged).
+					WithIPs(lastIP, newIP).
+					WithSource(source).
+					WithDuration(d.clock.Now().Sub(started)))
 			}
-
 			lastIP = newIP
-			lastIPUpdate = time.Now()
 
-			// Reach out to the actual DDNS provider and make the update
-			err = d.ddnsProvider.Update(domain, record, lastIP)
-			if err != nil {
-				status <- task.ErrorStatusf("Unable to update DNS, will retry in %d seconds. Erorr was:\n%v", updatePeriod/time.Second, err)
-				time.Sleep(retryDelay)
-				continue
+			publishIP, held := dp.observe(newIP, d.clock.Now())
+			pendingIP := ""
+			if held != "" {
+				pendingIP = newIP
+				d.publish(task.InfoStatusf("Not updating DNS to new IP '%s' yet, %s", newIP, held).
+					WithKind(task.UpdateDamped).
+					WithIPs(publishIP, newIP).
+					WithSource(source))
+			}
+			d.setState(func(s *DaemonState) { s.PendingIP = pendingIP })
+
+			var backoff time.Duration
+			for _, r := range due {
+				rs := states[r.Key()]
+				rs.lastError = d.check(rs, publishIP)
+				// Scheduled from when the batch started rather than when each record was checked, so that records
+				// that were due together stay together however long their checks take
+				next := rs.schedule.Next(now)
+				retry := now.Add(retryDelay)
+				if rs.lastError == nil {
+					rs.attempt = 0
+					// A held back change is checked again as soon as a retry would be, so that it can be confirmed
+					if held != "" {
+						next = earliest(next, retry)
+					}
+					if rs.ForceUpdateInterval > 0 {
+						next = earliest(next, rs.lastPush.Add(rs.ForceUpdateInterval))
+					}
+					rs.due = next
+					if rs.parked {
+						rs.parked = false
+						d.publish(task.InfoStatusf("Public IP found again, DNS record '%s' restored from fallback address '%s' to '%s'", rs.Record, rs.Fallback, publishIP).
+							WithKind(task.RecordRestored).
+							ForRecord(rs.Domain, rs.Record).
+							WithIPs(rs.Fallback, publishIP))
+					}
+					continue
+				}
+				rs.attempt++
+				class, retryAfter := Classify(rs.lastError)
+				switch class {
+				case ClassAuth:
+					d.publishRecords(states, records)
+					d.publish(task.FatalStatusWrap(rs.lastError, "the DNS provider rejected the credentials").
+						WithErrorClass(string(class)))
+					return
+				case ClassRateLimited:
+					backoff = retryAfter
+				case ClassNotFound:
+					// The provider has forgotten any cached IDs, so retry straight away with fresh ones, but only once
+					if rs.attempt == 1 {
+						rs.due = now
+					} else {
+						rs.due = earliest(next, retry)
+					}
+				case ClassPermission, ClassValidation:
+					// Retrying soon won't help, these need the configuration or token to be fixed
+					rs.due = next
+				default:
+					rs.due = earliest(next, retry)
+				}
+				if backoff > 0 {
+					// Any further requests would be rejected too, the remaining records stay due
+					break
+				}
 			}
-			// Do another run check before the sleep occurs so as to not draw out the stop operation
-			if !d.shouldRun {
-				break
+			d.publishRecords(states, records)
+			nextBeat = time.Time{}
+			if beat := d.beat(states, records, publishIP, source); beat > 0 {
+				nextBeat = d.clock.Now().Add(beat)
+			}
+			if backoff > 0 {
+				d.publish(task.ErrorStatusf("Rate limited by the DNS provider, backing off for %s", backoff).
+					WithKind(task.RateLimited).
+					WithErrorClass(string(ClassRateLimited)).
+					WithDuration(backoff))
+				triggered = d.wait(backoff)
 			}
-			time.Sleep(updatePeriod)
 		}
+		d.shutdown(states, records)
+		d.publish(task.Status{Type: task.Info, Kind: task.DaemonStopped, Message: "Daemon stopped", IsDone: true})
 	}()
-	return status
+}
+
+// park points records at their fallback address once no public IP has been found for their fallback_after,
+// and returns how long until the next record is due to be parked, or zero if none are
+func (d *DDNSDaemon) park(states map[string]*recordState, records []conf.RecordConfig, unconfirmed time.Duration) time.Duration {
+	var next time.Duration
+	for _, r := range records {
+		rs := states[r.Key()]
+		if rs.FallbackAfter == 0 || rs.parked || !d.leads(r) {
+			continue
+		}
+		if wait := rs.FallbackAfter - unconfirmed; wait > 0 {
+			next = shortest(next, wait)
+			continue
+		}
+		if err := d.update(rs.RecordConfig, rs.Fallback, false); err != nil {
+			class, _ := Classify(err)
+			d.publish(task.ErrorStatusf("Unable to point DNS record '%s' at fallback address '%s', will retry. Error was:\n%v", rs.Record, rs.Fallback, err).
+				WithKind(task.UpdateFailed).
+				ForRecord(rs.Domain, rs.Record).
+				WithIPs(rs.lastIP, rs.Fallback).
+				WithErrorClass(string(class)))
+			continue
+		}
+		d.publish(task.InfoStatusf("No public IP found for %s, DNS record '%s' now points to fallback address '%s'", unconfirmed.Round(time.Second), rs.Record, rs.Fallback).
+			WithKind(task.RecordParked).
+			ForRecord(rs.Domain, rs.Record).
+			WithIPs(rs.lastIP, rs.Fallback))
+		rs.parked = true
+		rs.lastIP = rs.Fallback
+		rs.lastIPUpdate = d.clock.Now()
+	}
+	d.publishRecords(states, records)
+	return next
+}
+
+// shutdown carries out each record's on_shutdown action, and then gives up the leases it holds
+func (d *DDNSDaemon) shutdown(states map[string]*recordState, records []conf.RecordConfig) {
+	defer d.release(records)
+	for _, r := range records {
+		rs := states[r.Key()]
+		if !d.leads(r) {
+			// The instance that is taking over is responsible for the record now
+			continue
+		}
+		switch rs.OnShutdown {
+		case conf.ShutdownDelete:
+			if err := d.ddnsProvider.Delete(rs.Domain, rs.Record); err != nil {
+				class, _ := Classify(err)
+				d.publish(task.ErrorStatusf("Unable to delete DNS record '%s' on shutdown. Error was:\n%v", rs.Record, err).
+					WithKind(task.UpdateFailed).
+					ForRecord(rs.Domain, rs.Record).
+					WithErrorClass(string(class)))
+				continue
+			}
+			d.publish(task.InfoStatusf("Deleted DNS record '%s' on shutdown", rs.Record).
+				WithKind(task.RecordDeleted).
+				ForRecord(rs.Domain, rs.Record).
+				WithIPs(rs.lastIP, ""))
+		case conf.ShutdownFallback:
+			if err := d.update(rs.RecordConfig, rs.Fallback, false); err != nil {
+				class, _ := Classify(err)
+				d.publish(task.ErrorStatusf("Unable to point DNS record '%s' at fallback address '%s' on shutdown. Error was:\n%v", rs.Record, rs.Fallback, err).
+					WithKind(task.UpdateFailed).
+					ForRecord(rs.Domain, rs.Record).
+					WithIPs(rs.lastIP, rs.Fallback).
+					WithErrorClass(string(class)))
+				continue
+			}
+			d.publish(task.InfoStatusf("DNS record '%s' now points to fallback address '%s' on shutdown", rs.Record, rs.Fallback).
+				WithKind(task.RecordParked).
+				ForRecord(rs.Domain, rs.Record).
+				WithIPs(rs.lastIP, rs.Fallback))
+		}
+	}
+}
+
+// untilDue returns how long until the first of the records that this instance leads is due, or defaultInterval
+// if none of them ever are
+func (d *DDNSDaemon) untilDue(states map[string]*recordState, records []conf.RecordConfig, now time.Time, defaultInterval time.Duration) time.Duration {
+	var first time.Time
+	for _, r := range records {
+		if d.leads(r) {
+			first = earliest(first, states[r.Key()].due)
+		}
+	}
+	if first.IsZero() {
+		return defaultInterval
+	}
+	return durationUntil(first, now)
+}
+
+// durationUntil returns how long from now until t, at least a nanosecond so that it isn't mistaken for no wait at all,
+// or zero if t is the zero time
+func durationUntil(t, now time.Time) time.Duration {
+	if t.IsZero() {
+		return 0
+	}
+	if wait := t.Sub(now); wait > 0 {
+		return wait
+	}
+	return time.Nanosecond
+}
+
+// earliest returns the earlier of two times, ignoring either one if it is the zero time
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// SetDamping configures how new IPs are confirmed before they are published, it must be called before Start
+func (d *DDNSDaemon) SetDamping(damping Damping) {
+	d.damping = damping
+}
+
+// SetLeadership makes the daemon only act on records that it holds the lease on, it must be called before Start
+func (d *DDNSDaemon) SetLeadership(leadership Leadership) {
+	d.leadership = leadership
+}
+
+// leads returns true if this instance is to act on the record, which is always the case without Leadership.
+// Otherwise this instance must hold a lease on the record that hasn't expired yet.
+func (d *DDNSDaemon) leads(r conf.RecordConfig) bool {
+	if !d.leadership.enabled() {
+		return true
+	}
+	d.leaseMu.Lock()
+	defer d.leaseMu.Unlock()
+	return d.clock.Now().Before(d.leases[r.Key()])
+}
+
+// renewLeases renews the leases every third of their TTL until the daemon is stopped
+func (d *DDNSDaemon) renewLeases() {
+	defer d.renewing.Done()
+	// Whether this instance led each record when last published
+	leading := map[string]bool{}
+	for {
+		// An error is reported by the checks, which read the records too
+		if records, err := d.configProvider.Get(); err == nil {
+			d.renew(records, leading)
+		}
+		select {
+		case <-d.clock.After(d.leadership.TTL / 3):
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// renew acquires or renews the lease on each record, publishing any change of leader, and wakes the checks
+// if there was one. The leases are renewed concurrently, so that a lease taking long to acquire doesn't hold up
+// the renewal of the others.
+func (d *DDNSDaemon) renew(records []conf.RecordConfig, leading map[string]bool) {
+	l := d.leadership
+	now := d.clock.Now()
+	expires := now.Add(l.TTL)
+	acquired := make([]bool, len(records))
+	errs := make([]error, len(records))
+	var wg sync.WaitGroup
+	for i, r := range records {
+		wg.Add(1)
+		go func(i int, r conf.RecordConfig) {
+			defer wg.Done()
+			acquired[i], errs[i] = l.Lease.Acquire(d.ctx, r, l.Holder, now, expires)
+		}(i, r)
+	}
+	wg.Wait()
+	for i, r := range records {
+		if err := errs[i]; err != nil {
+			if d.stopped() {
+				// Acquiring was cut short by Stop
+				continue
+			}
+			// No other instance can take the lease before it expires, so carry on until then
+			d.publish(task.ErrorStatusf("Unable to renew lease on record '%s'. Error was:\n%v", r.Record, err).
+				WithKind(task.LeaseFailed).
+				ForRecord(r.Domain, r.Record))
+		} else {
+			d.leaseMu.Lock()
+			if acquired[i] {
+				d.leases[r.Key()] = expires
+			} else {
+				delete(d.leases, r.Key())
+			}
+			d.leaseMu.Unlock()
+		}
+		leader := d.leads(r)
+		if was, checked := leading[r.Key()]; checked && was == leader {
+			continue
+		}
+		leading[r.Key()] = leader
+		if leader {
+			d.publish(task.InfoStatusf("This instance, '%s', is now the leader for record '%s'", l.Holder, r.Record).
+				WithKind(task.LeadershipChanged).
+				ForRecord(r.Domain, r.Record))
+		} else {
+			d.publish(task.InfoStatusf("Another instance is the leader for record '%s', this instance, '%s', is standing by", r.Record, l.Holder).
+				WithKind(task.LeadershipChanged).
+				ForRecord(r.Domain, r.Record))
+		}
+		select {
+		case d.leaseChanged <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// release gives up the leases that this instance holds, so that a standby can take over without waiting for them to expire
+func (d *DDNSDaemon) release(records []conf.RecordConfig) {
+	if !d.leadership.enabled() {
+		return
+	}
+	// Renewing a lease after it was released would take it back
+	d.renewing.Wait()
+	for _, r := range records {
+		if !d.leads(r) {
+			continue
+		}
+		if err := d.leadership.Lease.Release(r, d.leadership.Holder); err != nil {
+			d.publish(task.ErrorStatusf("Unable to release lease on record '%s'. Error was:\n%v", r.Record, err).
+				WithKind(task.LeaseFailed).
+				ForRecord(r.Domain, r.Record))
+		}
+	}
+}
+
+// SetStore remembers when records with a force_update_interval were last written in store, it must be called before Start
+func (d *DDNSDaemon) SetStore(store Store) {
+	d.store = store
+}
+
+// lastPush returns when the record was last written according to the Store, or the zero time if that isn't known
+func (d *DDNSDaemon) lastPush(r conf.RecordConfig) time.Time {
+	if d.store == nil {
+		return time.Time{}
+	}
+	return d.store.LastPush(r.Key())
+}
+
+// pushed remembers in the Store that the record was written at t, if it has a force_update_interval
+func (d *DDNSDaemon) pushed(r conf.RecordConfig, t time.Time) error {
+	if d.store == nil || r.ForceUpdateInterval == 0 {
+		return nil
+	}
+	return errors.Annotatef(d.store.SetLastPush(r.Key(), t), "unable to save when record '%s' was written", r.Record)
+}
+
+// forceDue returns true if the record is due to be rewritten, whether or not it has changed
+func forceDue(r conf.RecordConfig, lastPush, now time.Time) bool {
+	return r.ForceUpdateInterval > 0 && !now.Before(lastPush.Add(r.ForceUpdateInterval))
+}
+
+// SetTarget handles records of the given type with target instead of the DDNSProvider, it must be called before Start
+func (d *DDNSDaemon) SetTarget(recordType string, target Target) {
+	d.targets[recordType] = target
+}
+
+// get reads the current IP of a record from its DDNSProvider or Target
+func (d *DDNSDaemon) get(r conf.RecordConfig) (string, error) {
+	if r.IsDNS() {
+		return d.ddnsProvider.Get(r.Domain, r.Record)
+	}
+	target, ok := d.targets[r.Type]
+	if !ok {
+		return "", errors.NotSupportedf("record type '%s'", r.Type)
+	}
+	return target.Get(r)
+}
+
+// update points a record at ip using its DDNSProvider or Target, writing it even if it is unchanged if force is set
+func (d *DDNSDaemon) update(r conf.RecordConfig, ip string, force bool) error {
+	if r.IsDNS() {
+		if force {
+			return d.ddnsProvider.ForceUpdate(r.Domain, r.Record, ip)
+		}
+		return d.ddnsProvider.Update(r.Domain, r.Record, ip)
+	}
+	target, ok := d.targets[r.Type]
+	if !ok {
+		return errors.NotSupportedf("record type '%s'", r.Type)
+	}
+	if force {
+		return target.ForceUpdate(r, ip)
+	}
+	return target.Update(r, ip)
+}
+
+// SetHeartbeat enables heartbeat TXT records, it must be called before Start
+func (d *DDNSDaemon) SetHeartbeat(heartbeat Heartbeat) {
+	d.heartbeat = heartbeat
+}
+
+// SetClock replaces the clock used to tell the time and to wait between checks, it must be called before Start
+func (d *DDNSDaemon) SetClock(clock Clock) {
+	d.clock = clock
+}
+
+// SetVerifier enables checking that each update has propagated, it must be called before Start
+func (d *DDNSDaemon) SetVerifier(verifier Verifier) {
+	d.verifier = verifier
+}
+
+// Events returns the bus that the daemon publishes its progress to
+func (d *DDNSDaemon) Events() *task.Bus {
+	return d.events
+}
+
+func (d *DDNSDaemon) publish(status task.Status) {
+	d.events.Publish(status)
+}
+
+// check compares a single record against the current public IP and updates it if they differ
+func (d *DDNSDaemon) check(rs *recordState, newIP string) error {
+	if err := checkPolicy(rs.RecordConfig, newIP); err != nil {
+		d.publish(task.ErrorStatusf("Refusing to publish '%s' to DNS record '%s', will retry. Error was:\n%v", newIP, rs.Record, err).
+			WithKind(task.AddressRejected).
+			ForRecord(rs.Domain, rs.Record).
+			WithIPs(rs.lastIP, newIP).
+			WithAttempt(rs.attempt + 1))
+		return err
+	}
+	dnsRecordIP, err := d.get(rs.RecordConfig)
+	if err != nil {
+		class, _ := Classify(err)
+		d.publish(task.ErrorStatusf("Unable to look up current DNS record '%s'. Error was:\n%v", rs.Record, err).
+			WithKind(task.LookupFailed).
+			ForRecord(rs.Domain, rs.Record).
+			WithAttempt(rs.attempt + 1).
+			WithErrorClass(string(class)))
+		return err
+	}
+
+	// Nothing has changed, log and move on, unless the record is due to be rewritten anyway
+	forced := newIP == rs.lastIP && newIP == dnsRecordIP
+	if forced && !forceDue(rs.RecordConfig, rs.lastPush, d.clock.Now()) {
+		d.publish(task.InfoStatusf(
+			"No IP change detected for '%s' since %s (%d seconds ago)",
+			rs.Record,
+			rs.lastIPUpdate.Format(time.RFC1123Z),
+			int(d.clock.Now().Sub(rs.lastIPUpdate).Seconds())).
+			WithKind(task.RecordInSync).
+			ForRecord(rs.Domain, rs.Record).
+			WithIPs(dnsRecordIP, newIP))
+		return nil
+	}
+
+	if forced {
+		d.publish(task.InfoStatusf("Rewriting DNS record '%s' with unchanged IP '%s', its force_update_interval of %s has passed", rs.Record, newIP, rs.ForceUpdateInterval).
+			WithKind(task.RecordForced).
+			ForRecord(rs.Domain, rs.Record).
+			WithIPs(dnsRecordIP, newIP))
+	} else if rs.lastIP == newIP && dnsRecordIP != newIP {
+		// Log line for no new IP, but mismatch with DNS record
+		d.publish(task.InfoStatusf("Public IP address did not change, but DNS record '%s' did not match, is '%s' but expected '%s', correcting", rs.Record, dnsRecordIP, newIP).
+			WithKind(task.RecordOutOfSync).
+			ForRecord(rs.Domain, rs.Record).
+			WithIPs(dnsRecordIP, newIP))
+	}
+
+	if !d.leads(rs.RecordConfig) {
+		// The lease expired while the record was being looked up, the instance taking over will update it
+		return nil
+	}
+
+	// Reach out to the actual DDNS provider and make the update
+	started := d.clock.Now()
+	err = d.update(rs.RecordConfig, newIP, forced)
+	if err != nil {
+		class, _ := Classify(err)
+		d.publish(task.ErrorStatusf("Unable to update DNS record '%s'. Error was:\n%v", rs.Record, err).
+			WithKind(task.UpdateFailed).
+			ForRecord(rs.Domain, rs.Record).
+			WithIPs(dnsRecordIP, newIP).
+			WithAttempt(rs.attempt + 1).
+			WithErrorClass(string(class)))
+		return err
+	}
+	rs.lastIP = newIP
+	rs.lastIPUpdate = d.clock.Now()
+	rs.lastPush = rs.lastIPUpdate
+	if err := d.pushed(rs.RecordConfig, rs.lastPush); err != nil {
+		// The record was updated, it may just be rewritten sooner than needed after a restart
+		d.publish(task.ErrorStatusf("%v", err).
+			WithKind(task.StateSaveFailed).
+			ForRecord(rs.Domain, rs.Record))
+	}
+	d.publish(task.InfoStatusf("DNS record '%s' now points to '%s'", rs.Record, newIP).
+		WithKind(task.RecordUpdated).
+		ForRecord(rs.Domain, rs.Record).
+		WithIPs(dnsRecordIP, newIP).
+		WithDuration(d.clock.Now().Sub(started)))
+	if d.verifier != nil && rs.IsDNS() {
+		if d.proxied(rs.RecordConfig) {
+			d.publish(task.InfoStatusf("Not verifying that DNS record '%s' propagated, it is proxied and served with CloudFlare's addresses", rs.Record).
+				WithKind(task.PropagationSkipped).
+				ForRecord(rs.Domain, rs.Record).
+				WithIPs("", newIP))
+		} else {
+			d.verifying.Add(1)
+			go d.verify(rs.RecordConfig, newIP)
+		}
+	}
+	return nil
+}
+
+// proxied returns true if the DDNSProvider knows the record to be proxied, see ProxyAware
+func (d *DDNSDaemon) proxied(r conf.RecordConfig) bool {
+	p, ok := d.ddnsProvider.(ProxyAware)
+	if !ok {
+		return false
+	}
+	proxied, _ := p.Proxied(r.Domain, r.Record)
+	return proxied
+}
+
+// verify waits for an update to reach the record's authoritative nameservers. It runs alongside the checks,
+// which would otherwise be held up for as long as the propagation timeout, and is cancelled by Stop.
+// The update itself succeeded, so a failure here is only reported and does not cause a retry.
+func (d *DDNSDaemon) verify(r conf.RecordConfig, newIP string) {
+	defer d.verifying.Done()
+	took, err := d.verifier.Verify(d.ctx, r.Domain, r.Record, newIP)
+	if err != nil {
+		d.publish(task.ErrorStatusf("Unable to verify that DNS record '%s' propagated. Error was:\n%v", r.Record, err).
+			WithKind(task.PropagationFailed).
+			ForRecord(r.Domain, r.Record).
+			WithIPs("", newIP).
+			WithDuration(took))
+		return
+	}
+	d.publish(task.InfoStatusf("DNS record '%s' is served as '%s' by all authoritative nameservers after %s", r.Record, newIP, took.Round(time.Millisecond)).
+		WithKind(task.PropagationVerified).
+		ForRecord(r.Domain, r.Record).
+		WithIPs("", newIP).
+		WithDuration(took))
+}
+
+// checkPolicy returns an error if the record's policy doesn't allow the address to be published to it
+func checkPolicy(r conf.RecordConfig, addr string) error {
+	policy, err := r.Policy()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(policy.Check(addr))
+}
+
+// beat refreshes the heartbeat record of each record that is due and returns how long until the next is due
+func (d *DDNSDaemon) beat(states map[string]*recordState, records []conf.RecordConfig, ip, source string) time.Duration {
+	if !d.heartbeat.enabled() {
+		return 0
+	}
+	now := d.clock.Now()
+	next := d.heartbeat.Interval
+	for _, r := range records {
+		rs := states[r.Key()]
+		if !rs.IsDNS() || !d.leads(r) {
+			continue
+		}
+		if due := rs.lastBeat.Add(d.heartbeat.Interval).Sub(now); due > 0 {
+			next = shortest(next, due)
+			continue
+		}
+		name := HeartbeatRecord(rs.Record)
+		content, err := d.heartbeat.render(rs, ip, source, now)
+		if err == nil {
+			err = d.heartbeat.Provider.UpdateTXT(rs.Domain, name, content)
+		}
+		if err != nil {
+			// Try again on the next check rather than waiting a whole interval
+			d.publish(task.ErrorStatusf("Unable to update heartbeat record '%s'. Error was:\n%v", name, err).
+				WithKind(task.HeartbeatFailed).
+				ForRecord(rs.Domain, name))
+			continue
+		}
+		rs.lastBeat = now
+		d.publish(task.InfoStatusf("Heartbeat record '%s' now reads '%s'", name, content).
+			WithKind(task.HeartbeatUpdated).
+			ForRecord(rs.Domain, name))
+	}
+	return next
+}
+
+// shortest returns the shorter of two durations, ignoring either one if it is zero
+func shortest(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// syncRecords adds state for newly configured records, due straight away unless their schedule doesn't allow it,
+// and drops state for removed ones, leaving records that are still configured untouched apart from their
+// configuration and schedule.
+func (d *DDNSDaemon) syncRecords(states map[string]*recordState, records []conf.RecordConfig, defaultInterval time.Duration) {
+	starting := len(states) == 0
+	configured := map[string]bool{}
+	for _, r := range records {
+		configured[r.Key()] = true
+		sched, err := r.Schedule(defaultInterval)
+		if err != nil {
+			// The configuration has already been validated, this is only a fallback
+			sched = schedule.Every(defaultInterval)
+		}
+		if rs, ok := states[r.Key()]; ok {
+			rs.RecordConfig = r
+			rs.schedule = sched
+			continue
+		}
+		if !starting {
+			d.publish(task.InfoStatusf("Now managing DNS record '%s' in domain '%s'", r.Record, r.Domain).
+				WithKind(task.RecordAdded).
+				ForRecord(r.Domain, r.Record))
+		}
+		rs := &recordState{RecordConfig: r, schedule: sched, lastPush: d.lastPush(r)}
+		if now := d.clock.Now(); !sched.Allows(now) {
+			// e.g. outside of the record's windows, which must be kept to after a restart or reload too
+			rs.due = sched.Next(now)
+		}
+		states[r.Key()] = rs
+	}
+	for key, rs := range states {
+		if !configured[key] {
+			d.publish(task.InfoStatusf("No longer managing DNS record '%s' in domain '%s'", rs.Record, rs.Domain).
+				WithKind(task.RecordRemoved).
+				ForRecord(rs.Domain, rs.Record))
+			delete(states, key)
+		}
+	}
+}
+
+// publishRecords copies per-record state into the daemon state, in configuration order
+func (d *DDNSDaemon) publishRecords(states map[string]*recordState, records []conf.RecordConfig) {
+	published := make([]RecordState, 0, len(records))
+	for _, r := range records {
+		rs := states[r.Key()]
+		s := RecordState{Domain: rs.Domain, Record: rs.Record, IP: rs.lastIP, LastUpdate: rs.lastIPUpdate, Standby: !d.leads(r)}
+		if rs.lastError != nil {
+			s.LastError = rs.lastError.Error()
+		}
+		published = append(published, s)
+	}
+	d.setState(func(s *DaemonState) { s.Records = published })
 }
 
 // StartWithDefaults calls Start but with default values
-func (d *DDNSDaemon) StartWithDefaults() chan task.Status {
+func (d *DDNSDaemon) StartWithDefaults() {
 	t := 10 * time.Second
-	return d.Start(t, t)
+	d.Start(t, t)
 }
 
 // Stop instructs the daemon to stop as soon as the current (if any) operation is finished
 func (d *DDNSDaemon) Stop() {
-	d.shouldRun = true
+	d.stopOnce.Do(func() {
+		close(d.stop)
+		d.cancel()
+	})
+}
+
+// Trigger requests an immediate check, cutting short any wait between checks. Records with windows or a cron
+// expression are only checked if their schedule allows it at the time.
+// Requests made while one is already pending are coalesced into one check.
+func (d *DDNSDaemon) Trigger() {
+	select {
+	case d.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Pause suspends checks and updates until Resume is called
+func (d *DDNSDaemon) Pause() {
+	d.setState(func(s *DaemonState) { s.Paused = true })
+}
+
+// Resume continues checking for updates after Pause, starting with an immediate check
+func (d *DDNSDaemon) Resume() {
+	d.setState(func(s *DaemonState) { s.Paused = false })
+	d.Trigger()
+}
+
+// State returns a snapshot of the daemon's current state
+func (d *DDNSDaemon) State() DaemonState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+func (d *DDNSDaemon) setState(fn func(s *DaemonState)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn(&d.state)
+}
+
+func (d *DDNSDaemon) setError(err error) {
+	d.setState(func(s *DaemonState) { s.LastError = err.Error() })
+}
+
+// stopped returns true once Stop has been called
+func (d *DDNSDaemon) stopped() bool {
+	select {
+	case <-d.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait blocks until the given duration has passed, a check is triggered, the leader of a record changes,
+// or the daemon is stopped. It returns true if a check was triggered.
+func (d *DDNSDaemon) wait(duration time.Duration) bool {
+	select {
+	case <-d.clock.After(duration):
+	case <-d.trigger:
+		return true
+	case <-d.leaseChanged:
+	case <-d.stop:
+	}
+	return false
 }
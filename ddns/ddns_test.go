@@ -1,11 +1,16 @@
 package ddns
 
 import (
+	"context"
 	"fmt"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/task"
 	"github.com/mattolenik/cloudflare-ddns-client/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -24,10 +29,13 @@ func TestUpdate(t *testing.T) {
 	configProvider := NewMockConfigProvider(ctrl)
 	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
 
-	configProvider.EXPECT().Get().Return(domain, record, nil)
-	ipProvider.EXPECT().Get().Return(ip, nil)
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{{Domain: domain, Record: record}}, nil)
+	ipProvider.EXPECT().Get(gomock.Any()).Return(ip, "test", nil)
 	ddnsProvider.EXPECT().Update(gomock.Eq(domain), gomock.Eq(record), gomock.Eq(ip)).Return(nil).Times(1)
-	ddnsProvider.EXPECT().Get(domain, record).Return(ip, nil).Times(1)
+	gomock.InOrder(
+		ddnsProvider.EXPECT().Get(domain, record).Return("", nil).Times(1),
+		ddnsProvider.EXPECT().Get(domain, record).Return(ip, nil).Times(1),
+	)
 	assert.NoError(ddnsDaemon.Update())
 
 	actualIP, err := ddnsProvider.Get(domain, record)
@@ -35,6 +43,106 @@ func TestUpdate(t *testing.T) {
 	assert.Equal(ip, actualIP)
 }
 
+func TestUpdateErrorTypes(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+
+	ipProvider.EXPECT().Get(gomock.Any()).Return("", "", fmt.Errorf("no route to host")).Times(1)
+	var ipErr *IPDetectionError
+	assert.ErrorAs(ddnsDaemon.Update(), &ipErr)
+
+	records := []conf.RecordConfig{{Domain: "abc.com", Record: "a.abc.com"}, {Domain: "abc.com", Record: "b.abc.com"}}
+	configProvider.EXPECT().Get().Return(records, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	ddnsProvider.EXPECT().Get(gomock.Any(), gomock.Any()).Return("", nil).AnyTimes()
+	ddnsProvider.EXPECT().Update("abc.com", "a.abc.com", "1.1.1.1").Return(fmt.Errorf("connection reset")).Times(1)
+	ddnsProvider.EXPECT().Update("abc.com", "b.abc.com", "1.1.1.1").Return(&ProviderError{Class: ClassAuth, Err: fmt.Errorf("invalid token")}).Times(1)
+	err := ddnsDaemon.Update()
+	var perr *ProviderError
+	if assert.ErrorAs(err, &perr) {
+		assert.Equal(ClassTransient, perr.Class, "unclassified failures are expected to be transient provider errors")
+	}
+	assert.Contains(err.Error(), "invalid token", "every failure must be reported")
+}
+
+func TestUpdateRecords(t *testing.T) {
+	assert, require, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	current := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com"}
+	stale := conf.RecordConfig{Domain: "abc.com", Record: "b.abc.com"}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{current, stale}, nil)
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil)
+	ddnsProvider.EXPECT().Get(current.Domain, current.Record).Return("1.1.1.1", nil)
+	ddnsProvider.EXPECT().Get(stale.Domain, stale.Record).Return("2.2.2.2", nil)
+	// The record that is already current must not be updated
+	ddnsProvider.EXPECT().Update(stale.Domain, stale.Record, "1.1.1.1").Return(nil).Times(1)
+
+	result, err := ddnsDaemon.UpdateRecords()
+	require.NoError(err)
+	assert.Equal("1.1.1.1", result.IP)
+	assert.Equal([]conf.RecordConfig{stale}, result.Updated)
+	assert.Equal([]conf.RecordConfig{current}, result.Unchanged)
+}
+
+func TestUpdateRecordsForced(t *testing.T) {
+	assert, require, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	due := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com", ForceUpdateInterval: 24 * time.Hour}
+	recent := conf.RecordConfig{Domain: "abc.com", Record: "b.abc.com", ForceUpdateInterval: 24 * time.Hour}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	store := NewMockStore(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetClock(&fakeClock{now: now})
+	ddnsDaemon.SetStore(store)
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{due, recent}, nil)
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil)
+	ddnsProvider.EXPECT().Get("abc.com", gomock.Any()).Return("1.1.1.1", nil).Times(2)
+	store.EXPECT().LastPush(due.Key()).Return(now.Add(-25 * time.Hour))
+	store.EXPECT().LastPush(recent.Key()).Return(now.Add(-time.Hour))
+	// Only the record that hasn't been written for longer than its interval is rewritten
+	ddnsProvider.EXPECT().ForceUpdate(due.Domain, due.Record, "1.1.1.1").Return(nil).Times(1)
+	store.EXPECT().SetLastPush(due.Key(), now).Return(nil).Times(1)
+
+	result, err := ddnsDaemon.UpdateRecords()
+	require.NoError(err)
+	assert.Empty(result.Updated, "a forced rewrite doesn't change the record")
+	assert.Equal([]conf.RecordConfig{due}, result.Forced)
+	assert.Equal([]conf.RecordConfig{recent}, result.Unchanged)
+}
+
+func TestUpdateRecordsStoreFails(t *testing.T) {
+	assert, require, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	record := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com", ForceUpdateInterval: 24 * time.Hour}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	store := NewMockStore(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetStore(store)
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{record}, nil)
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil)
+	ddnsProvider.EXPECT().Get(record.Domain, record.Record).Return("2.2.2.2", nil)
+	ddnsProvider.EXPECT().Update(record.Domain, record.Record, "1.1.1.1").Return(nil)
+	store.EXPECT().SetLastPush(record.Key(), gomock.Any()).Return(fmt.Errorf("disk full"))
+
+	result, err := ddnsDaemon.UpdateRecords()
+	require.NoError(err, "the record was updated, so the run must not fail because the state file couldn't be saved")
+	assert.Equal([]conf.RecordConfig{record}, result.Updated)
+	require.Len(result.Warnings, 1)
+	assert.Contains(result.Warnings[0].Error(), "disk full")
+}
+
 type TestFlow struct {
 	t           *testing.T
 	assert      *assert.Assertions
@@ -68,10 +176,10 @@ func TestDaemon(t *testing.T) {
 	record := "xyz.abc.com"
 	currentSuffix := 0
 	currentIP := ""
-	ipGen := func() (string, error) {
+	ipGen := func(ctx context.Context) (string, string, error) {
 		currentSuffix++
 		currentIP = fmt.Sprintf("1.1.1.%d", currentSuffix)
-		return currentIP, nil
+		return currentIP, "test", nil
 	}
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -80,22 +188,350 @@ func TestDaemon(t *testing.T) {
 
 	daemon.Should("run", func(t *testing.T, assert *assert.Assertions, require *require.Assertions) {
 		getCurrentIP := func() interface{} { return currentIP }
+		updated := make(chan string, 10)
 
-		ddnsDaemon.Start(updatePeriod, retryDelay)
-
-		configProvider.EXPECT().Get().Return(domain, record, nil)
-		ipProvider.EXPECT().Get().DoAndReturn(ipGen)
-		//ddnsProvider.EXPECT().Update(gomock.Eq(domain), gomock.Eq(record), gomock.Eq(ip)).Return(nil).Times(1)
-		//ddnsProvider.EXPECT().Get(domain, record).Return(ip, nil).Times(1)
+		configProvider.EXPECT().Get().Return([]conf.RecordConfig{{Domain: domain, Record: record}}, nil).AnyTimes()
+		ipProvider.EXPECT().Get(gomock.Any()).DoAndReturn(ipGen).AnyTimes()
+		ddnsProvider.EXPECT().Get(domain, record).Return("", nil).AnyTimes()
 		ddnsProvider.EXPECT().
 			Update(
 				gomock.Eq(domain),
 				gomock.Eq(record),
 				FnMatch(gomock.Eq, getCurrentIP),
-			).AnyTimes()
+			).
+			DoAndReturn(func(domain, record, ip string) error {
+				updated <- ip
+				return nil
+			}).
+			MinTimes(2)
+
+		events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+		ddnsDaemon.Start(updatePeriod, retryDelay)
+		assert.Equal("1.1.1.1", <-updated)
+		assert.Equal("1.1.1.2", <-updated)
+		ddnsDaemon.Stop()
+		for s := range events.Events() {
+			require.NotEqual(task.Fatal, s.Type, s.Message)
+		}
 	})
 }
 
+func TestDaemonTrigger(t *testing.T) {
+	_, require, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	domain := "abc.com"
+	record := "xyz.abc.com"
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	checked := make(chan struct{}, 10)
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{{Domain: domain, Record: record}}, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	ddnsProvider.EXPECT().Update(domain, record, "1.1.1.1").Return(nil).Times(1)
+	ddnsProvider.EXPECT().Get(domain, record).DoAndReturn(func(domain, record string) (string, error) {
+		checked <- struct{}{}
+		return "1.1.1.1", nil
+	}).MinTimes(2)
+
+	// The update period is far longer than the test, so the second check can only come from Trigger
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	<-checked
+	ddnsDaemon.Trigger()
+	select {
+	case <-checked:
+	case <-time.After(5 * time.Second):
+		require.Fail("expected Trigger to cause an immediate check")
+	}
+	ddnsDaemon.Stop()
+	for range events.Events() {
+	}
+}
+
+func TestDaemonRecordsChange(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	a := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com"}
+	b := conf.RecordConfig{Domain: "abc.com", Record: "b.abc.com"}
+	ddnsProvider, ipProvider, _ := fixtures(ctrl)
+	configProvider := NewDefaultConfigProvider()
+	configProvider.Set([]conf.RecordConfig{a})
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	checked := make(chan string, 10)
+
+	var mu sync.Mutex
+	dns := map[string]string{}
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	ddnsProvider.EXPECT().Get(gomock.Any(), gomock.Any()).DoAndReturn(func(domain, record string) (string, error) {
+		checked <- record
+		mu.Lock()
+		defer mu.Unlock()
+		return dns[record], nil
+	}).AnyTimes()
+	setDNS := func(domain, record, ip string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		dns[record] = ip
+		return nil
+	}
+	// The unchanged record must keep its state and not be updated again because another record was added
+	ddnsProvider.EXPECT().Update(a.Domain, a.Record, "1.1.1.1").DoAndReturn(setDNS).Times(1)
+	ddnsProvider.EXPECT().Update(b.Domain, b.Record, "1.1.1.1").DoAndReturn(setDNS).Times(1)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	assert.Equal(a.Record, <-checked)
+	configProvider.Set([]conf.RecordConfig{a, b})
+	ddnsDaemon.Trigger()
+	assert.Equal(a.Record, <-checked)
+	assert.Equal(b.Record, <-checked)
+	ddnsDaemon.Stop()
+	for range events.Events() {
+	}
+	records := ddnsDaemon.State().Records
+	assert.Len(records, 2)
+}
+
+func TestDaemonRejectsNonPublicIP(t *testing.T) {
+	assert, require, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	internal := conf.RecordConfig{Domain: "abc.com", Record: "lan.abc.com", Allow: []string{"100.64.0.0/10"}}
+	public := conf.RecordConfig{Domain: "abc.com", Record: "xyz.abc.com"}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{internal, public}, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("100.64.1.2", "test", nil).AnyTimes()
+	ddnsProvider.EXPECT().Get(internal.Domain, internal.Record).Return("100.64.1.2", nil).AnyTimes()
+	// The public record must never be looked up or updated with the CGNAT address
+	ddnsProvider.EXPECT().Update(internal.Domain, internal.Record, "100.64.1.2").Return(nil).Times(1)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	for s := range events.Events() {
+		if s.Kind == task.AddressRejected {
+			assert.Equal(public.Record, s.Record)
+			ddnsDaemon.Stop()
+		}
+	}
+	records := ddnsDaemon.State().Records
+	require.Len(records, 2)
+	assert.Empty(records[0].LastError)
+	assert.Contains(records[1].LastError, "CGNAT")
+}
+
+func TestDaemonVerifiesPropagation(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	domain := "abc.com"
+	record := "xyz.abc.com"
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	verifier := NewMockVerifier(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetVerifier(verifier)
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{{Domain: domain, Record: record}}, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	ddnsProvider.EXPECT().Get(domain, record).Return("", nil).AnyTimes()
+	ddnsProvider.EXPECT().Update(domain, record, "1.1.1.1").Return(nil).Times(1)
+	verifier.EXPECT().Verify(gomock.Any(), domain, record, "1.1.1.1").Return(1500*time.Millisecond, nil).Times(1)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	for s := range events.Events() {
+		if s.Kind == task.PropagationVerified {
+			assert.Equal(record, s.Record)
+			assert.Equal(1500*time.Millisecond, s.Duration)
+			ddnsDaemon.Stop()
+		}
+	}
+}
+
+func TestDaemonVerifiesInBackground(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	records := []conf.RecordConfig{{Domain: "abc.com", Record: "a.abc.com"}, {Domain: "abc.com", Record: "b.abc.com"}}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	verifier := NewMockVerifier(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetVerifier(verifier)
+
+	configProvider.EXPECT().Get().Return(records, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	ddnsProvider.EXPECT().Get("abc.com", gomock.Any()).Return("", nil).AnyTimes()
+	ddnsProvider.EXPECT().Update("abc.com", "a.abc.com", "1.1.1.1").Return(nil).Times(1)
+	// Only reached if checks carry on while the first record's update is still being verified
+	ddnsProvider.EXPECT().Update("abc.com", "b.abc.com", "1.1.1.1").DoAndReturn(func(domain, record, ip string) error {
+		ddnsDaemon.Stop()
+		return nil
+	}).Times(1)
+	verifier.EXPECT().Verify(gomock.Any(), "abc.com", gomock.Any(), "1.1.1.1").DoAndReturn(func(ctx context.Context, domain, record, ip string) (time.Duration, error) {
+		<-ctx.Done()
+		return time.Second, ctx.Err()
+	}).Times(2)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	failed := 0
+	for s := range events.Events() {
+		if s.Kind == task.PropagationFailed {
+			failed++
+		}
+	}
+	assert.Equal(2, failed, "verifications must be cancelled by Stop, and reported before the daemon has stopped")
+}
+
+// proxyAwareProvider reports the records in proxied as proxied
+type proxyAwareProvider struct {
+	*MockDDNSProvider
+	proxied map[string]bool
+}
+
+func (p proxyAwareProvider) Proxied(domain, record string) (bool, bool) {
+	return p.proxied[record], true
+}
+
+func TestDaemonSkipsVerifyingProxied(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	records := []conf.RecordConfig{{Domain: "abc.com", Record: "proxied.abc.com"}, {Domain: "abc.com", Record: "direct.abc.com"}}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	verifier := NewMockVerifier(ctrl)
+	ddnsDaemon := NewDefaultDaemon(proxyAwareProvider{ddnsProvider, map[string]bool{"proxied.abc.com": true}}, ipProvider, configProvider)
+	ddnsDaemon.SetVerifier(verifier)
+
+	configProvider.EXPECT().Get().Return(records, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	ddnsProvider.EXPECT().Get("abc.com", gomock.Any()).Return("", nil).AnyTimes()
+	ddnsProvider.EXPECT().Update("abc.com", gomock.Any(), "1.1.1.1").Return(nil).Times(2)
+	verifier.EXPECT().Verify(gomock.Any(), "abc.com", "direct.abc.com", "1.1.1.1").Return(time.Second, nil).Times(1)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	var skipped []string
+	for s := range events.Events() {
+		switch s.Kind {
+		case task.PropagationSkipped:
+			skipped = append(skipped, s.Record)
+		case task.PropagationVerified:
+			ddnsDaemon.Stop()
+		}
+	}
+	assert.Equal([]string{"proxied.abc.com"}, skipped, "nameservers answer for proxied records with CloudFlare's addresses, so they can't be verified")
+}
+
+func TestUpdateTargets(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	dns := conf.RecordConfig{Domain: "abc.com", Record: "xyz.abc.com"}
+	list := conf.RecordConfig{Type: conf.TypeIPList, Record: "office", Account: "123", List: "allow"}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	target := NewMockTarget(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetTarget(conf.TypeIPList, target)
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{dns, list}, nil)
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil)
+	ddnsProvider.EXPECT().Get(dns.Domain, dns.Record).Return("", nil)
+	ddnsProvider.EXPECT().Update(dns.Domain, dns.Record, "1.1.1.1").Return(nil).Times(1)
+	target.EXPECT().Get(list).Return("", nil)
+	target.EXPECT().Update(list, "1.1.1.1").Return(nil).Times(1)
+	assert.NoError(ddnsDaemon.Update())
+
+	// Records of a type with no target registered fail rather than being sent to the DDNSProvider
+	ddnsDaemon = NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{list}, nil)
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil)
+	assert.Error(ddnsDaemon.Update())
+}
+
+func TestDaemonStopsOnAuthError(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+
+	records := []conf.RecordConfig{{Domain: "abc.com", Record: "a.abc.com"}, {Domain: "abc.com", Record: "b.abc.com"}}
+	configProvider.EXPECT().Get().Return(records, nil).Times(1)
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).Times(1)
+	ddnsProvider.EXPECT().Get("abc.com", "a.abc.com").Return("", &ProviderError{Class: ClassAuth, Err: fmt.Errorf("invalid token")}).Times(1)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Millisecond, time.Millisecond)
+	var fatal *task.Status
+	for s := range events.Events() {
+		if s.Type == task.Fatal {
+			fatal = &s
+		}
+	}
+	// The events channel is only closed once the daemon has stopped by itself
+	if assert.NotNil(fatal) {
+		assert.Equal(string(ClassAuth), fatal.ErrorClass)
+	}
+}
+
+func TestDaemonBacksOffWhenRateLimited(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+
+	records := []conf.RecordConfig{{Domain: "abc.com", Record: "a.abc.com"}, {Domain: "abc.com", Record: "b.abc.com"}}
+	configProvider.EXPECT().Get().Return(records, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	// The second record must not be looked up while rate limited
+	ddnsProvider.EXPECT().Get("abc.com", "a.abc.com").Return("", &ProviderError{Class: ClassRateLimited, RetryAfter: time.Hour, Err: fmt.Errorf("slow down")}).Times(1)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Millisecond, time.Millisecond)
+	for s := range events.Events() {
+		if s.Kind == task.LookupFailed {
+			assert.Equal(string(ClassRateLimited), s.ErrorClass)
+		}
+		if s.Kind == task.RateLimited {
+			assert.Equal(time.Hour, s.Duration)
+			ddnsDaemon.Stop()
+		}
+	}
+}
+
+func TestDaemonRetriesNotFoundImmediately(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	domain := "abc.com"
+	record := "xyz.abc.com"
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{{Domain: domain, Record: record}}, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	gomock.InOrder(
+		ddnsProvider.EXPECT().Get(domain, record).Return("", &ProviderError{Class: ClassNotFound, Err: fmt.Errorf("no such zone")}),
+		ddnsProvider.EXPECT().Get(domain, record).Return("", nil),
+	)
+	ddnsProvider.EXPECT().Update(domain, record, "1.1.1.1").Return(nil).Times(1)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	// Neither the update period nor the retry delay pass during the test, so the retry must be immediate
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	for s := range events.Events() {
+		if s.Kind == task.RecordUpdated {
+			assert.Equal(record, s.Record)
+			ddnsDaemon.Stop()
+		}
+	}
+}
+
 func fixtures(ctrl *gomock.Controller) (ddnsProvider *MockDDNSProvider, ipProvider *MockIPProvider, configProvider *MockConfigProvider) {
 	return NewMockDDNSProvider(ctrl),
 		NewMockIPProvider(ctrl),
@@ -122,3 +558,455 @@ func (f *funcMatcher) Matches(x interface{}) bool {
 func (f *funcMatcher) String() string {
 	return "runs underlying match against new value each time"
 }
+
+// fakeClock only moves forward when the daemon waits for it, so that schedules can be tested without waiting
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+	// hold makes the next wait never time out, so that it can only end with a trigger
+	hold bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if c.hold {
+		c.hold = false
+		return ch
+	}
+	c.now = c.now.Add(d)
+	ch <- c.now
+	return ch
+}
+
+// advance moves the clock forward, as if a call took d
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestDaemonSchedules(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	records := []conf.RecordConfig{
+		{Domain: "abc.com", Record: "a.abc.com", Interval: time.Minute},
+		{Domain: "abc.com", Record: "b.abc.com", Interval: 2 * time.Minute},
+		{Domain: "abc.com", Record: "c.abc.com", Cron: "*/3 * * * *"},
+	}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetClock(clock)
+
+	var mu sync.Mutex
+	checks := map[string][]time.Duration{}
+	detections := 0
+	configProvider.EXPECT().Get().Return(records, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).DoAndReturn(func(ctx context.Context) (string, string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		detections++
+		return "1.1.1.1", "test", nil
+	}).AnyTimes()
+	ddnsProvider.EXPECT().Get("abc.com", gomock.Any()).DoAndReturn(func(domain, record string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		checks[record] = append(checks[record], clock.Now().Sub(start))
+		if clock.Now().Sub(start) >= 6*time.Minute {
+			ddnsDaemon.Stop()
+		}
+		return "1.1.1.1", nil
+	}).AnyTimes()
+	ddnsProvider.EXPECT().Update("abc.com", gomock.Any(), "1.1.1.1").Return(nil).Times(len(records))
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	for range events.Events() {
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal([]time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute, 4 * time.Minute, 5 * time.Minute, 6 * time.Minute}, checks["a.abc.com"])
+	assert.Equal([]time.Duration{0, 2 * time.Minute, 4 * time.Minute, 6 * time.Minute}, checks["b.abc.com"])
+	assert.Equal([]time.Duration{0, 3 * time.Minute, 6 * time.Minute}, checks["c.abc.com"])
+	assert.Equal(7, detections, "the IP must be detected once for all the records due at the same time")
+}
+
+func TestDaemonSchedulesSlowChecks(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	records := []conf.RecordConfig{
+		{Domain: "abc.com", Record: "a.abc.com", Interval: time.Minute},
+		{Domain: "abc.com", Record: "b.abc.com", Interval: time.Minute},
+		{Domain: "abc.com", Record: "c.abc.com", Interval: time.Minute},
+	}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetClock(clock)
+
+	var mu sync.Mutex
+	checks := map[string]int{}
+	detections := 0
+	configProvider.EXPECT().Get().Return(records, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).DoAndReturn(func(ctx context.Context) (string, string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		detections++
+		clock.advance(time.Second)
+		return "1.1.1.1", "test", nil
+	}).AnyTimes()
+	// Every lookup takes a while, so each record is checked later than the one before it
+	ddnsProvider.EXPECT().Get("abc.com", gomock.Any()).DoAndReturn(func(domain, record string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		checks[record]++
+		if clock.Now().Sub(start) >= 5*time.Minute {
+			ddnsDaemon.Stop()
+		}
+		clock.advance(2 * time.Second)
+		return "1.1.1.1", nil
+	}).AnyTimes()
+	ddnsProvider.EXPECT().Update("abc.com", gomock.Any(), "1.1.1.1").DoAndReturn(func(domain, record, ip string) error {
+		clock.advance(2 * time.Second)
+		return nil
+	}).Times(len(records))
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	for range events.Events() {
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(map[string]int{"a.abc.com": 6, "b.abc.com": 6, "c.abc.com": 6}, checks)
+	assert.Equal(6, detections, "records due at the same time must stay together however long their checks take")
+}
+
+func TestDaemonKeepsToWindows(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	// A Monday, long before the window opens
+	start := time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	windowed := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com", Interval: time.Hour, Windows: []string{"Mon-Fri 09:00-17:00"}}
+	plain := conf.RecordConfig{Domain: "abc.com", Record: "b.abc.com", Interval: time.Hour}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetClock(clock)
+
+	var mu sync.Mutex
+	checks := map[string][]time.Duration{}
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{windowed, plain}, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	ddnsProvider.EXPECT().Get("abc.com", gomock.Any()).DoAndReturn(func(domain, record string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		checks[record] = append(checks[record], clock.Now().Sub(start))
+		if record == plain.Record && len(checks[record]) == 1 {
+			clock.mu.Lock()
+			clock.hold = true
+			clock.mu.Unlock()
+			ddnsDaemon.Trigger()
+		}
+		if record == windowed.Record {
+			ddnsDaemon.Stop()
+		}
+		return "1.1.1.1", nil
+	}).AnyTimes()
+	ddnsProvider.EXPECT().Update("abc.com", gomock.Any(), "1.1.1.1").Return(nil).Times(2)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	for range events.Events() {
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal([]time.Duration{6 * time.Hour}, checks[windowed.Record], "neither starting up nor a trigger may check a record outside of its windows")
+	assert.Equal([]time.Duration{0, 0, time.Hour, 2 * time.Hour, 3 * time.Hour, 4 * time.Hour, 5 * time.Hour, 6 * time.Hour}, checks[plain.Record])
+}
+
+func TestDaemonForcesUpdates(t *testing.T) {
+	assert, require, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	record := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com", Cron: "@daily", ForceUpdateInterval: 3 * time.Minute}
+	store, err := OpenFileStore(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(err)
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetClock(clock)
+	ddnsDaemon.SetStore(store)
+
+	var mu sync.Mutex
+	var updates []time.Duration
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{record}, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	ddnsProvider.EXPECT().Get(record.Domain, record.Record).Return("1.1.1.1", nil).AnyTimes()
+	update := func(domain, record, ip string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		updates = append(updates, clock.Now().Sub(start))
+		if len(updates) == 3 {
+			ddnsDaemon.Stop()
+		}
+		return nil
+	}
+	// The first check finds the record for the first time, only the rewrites are forced
+	ddnsProvider.EXPECT().Update(record.Domain, record.Record, "1.1.1.1").DoAndReturn(update).Times(1)
+	ddnsProvider.EXPECT().ForceUpdate(record.Domain, record.Record, "1.1.1.1").DoAndReturn(update).Times(2)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	forced := 0
+	for s := range events.Events() {
+		if s.Kind == task.RecordForced {
+			forced++
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The cron schedule isn't due again until midnight, so the later checks must come from the force_update_interval
+	assert.Equal([]time.Duration{0, 3 * time.Minute, 6 * time.Minute}, updates)
+	assert.Equal(2, forced)
+	assert.True(start.Add(6*time.Minute).Equal(store.LastPush(record.Key())), "the last rewrite must be saved")
+}
+
+func TestDaemonFallback(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	record := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com", Interval: time.Minute, Fallback: "192.0.2.10", FallbackAfter: 5 * time.Minute}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetClock(clock)
+
+	var mu sync.Mutex
+	dns := ""
+	var updates []string
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{record}, nil).AnyTimes()
+	// The public IP is lost from the first minute until the tenth
+	ipProvider.EXPECT().Get(gomock.Any()).DoAndReturn(func(ctx context.Context) (string, string, error) {
+		if elapsed := clock.Now().Sub(start); elapsed > 0 && elapsed < 10*time.Minute {
+			return "", "", fmt.Errorf("no route to host")
+		}
+		return "1.1.1.1", "test", nil
+	}).AnyTimes()
+	ddnsProvider.EXPECT().Get(record.Domain, record.Record).DoAndReturn(func(domain, record string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return dns, nil
+	}).AnyTimes()
+	ddnsProvider.EXPECT().Update(record.Domain, record.Record, gomock.Any()).DoAndReturn(func(domain, record, ip string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		dns = ip
+		updates = append(updates, fmt.Sprintf("%s at %s", ip, clock.Now().Sub(start)))
+		if len(updates) == 3 {
+			ddnsDaemon.Stop()
+		}
+		return nil
+	}).Times(3)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Minute)
+	var kinds []task.Kind
+	for s := range events.Events() {
+		if s.Kind == task.RecordParked || s.Kind == task.RecordRestored {
+			kinds = append(kinds, s.Kind)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal([]string{"1.1.1.1 at 0s", "192.0.2.10 at 5m0s", "1.1.1.1 at 10m0s"}, updates)
+	assert.Equal([]task.Kind{task.RecordParked, task.RecordRestored}, kinds)
+}
+
+func TestDaemonShutdownActions(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	deleted := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com", OnShutdown: conf.ShutdownDelete}
+	parked := conf.RecordConfig{Domain: "abc.com", Record: "b.abc.com", OnShutdown: conf.ShutdownFallback, Fallback: "192.0.2.10"}
+	kept := conf.RecordConfig{Domain: "abc.com", Record: "c.abc.com"}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	checked := make(chan struct{}, 10)
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{deleted, parked, kept}, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	ddnsProvider.EXPECT().Get("abc.com", gomock.Any()).Return("1.1.1.1", nil).AnyTimes()
+	ddnsProvider.EXPECT().Update("abc.com", gomock.Any(), "1.1.1.1").DoAndReturn(func(domain, record, ip string) error {
+		checked <- struct{}{}
+		return nil
+	}).Times(3)
+	// The record without an on_shutdown action must be left alone
+	ddnsProvider.EXPECT().Delete(deleted.Domain, deleted.Record).Return(nil).Times(1)
+	ddnsProvider.EXPECT().Update(parked.Domain, parked.Record, "192.0.2.10").Return(nil).Times(1)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	for i := 0; i < 3; i++ {
+		<-checked
+	}
+	ddnsDaemon.Stop()
+	var kinds []task.Kind
+	for s := range events.Events() {
+		if s.Kind == task.RecordDeleted || s.Kind == task.RecordParked {
+			kinds = append(kinds, s.Kind)
+		}
+	}
+	assert.Equal([]task.Kind{task.RecordDeleted, task.RecordParked}, kinds)
+}
+
+func TestDaemonLeadership(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	record := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com", Interval: time.Minute}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	lease := NewMockLease(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetLeadership(Leadership{Lease: lease, Holder: "standby", TTL: 30 * time.Millisecond})
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{record}, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	// Another instance holds the lease until it stops renewing it, after which this one takes over
+	var mu sync.Mutex
+	attempts := 0
+	lease.EXPECT().Acquire(gomock.Any(), record, "standby", gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, r conf.RecordConfig, holder string, now, expires time.Time) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(now.Add(30*time.Millisecond), expires)
+		attempts++
+		return attempts >= 3, nil
+	}).MinTimes(3)
+	ddnsProvider.EXPECT().Get(record.Domain, record.Record).Return("2.2.2.2", nil).Times(1)
+	ddnsProvider.EXPECT().Update(record.Domain, record.Record, "1.1.1.1").DoAndReturn(func(domain, record, ip string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.GreaterOrEqual(attempts, 3, "the record must only be updated once this instance is the leader")
+		ddnsDaemon.Stop()
+		return nil
+	}).Times(1)
+	lease.EXPECT().Release(record, "standby").Return(nil).Times(1)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	var messages []string
+	for s := range events.Events() {
+		if s.Kind == task.LeadershipChanged {
+			messages = append(messages, s.Message)
+		}
+	}
+	assert.Equal([]string{
+		"Another instance is the leader for record 'a.abc.com', this instance, 'standby', is standing by",
+		"This instance, 'standby', is now the leader for record 'a.abc.com'",
+	}, messages)
+}
+
+func TestDaemonRenewsConcurrently(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	slow := conf.RecordConfig{Domain: "abc.com", Record: "slow.abc.com"}
+	fast := conf.RecordConfig{Domain: "abc.com", Record: "fast.abc.com"}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	lease := NewMockLease(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetLeadership(Leadership{Lease: lease, Holder: "leader", TTL: time.Hour})
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{slow, fast}, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	ddnsProvider.EXPECT().Get("abc.com", gomock.Any()).Return("1.1.1.1", nil).AnyTimes()
+	ddnsProvider.EXPECT().Update("abc.com", gomock.Any(), "1.1.1.1").Return(nil).AnyTimes()
+	// The slow lease can only be acquired once the fast one has been, so renewing them one after the other would time out
+	fastAcquired := make(chan struct{})
+	lease.EXPECT().Acquire(gomock.Any(), fast, "leader", gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, r conf.RecordConfig, holder string, now, expires time.Time) (bool, error) {
+		close(fastAcquired)
+		return true, nil
+	}).Times(1)
+	lease.EXPECT().Acquire(gomock.Any(), slow, "leader", gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, r conf.RecordConfig, holder string, now, expires time.Time) (bool, error) {
+		select {
+		case <-fastAcquired:
+			return true, nil
+		case <-time.After(time.Second):
+			return false, fmt.Errorf("timed out")
+		}
+	}).Times(1)
+	lease.EXPECT().Release(gomock.Any(), "leader").Return(nil).AnyTimes()
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	leaders := 0
+	for s := range events.Events() {
+		assert.NotEqual(task.LeaseFailed, s.Kind, s.Message)
+		if s.Kind == task.LeadershipChanged {
+			leaders++
+			if leaders == 2 {
+				ddnsDaemon.Stop()
+			}
+		}
+	}
+	assert.Equal(2, leaders)
+}
+
+func TestDaemonLeaseLapsesDuringCheck(t *testing.T) {
+	assert, _, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	record := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com"}
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	lease := NewMockLease(ctrl)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetLeadership(Leadership{Lease: lease, Holder: "leader", TTL: 30 * time.Millisecond})
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{record}, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	// The lease can't be renewed after it was first acquired, so another instance may take it once it expires
+	first := lease.EXPECT().Acquire(gomock.Any(), record, "leader", gomock.Any(), gomock.Any()).Return(true, nil).Times(1)
+	lease.EXPECT().Acquire(gomock.Any(), record, "leader", gomock.Any(), gomock.Any()).Return(false, fmt.Errorf("unreachable")).After(first).AnyTimes()
+	lost := make(chan struct{})
+	// The lookup is slow enough for the lease to expire, so the record must not be updated, nor the lease released
+	ddnsProvider.EXPECT().Get(record.Domain, record.Record).DoAndReturn(func(domain, record string) (string, error) {
+		<-lost
+		ddnsDaemon.Stop()
+		return "2.2.2.2", nil
+	}).Times(1)
+
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	leader := false
+	for s := range events.Events() {
+		if s.Kind != task.LeadershipChanged {
+			continue
+		}
+		if !leader {
+			leader = true
+			continue
+		}
+		assert.Equal("Another instance is the leader for record 'a.abc.com', this instance, 'leader', is standing by", s.Message)
+		close(lost)
+	}
+	assert.True(leader)
+}
@@ -0,0 +1,84 @@
+package ddns
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// ErrorClass says what kind of failure a provider ran into, and so how the daemon reacts to it
+type ErrorClass string
+
+const (
+	// ClassAuth means the credentials were rejected, the daemon stops as retrying can't help
+	ClassAuth ErrorClass = "auth"
+	// ClassPermission means the credentials lack a permission, retried every update period
+	ClassPermission ErrorClass = "permission"
+	// ClassNotFound means something, e.g. a cached zone ID, no longer exists. Providers forget cached IDs
+	// when they see this, so the first one is retried immediately.
+	ClassNotFound ErrorClass = "not_found"
+	// ClassRateLimited means too many requests were made, the daemon backs off for the RetryAfter period
+	ClassRateLimited ErrorClass = "rate_limited"
+	// ClassTransient means the provider had a temporary problem, e.g. a 5xx, retried after the retry delay
+	ClassTransient ErrorClass = "transient"
+	// ClassValidation means the request was rejected as invalid, retried every update period
+	ClassValidation ErrorClass = "validation"
+)
+
+// DefaultRetryAfter is how long to back off when rate limited without being told how long to wait
+const DefaultRetryAfter = time.Minute
+
+// ProviderError is a failure that a provider has classified
+type ProviderError struct {
+	Class ErrorClass
+	// RetryAfter is how long to wait before trying again, if the provider said so
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s error, retry after %s: %v", e.Class, e.RetryAfter, e.Err)
+	}
+	return fmt.Sprintf("%s error: %v", e.Class, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns the class of an error, and how long to wait if rate limited. Errors that weren't
+// classified by a provider, such as network failures, are considered transient.
+func Classify(err error) (ErrorClass, time.Duration) {
+	var perr *ProviderError
+	if !errors.As(err, &perr) {
+		return ClassTransient, 0
+	}
+	if perr.Class == ClassRateLimited && perr.RetryAfter <= 0 {
+		return perr.Class, DefaultRetryAfter
+	}
+	return perr.Class, perr.RetryAfter
+}
+
+// IPDetectionError means no public IP could be found that may be published
+type IPDetectionError struct {
+	Err error
+}
+
+func (e *IPDetectionError) Error() string {
+	return "unable to find a public IP: " + e.Err.Error()
+}
+
+func (e *IPDetectionError) Unwrap() error {
+	return e.Err
+}
+
+// asProviderError returns err as a *ProviderError, classifying it as transient if the provider didn't classify it
+func asProviderError(err error) error {
+	var perr *ProviderError
+	if errors.As(err, &perr) {
+		return err
+	}
+	return &ProviderError{Class: ClassTransient, Err: err}
+}
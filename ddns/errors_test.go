@@ -0,0 +1,29 @@
+package ddns
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	assert := assert.New(t)
+
+	class, retryAfter := Classify(fmt.Errorf("connection refused"))
+	assert.Equal(ClassTransient, class, "unclassified errors are expected to be transient")
+	assert.Zero(retryAfter)
+
+	err := errors.Annotate(&ProviderError{Class: ClassPermission, Err: fmt.Errorf("forbidden")}, "unable to update")
+	class, _ = Classify(err)
+	assert.Equal(ClassPermission, class, "annotated errors must keep their class")
+
+	class, retryAfter = Classify(&ProviderError{Class: ClassRateLimited, RetryAfter: 5 * time.Second})
+	assert.Equal(ClassRateLimited, class)
+	assert.Equal(5*time.Second, retryAfter)
+
+	_, retryAfter = Classify(&ProviderError{Class: ClassRateLimited})
+	assert.Equal(DefaultRetryAfter, retryAfter)
+}
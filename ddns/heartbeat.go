@@ -0,0 +1,76 @@
+package ddns
+
+import (
+	"bytes"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/meta"
+)
+
+// HeartbeatPrefix is prepended to a record's name to get the name of its heartbeat TXT record
+const HeartbeatPrefix = "_ddns."
+
+// Heartbeat periodically writes a TXT record next to each managed record, showing when the daemon last
+// checked in. The zero value disables it.
+type Heartbeat struct {
+	Provider HeartbeatProvider
+	Interval time.Duration
+	Template *template.Template
+}
+
+// HeartbeatData is what a heartbeat template is rendered with
+type HeartbeatData struct {
+	Hostname  string
+	Version   string
+	Timestamp string // UTC, in RFC 3339 format
+	Time      time.Time
+	Domain    string
+	Record    string
+	IP        string
+	Source    string
+}
+
+// ParseHeartbeatTemplate parses a text/template for heartbeat record content, see HeartbeatData for the fields available
+func ParseHeartbeatTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("heartbeat").Parse(text)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid heartbeat template")
+	}
+	// Catch references to fields that don't exist now rather than on the first heartbeat
+	if err := tmpl.Execute(&bytes.Buffer{}, HeartbeatData{}); err != nil {
+		return nil, errors.Annotate(err, "invalid heartbeat template")
+	}
+	return tmpl, nil
+}
+
+// HeartbeatRecord returns the name of the heartbeat TXT record for a record
+func HeartbeatRecord(record string) string {
+	return HeartbeatPrefix + record
+}
+
+func (h Heartbeat) enabled() bool {
+	return h.Provider != nil && h.Interval > 0 && h.Template != nil
+}
+
+// render produces the TXT record content for a record that currently points to ip
+func (h Heartbeat) render(rs *recordState, ip, source string, now time.Time) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	var buf bytes.Buffer
+	err = h.Template.Execute(&buf, HeartbeatData{
+		Hostname:  hostname,
+		Version:   meta.Version,
+		Timestamp: now.UTC().Format(time.RFC3339),
+		Time:      now,
+		Domain:    rs.Domain,
+		Record:    rs.Record,
+		IP:        ip,
+		Source:    source,
+	})
+	return buf.String(), errors.Annotate(err, "unable to render heartbeat template")
+}
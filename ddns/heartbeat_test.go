@@ -0,0 +1,61 @@
+package ddns
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/task"
+	"github.com/mattolenik/cloudflare-ddns-client/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHeartbeatTemplate(t *testing.T) {
+	assert := assert.New(t)
+	_, err := ParseHeartbeatTemplate(conf.HeartbeatTemplate.Default)
+	assert.NoError(err)
+	_, err = ParseHeartbeatTemplate("{{.Hostname")
+	assert.Error(err)
+	_, err = ParseHeartbeatTemplate("{{.Nonexistent}}")
+	assert.Error(err, "expected unknown fields to be rejected up front")
+}
+
+func TestDaemonHeartbeat(t *testing.T) {
+	assert, require, ctrl, cleanup := test.NewTools(t)
+	defer cleanup()
+
+	domain := "abc.com"
+	record := "xyz.abc.com"
+	ddnsProvider, ipProvider, configProvider := fixtures(ctrl)
+	heartbeatProvider := NewMockHeartbeatProvider(ctrl)
+	tmpl, err := ParseHeartbeatTemplate("ip={{.IP}} source={{.Source}} at={{.Timestamp}}")
+	require.NoError(err)
+	ddnsDaemon := NewDefaultDaemon(ddnsProvider, ipProvider, configProvider)
+	ddnsDaemon.SetHeartbeat(Heartbeat{Provider: heartbeatProvider, Interval: 50 * time.Millisecond, Template: tmpl})
+	beats := make(chan string, 10)
+
+	configProvider.EXPECT().Get().Return([]conf.RecordConfig{{Domain: domain, Record: record}}, nil).AnyTimes()
+	ipProvider.EXPECT().Get(gomock.Any()).Return("1.1.1.1", "test", nil).AnyTimes()
+	ddnsProvider.EXPECT().Get(domain, record).Return("1.1.1.1", nil).AnyTimes()
+	ddnsProvider.EXPECT().Update(domain, record, "1.1.1.1").Return(nil).Times(1)
+	heartbeatProvider.EXPECT().UpdateTXT(domain, "_ddns.xyz.abc.com", gomock.Any()).DoAndReturn(func(domain, record, content string) error {
+		beats <- content
+		return nil
+	}).MinTimes(2)
+
+	// The update period is far longer than the test, so repeated heartbeats must come from the heartbeat interval
+	events := ddnsDaemon.Events().Subscribe("test", 100, task.DropOldest)
+	ddnsDaemon.Start(time.Hour, time.Hour)
+	first := <-beats
+	assert.True(strings.HasPrefix(first, "ip=1.1.1.1 source=test at="), first)
+	select {
+	case <-beats:
+	case <-time.After(5 * time.Second):
+		require.Fail("expected a second heartbeat")
+	}
+	ddnsDaemon.Stop()
+	for range events.Events() {
+	}
+}
@@ -0,0 +1,251 @@
+package ddns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+)
+
+// LeasePrefix is prepended to a record's name to get the name of its lease TXT record
+const LeasePrefix = "_ddns-lease."
+
+// Leadership makes the daemon act only on records that it holds the Lease on, so that several instances can
+// run side by side, one active and the others on standby. Leases are renewed every third of TTL, and a standby
+// takes over once the active instance's lease expires. The zero value disables it.
+type Leadership struct {
+	Lease Lease
+	// Holder identifies this instance, it must be different for every instance
+	Holder string
+	TTL    time.Duration
+}
+
+func (l Leadership) enabled() bool {
+	return l.Lease != nil && l.Holder != "" && l.TTL > 0
+}
+
+// LeaseRecord returns the name of the lease TXT record for a record
+func LeaseRecord(record string) string {
+	return LeasePrefix + record
+}
+
+// leaseInfo is who holds a lease and until when
+type leaseInfo struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// heldBy returns true if the lease belongs to someone other than holder and hasn't expired by now
+func (l leaseInfo) heldBy(holder string, now time.Time) bool {
+	return l.Holder != "" && l.Holder != holder && l.Expires.After(now)
+}
+
+func (l leaseInfo) String() string {
+	return fmt.Sprintf("holder=%s expires=%s", l.Holder, l.Expires.UTC().Format(time.RFC3339))
+}
+
+// parseLeaseInfo parses the content of a lease TXT record, anything unreadable is a lease that nobody holds
+func parseLeaseInfo(content string) leaseInfo {
+	var l leaseInfo
+	for _, field := range strings.Fields(content) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "holder":
+			l.Holder = value
+		case "expires":
+			l.Expires, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+	return l
+}
+
+// DefaultLeaseSettle is how long TXTLease waits after writing a lease before reading it back
+const DefaultLeaseSettle = 2 * time.Second
+
+// TXTLease keeps each record's lease in a TXT record named by LeaseRecord, next to the record itself, so that
+// instances on different hosts can share it. DNS providers can't compare and swap, so this is best-effort: after
+// taking a lease it waits for Settle and reads it back, so that of several instances writing at about the same
+// time only the last one becomes the leader. Instances whose writes are further apart than that may both act on
+// the record until the next renewal. Renewing a lease that is still held doesn't wait. Duplicate lease records,
+// left by instances that created one at the same time, are deleted, every instance keeping the one with the lowest ID.
+type TXTLease struct {
+	Provider LeaseProvider
+	// Settle is how long to wait before reading back a lease that was taken, DefaultLeaseSettle if zero
+	Settle time.Duration
+	// Clock is used to wait for Settle, the system clock if nil
+	Clock Clock
+}
+
+func (l *TXTLease) Acquire(ctx context.Context, r conf.RecordConfig, holder string, now, expires time.Time) (bool, error) {
+	if !r.IsDNS() {
+		return false, errors.NotSupportedf("TXT lease for %s entry '%s'", r.Type, r.Record)
+	}
+	name := LeaseRecord(r.Record)
+	current, err := l.read(r.Domain, name)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	if current.heldBy(holder, now) {
+		return false, nil
+	}
+	if err := l.Provider.UpdateTXT(r.Domain, name, leaseInfo{Holder: holder, Expires: expires}.String()); err != nil {
+		return false, errors.Annotatef(err, "unable to write lease record '%s'", name)
+	}
+	if current.Holder == holder && current.Expires.After(now) {
+		// No other instance takes a lease before it expires, so there is nobody to race with
+		return true, nil
+	}
+	settle := l.Settle
+	if settle == 0 {
+		settle = DefaultLeaseSettle
+	}
+	clock := l.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+	select {
+	case <-clock.After(settle):
+	case <-ctx.Done():
+		return false, errors.Trace(ctx.Err())
+	}
+	if current, err = l.read(r.Domain, name); err != nil {
+		return false, errors.Trace(err)
+	}
+	return current.Holder == holder, nil
+}
+
+func (l *TXTLease) Release(r conf.RecordConfig, holder string) error {
+	if !r.IsDNS() {
+		return nil
+	}
+	name := LeaseRecord(r.Record)
+	current, err := l.read(r.Domain, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if current.Holder != holder {
+		return nil
+	}
+	// An expired lease is free for anyone to take
+	return errors.Annotatef(l.Provider.UpdateTXT(r.Domain, name, leaseInfo{Holder: holder}.String()), "unable to write lease record '%s'", name)
+}
+
+// read returns the lease in the lease record with the lowest ID, deleting any others
+func (l *TXTLease) read(domain, name string) (leaseInfo, error) {
+	records, err := l.Provider.ListTXT(domain, name)
+	if err != nil {
+		return leaseInfo{}, errors.Annotatef(err, "unable to read lease record '%s'", name)
+	}
+	if len(records) == 0 {
+		return leaseInfo{}, nil
+	}
+	for _, dup := range records[1:] {
+		// Another instance may have deleted it already
+		if err := l.Provider.DeleteTXT(domain, name, dup.ID); err != nil {
+			if class, _ := Classify(err); class != ClassNotFound {
+				return leaseInfo{}, errors.Annotatef(err, "unable to delete duplicate lease record '%s'", name)
+			}
+		}
+	}
+	return parseLeaseInfo(records[0].Content), nil
+}
+
+// How long to wait for another process to finish with a lease file, and after how long its lock is assumed
+// to have been left behind by a process that crashed
+const (
+	leaseLockTimeout = 5 * time.Second
+	leaseLockStale   = 30 * time.Second
+)
+
+// FileLease keeps the leases of all records in a JSON file, for instances running on the same host.
+// Changes are made while holding a lock file next to it, so they can't overlap.
+type FileLease struct {
+	path string
+}
+
+// NewFileLease keeps leases in the file at path, which is created when first needed
+func NewFileLease(path string) *FileLease {
+	return &FileLease{path: path}
+}
+
+func (l *FileLease) Acquire(ctx context.Context, r conf.RecordConfig, holder string, now, expires time.Time) (bool, error) {
+	acquired := false
+	err := l.update(func(leases map[string]leaseInfo) bool {
+		if leases[r.Key()].heldBy(holder, now) {
+			return false
+		}
+		leases[r.Key()] = leaseInfo{Holder: holder, Expires: expires}
+		acquired = true
+		return true
+	})
+	return acquired, errors.Trace(err)
+}
+
+func (l *FileLease) Release(r conf.RecordConfig, holder string) error {
+	return errors.Trace(l.update(func(leases map[string]leaseInfo) bool {
+		if leases[r.Key()].Holder != holder {
+			return false
+		}
+		delete(leases, r.Key())
+		return true
+	}))
+}
+
+// update reads the leases, lets fn change them, and saves them if fn returns true, all while holding the lock
+func (l *FileLease) update(fn func(leases map[string]leaseInfo) bool) error {
+	unlock, err := l.lock()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer unlock()
+	leases := map[string]leaseInfo{}
+	data, err := os.ReadFile(l.path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Annotatef(err, "unable to read lease file '%s'", l.path)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &leases); err != nil {
+			return errors.Annotatef(err, "lease file '%s' is corrupt", l.path)
+		}
+	}
+	if !fn(leases) {
+		return nil
+	}
+	if data, err = json.MarshalIndent(leases, "", "  "); err != nil {
+		return errors.Trace(err)
+	}
+	tmp := l.path + ".new"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Annotatef(err, "unable to write lease file '%s'", l.path)
+	}
+	return errors.Annotatef(os.Rename(tmp, l.path), "unable to write lease file '%s'", l.path)
+}
+
+// lock creates the lock file, waiting for any other process holding it, and returns a function that removes it
+func (l *FileLease) lock() (func(), error) {
+	path := l.path + ".lock"
+	deadline := time.Now().Add(leaseLockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Annotatef(err, "unable to lock lease file '%s'", l.path)
+		}
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > leaseLockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf("timed out waiting for lock '%s' on lease file", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
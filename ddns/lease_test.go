@@ -0,0 +1,155 @@
+package ddns
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTXT keeps TXT records like a DNS provider would, including several with the same name
+type fakeTXT struct {
+	mu      sync.Mutex
+	records map[string][]TXTRecord
+	nextID  int
+	// creating, if set, holds back the creation of a record until that many instances are creating one
+	creating *sync.WaitGroup
+}
+
+func (f *fakeTXT) ListTXT(domain, record string) ([]TXTRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]TXTRecord(nil), f.records[record]...), nil
+}
+
+func (f *fakeTXT) UpdateTXT(domain, record, content string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.records[record]) > 0 {
+		f.records[record][0].Content = content
+		return nil
+	}
+	if f.creating != nil {
+		f.mu.Unlock()
+		f.creating.Done()
+		f.creating.Wait()
+		f.mu.Lock()
+	}
+	f.nextID++
+	f.records[record] = append(f.records[record], TXTRecord{ID: fmt.Sprintf("%03d", f.nextID), Content: content})
+	return nil
+}
+
+func (f *fakeTXT) DeleteTXT(domain, record, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, r := range f.records[record] {
+		if r.ID == id {
+			f.records[record] = append(f.records[record][:i], f.records[record][i+1:]...)
+			return nil
+		}
+	}
+	return &ProviderError{Class: ClassNotFound, Err: fmt.Errorf("record %s not found", id)}
+}
+
+func TestLeases(t *testing.T) {
+	for name, lease := range map[string]Lease{
+		"file": NewFileLease(filepath.Join(t.TempDir(), "cloudflare-ddns.lease")),
+		"txt":  &TXTLease{Provider: &fakeTXT{records: map[string][]TXTRecord{}}, Settle: time.Millisecond},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			record := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com"}
+			other := conf.RecordConfig{Domain: "abc.com", Record: "b.abc.com"}
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			acquire := func(r conf.RecordConfig, holder string, now time.Time) bool {
+				ok, err := lease.Acquire(context.Background(), r, holder, now, now.Add(time.Minute))
+				require.NoError(t, err)
+				return ok
+			}
+
+			assert.True(acquire(record, "one", now), "a lease nobody holds must be free")
+			assert.False(acquire(record, "two", now.Add(30*time.Second)), "a lease held by another instance must not be taken")
+			assert.True(acquire(other, "two", now), "leases on different records must be independent")
+			assert.True(acquire(record, "one", now.Add(30*time.Second)), "the holder must be able to renew its lease")
+			assert.False(acquire(record, "two", now.Add(time.Minute)), "a renewed lease must last from when it was renewed")
+			assert.True(acquire(record, "two", now.Add(2*time.Minute)), "an expired lease must be taken over")
+
+			require.NoError(t, lease.Release(record, "one"), "releasing a lease held by another instance must do nothing")
+			assert.False(acquire(record, "one", now.Add(2*time.Minute)))
+			require.NoError(t, lease.Release(record, "two"))
+			assert.True(acquire(record, "one", now.Add(2*time.Minute)), "a released lease must be free straight away")
+		})
+	}
+}
+
+func TestTXTLeaseRace(t *testing.T) {
+	assert := assert.New(t)
+	record := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com"}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Both instances find no lease record, and each creates its own
+	creating := &sync.WaitGroup{}
+	creating.Add(2)
+	provider := &fakeTXT{records: map[string][]TXTRecord{}, creating: creating}
+	lease := &TXTLease{Provider: provider, Settle: 10 * time.Millisecond}
+
+	var wg sync.WaitGroup
+	acquired := make([]bool, 2)
+	for i, holder := range []string{"one", "two"} {
+		wg.Add(1)
+		go func(i int, holder string) {
+			defer wg.Done()
+			ok, err := lease.Acquire(context.Background(), record, holder, now, now.Add(time.Minute))
+			assert.NoError(err)
+			acquired[i] = ok
+		}(i, holder)
+	}
+	wg.Wait()
+	assert.NotEqual(acquired[0], acquired[1], "exactly one instance must become the leader")
+	records, _ := provider.ListTXT(record.Domain, LeaseRecord(record.Record))
+	assert.Equal([]TXTRecord{{ID: "001", Content: records[0].Content}}, records, "the duplicate lease record must be deleted")
+	holder := "one"
+	if acquired[1] {
+		holder = "two"
+	}
+	assert.Equal(holder, parseLeaseInfo(records[0].Content).Holder, "the leader must be the one in the record that was kept")
+}
+
+func TestTXTLeaseSettle(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	record := conf.RecordConfig{Domain: "abc.com", Record: "a.abc.com"}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: now}
+	lease := &TXTLease{Provider: &fakeTXT{records: map[string][]TXTRecord{}}, Clock: clock}
+
+	ok, err := lease.Acquire(context.Background(), record, "one", now, now.Add(time.Minute))
+	require.NoError(err)
+	assert.True(ok)
+	assert.Equal(now.Add(DefaultLeaseSettle), clock.Now(), "taking a lease must wait for it to settle on the given clock")
+
+	renewed := now.Add(30 * time.Second)
+	ok, err = lease.Acquire(context.Background(), record, "one", renewed, renewed.Add(time.Minute))
+	require.NoError(err)
+	assert.True(ok)
+	assert.Equal(now.Add(DefaultLeaseSettle), clock.Now(), "renewing a lease that is still held must not wait")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	clock.hold = true
+	ok, err = lease.Acquire(ctx, conf.RecordConfig{Domain: "abc.com", Record: "b.abc.com"}, "one", now, now.Add(time.Minute))
+	assert.Error(err, "waiting to settle must be cut short once the context is done")
+	assert.False(ok)
+}
+
+func TestParseLeaseInfo(t *testing.T) {
+	expires := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := leaseInfo{Holder: "gateway-1", Expires: expires}
+	assert.Equal(t, l, parseLeaseInfo(l.String()))
+	assert.Equal(t, leaseInfo{}, parseLeaseInfo("v=spf1 -all"), "unrelated content must be a free lease")
+}
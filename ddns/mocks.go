@@ -5,10 +5,13 @@
 package ddns
 
 import (
+	context "context"
 	reflect "reflect"
 	time "time"
 
 	gomock "github.com/golang/mock/gomock"
+	conf "github.com/mattolenik/cloudflare-ddns-client/conf"
+	task "github.com/mattolenik/cloudflare-ddns-client/task"
 )
 
 // MockDDNSProvider is a mock of DDNSProvider interface.
@@ -63,6 +66,189 @@ func (mr *MockDDNSProviderMockRecorder) Update(domain, record, ip interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockDDNSProvider)(nil).Update), domain, record, ip)
 }
 
+// ForceUpdate mocks base method.
+func (m *MockDDNSProvider) ForceUpdate(domain, record, ip string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForceUpdate", domain, record, ip)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForceUpdate indicates an expected call of ForceUpdate.
+func (mr *MockDDNSProviderMockRecorder) ForceUpdate(domain, record, ip interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForceUpdate", reflect.TypeOf((*MockDDNSProvider)(nil).ForceUpdate), domain, record, ip)
+}
+
+// Delete mocks base method.
+func (m *MockDDNSProvider) Delete(domain, record string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", domain, record)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockDDNSProviderMockRecorder) Delete(domain, record interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockDDNSProvider)(nil).Delete), domain, record)
+}
+
+// MockHeartbeatProvider is a mock of HeartbeatProvider interface.
+type MockHeartbeatProvider struct {
+	ctrl     *gomock.Controller
+	recorder *MockHeartbeatProviderMockRecorder
+}
+
+// MockHeartbeatProviderMockRecorder is the mock recorder for MockHeartbeatProvider.
+type MockHeartbeatProviderMockRecorder struct {
+	mock *MockHeartbeatProvider
+}
+
+// NewMockHeartbeatProvider creates a new mock instance.
+func NewMockHeartbeatProvider(ctrl *gomock.Controller) *MockHeartbeatProvider {
+	mock := &MockHeartbeatProvider{ctrl: ctrl}
+	mock.recorder = &MockHeartbeatProviderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockHeartbeatProvider) EXPECT() *MockHeartbeatProviderMockRecorder {
+	return m.recorder
+}
+
+// UpdateTXT mocks base method.
+func (m *MockHeartbeatProvider) UpdateTXT(domain, record, content string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateTXT", domain, record, content)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateTXT indicates an expected call of UpdateTXT.
+func (mr *MockHeartbeatProviderMockRecorder) UpdateTXT(domain, record, content interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTXT", reflect.TypeOf((*MockHeartbeatProvider)(nil).UpdateTXT), domain, record, content)
+}
+
+// MockLeaseProvider is a mock of LeaseProvider interface.
+type MockLeaseProvider struct {
+	ctrl     *gomock.Controller
+	recorder *MockLeaseProviderMockRecorder
+}
+
+// MockLeaseProviderMockRecorder is the mock recorder for MockLeaseProvider.
+type MockLeaseProviderMockRecorder struct {
+	mock *MockLeaseProvider
+}
+
+// NewMockLeaseProvider creates a new mock instance.
+func NewMockLeaseProvider(ctrl *gomock.Controller) *MockLeaseProvider {
+	mock := &MockLeaseProvider{ctrl: ctrl}
+	mock.recorder = &MockLeaseProviderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLeaseProvider) EXPECT() *MockLeaseProviderMockRecorder {
+	return m.recorder
+}
+
+// DeleteTXT mocks base method.
+func (m *MockLeaseProvider) DeleteTXT(domain, record, id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteTXT", domain, record, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteTXT indicates an expected call of DeleteTXT.
+func (mr *MockLeaseProviderMockRecorder) DeleteTXT(domain, record, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTXT", reflect.TypeOf((*MockLeaseProvider)(nil).DeleteTXT), domain, record, id)
+}
+
+// ListTXT mocks base method.
+func (m *MockLeaseProvider) ListTXT(domain, record string) ([]TXTRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTXT", domain, record)
+	ret0, _ := ret[0].([]TXTRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTXT indicates an expected call of ListTXT.
+func (mr *MockLeaseProviderMockRecorder) ListTXT(domain, record interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTXT", reflect.TypeOf((*MockLeaseProvider)(nil).ListTXT), domain, record)
+}
+
+// UpdateTXT mocks base method.
+func (m *MockLeaseProvider) UpdateTXT(domain, record, content string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateTXT", domain, record, content)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateTXT indicates an expected call of UpdateTXT.
+func (mr *MockLeaseProviderMockRecorder) UpdateTXT(domain, record, content interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTXT", reflect.TypeOf((*MockLeaseProvider)(nil).UpdateTXT), domain, record, content)
+}
+
+// MockLease is a mock of Lease interface.
+type MockLease struct {
+	ctrl     *gomock.Controller
+	recorder *MockLeaseMockRecorder
+}
+
+// MockLeaseMockRecorder is the mock recorder for MockLease.
+type MockLeaseMockRecorder struct {
+	mock *MockLease
+}
+
+// NewMockLease creates a new mock instance.
+func NewMockLease(ctrl *gomock.Controller) *MockLease {
+	mock := &MockLease{ctrl: ctrl}
+	mock.recorder = &MockLeaseMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLease) EXPECT() *MockLeaseMockRecorder {
+	return m.recorder
+}
+
+// Acquire mocks base method.
+func (m *MockLease) Acquire(ctx context.Context, r conf.RecordConfig, holder string, now, expires time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Acquire", ctx, r, holder, now, expires)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Acquire indicates an expected call of Acquire.
+func (mr *MockLeaseMockRecorder) Acquire(ctx, r, holder, now, expires interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Acquire", reflect.TypeOf((*MockLease)(nil).Acquire), ctx, r, holder, now, expires)
+}
+
+// Release mocks base method.
+func (m *MockLease) Release(r conf.RecordConfig, holder string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Release", r, holder)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Release indicates an expected call of Release.
+func (mr *MockLeaseMockRecorder) Release(r, holder interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Release", reflect.TypeOf((*MockLease)(nil).Release), r, holder)
+}
+
 // MockIPProvider is a mock of IPProvider interface.
 type MockIPProvider struct {
 	ctrl     *gomock.Controller
@@ -87,18 +273,19 @@ func (m *MockIPProvider) EXPECT() *MockIPProviderMockRecorder {
 }
 
 // Get mocks base method.
-func (m *MockIPProvider) Get() (string, error) {
+func (m *MockIPProvider) Get(ctx context.Context) (string, string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Get")
+	ret := m.ctrl.Call(m, "Get", ctx)
 	ret0, _ := ret[0].(string)
-	ret1, _ := ret[1].(error)
-	return ret0, ret1
+	ret1, _ := ret[1].(string)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
 }
 
 // Get indicates an expected call of Get.
-func (mr *MockIPProviderMockRecorder) Get() *gomock.Call {
+func (mr *MockIPProviderMockRecorder) Get(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockIPProvider)(nil).Get))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockIPProvider)(nil).Get), ctx)
 }
 
 // MockConfigProvider is a mock of ConfigProvider interface.
@@ -125,13 +312,12 @@ func (m *MockConfigProvider) EXPECT() *MockConfigProviderMockRecorder {
 }
 
 // Get mocks base method.
-func (m *MockConfigProvider) Get() (string, string, error) {
+func (m *MockConfigProvider) Get() ([]conf.RecordConfig, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Get")
-	ret0, _ := ret[0].(string)
-	ret1, _ := ret[1].(string)
-	ret2, _ := ret[2].(error)
-	return ret0, ret1, ret2
+	ret0, _ := ret[0].([]conf.RecordConfig)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // Get indicates an expected call of Get.
@@ -140,6 +326,161 @@ func (mr *MockConfigProviderMockRecorder) Get() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockConfigProvider)(nil).Get))
 }
 
+// MockVerifier is a mock of Verifier interface.
+type MockVerifier struct {
+	ctrl     *gomock.Controller
+	recorder *MockVerifierMockRecorder
+}
+
+// MockVerifierMockRecorder is the mock recorder for MockVerifier.
+type MockVerifierMockRecorder struct {
+	mock *MockVerifier
+}
+
+// NewMockVerifier creates a new mock instance.
+func NewMockVerifier(ctrl *gomock.Controller) *MockVerifier {
+	mock := &MockVerifier{ctrl: ctrl}
+	mock.recorder = &MockVerifierMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockVerifier) EXPECT() *MockVerifierMockRecorder {
+	return m.recorder
+}
+
+// Verify mocks base method.
+func (m *MockVerifier) Verify(ctx context.Context, domain, record, ip string) (time.Duration, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Verify", ctx, domain, record, ip)
+	ret0, _ := ret[0].(time.Duration)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Verify indicates an expected call of Verify.
+func (mr *MockVerifierMockRecorder) Verify(ctx, domain, record, ip interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Verify", reflect.TypeOf((*MockVerifier)(nil).Verify), ctx, domain, record, ip)
+}
+
+// MockTarget is a mock of Target interface.
+type MockTarget struct {
+	ctrl     *gomock.Controller
+	recorder *MockTargetMockRecorder
+}
+
+// MockTargetMockRecorder is the mock recorder for MockTarget.
+type MockTargetMockRecorder struct {
+	mock *MockTarget
+}
+
+// NewMockTarget creates a new mock instance.
+func NewMockTarget(ctrl *gomock.Controller) *MockTarget {
+	mock := &MockTarget{ctrl: ctrl}
+	mock.recorder = &MockTargetMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTarget) EXPECT() *MockTargetMockRecorder {
+	return m.recorder
+}
+
+// Get mocks base method.
+func (m *MockTarget) Get(r conf.RecordConfig) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", r)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockTargetMockRecorder) Get(r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockTarget)(nil).Get), r)
+}
+
+// Update mocks base method.
+func (m *MockTarget) Update(r conf.RecordConfig, ip string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", r, ip)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockTargetMockRecorder) Update(r, ip interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockTarget)(nil).Update), r, ip)
+}
+
+// ForceUpdate mocks base method.
+func (m *MockTarget) ForceUpdate(r conf.RecordConfig, ip string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForceUpdate", r, ip)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForceUpdate indicates an expected call of ForceUpdate.
+func (mr *MockTargetMockRecorder) ForceUpdate(r, ip interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForceUpdate", reflect.TypeOf((*MockTarget)(nil).ForceUpdate), r, ip)
+}
+
+// MockStore is a mock of Store interface.
+type MockStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockStoreMockRecorder
+}
+
+// MockStoreMockRecorder is the mock recorder for MockStore.
+type MockStoreMockRecorder struct {
+	mock *MockStore
+}
+
+// NewMockStore creates a new mock instance.
+func NewMockStore(ctrl *gomock.Controller) *MockStore {
+	mock := &MockStore{ctrl: ctrl}
+	mock.recorder = &MockStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockStore) EXPECT() *MockStoreMockRecorder {
+	return m.recorder
+}
+
+// LastPush mocks base method.
+func (m *MockStore) LastPush(key string) time.Time {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LastPush", key)
+	ret0, _ := ret[0].(time.Time)
+	return ret0
+}
+
+// LastPush indicates an expected call of LastPush.
+func (mr *MockStoreMockRecorder) LastPush(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LastPush", reflect.TypeOf((*MockStore)(nil).LastPush), key)
+}
+
+// SetLastPush mocks base method.
+func (m *MockStore) SetLastPush(key string, t time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetLastPush", key, t)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetLastPush indicates an expected call of SetLastPush.
+func (mr *MockStoreMockRecorder) SetLastPush(key, t interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLastPush", reflect.TypeOf((*MockStore)(nil).SetLastPush), key, t)
+}
+
 // MockDaemon is a mock of Daemon interface.
 type MockDaemon struct {
 	ctrl     *gomock.Controller
@@ -163,44 +504,104 @@ func (m *MockDaemon) EXPECT() *MockDaemonMockRecorder {
 	return m.recorder
 }
 
-// Start mocks base method.
-func (m *MockDaemon) Start(provider DDNSProvider, updatePeriod, failureRetryDelay time.Duration) error {
+// Events mocks base method.
+func (m *MockDaemon) Events() *task.Bus {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Start", provider, updatePeriod, failureRetryDelay)
-	ret0, _ := ret[0].(error)
+	ret := m.ctrl.Call(m, "Events")
+	ret0, _ := ret[0].(*task.Bus)
 	return ret0
 }
 
+// Events indicates an expected call of Events.
+func (mr *MockDaemonMockRecorder) Events() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Events", reflect.TypeOf((*MockDaemon)(nil).Events))
+}
+
+// Pause mocks base method.
+func (m *MockDaemon) Pause() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Pause")
+}
+
+// Pause indicates an expected call of Pause.
+func (mr *MockDaemonMockRecorder) Pause() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Pause", reflect.TypeOf((*MockDaemon)(nil).Pause))
+}
+
+// Resume mocks base method.
+func (m *MockDaemon) Resume() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Resume")
+}
+
+// Resume indicates an expected call of Resume.
+func (mr *MockDaemonMockRecorder) Resume() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Resume", reflect.TypeOf((*MockDaemon)(nil).Resume))
+}
+
+// Start mocks base method.
+func (m *MockDaemon) Start(updatePeriod, retryDelay time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Start", updatePeriod, retryDelay)
+}
+
 // Start indicates an expected call of Start.
-func (mr *MockDaemonMockRecorder) Start(provider, updatePeriod, failureRetryDelay interface{}) *gomock.Call {
+func (mr *MockDaemonMockRecorder) Start(updatePeriod, retryDelay interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Start", reflect.TypeOf((*MockDaemon)(nil).Start), provider, updatePeriod, failureRetryDelay)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Start", reflect.TypeOf((*MockDaemon)(nil).Start), updatePeriod, retryDelay)
 }
 
-// Stop mocks base method.
-func (m *MockDaemon) Stop() error {
+// State mocks base method.
+func (m *MockDaemon) State() DaemonState {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Stop")
-	ret0, _ := ret[0].(error)
+	ret := m.ctrl.Call(m, "State")
+	ret0, _ := ret[0].(DaemonState)
 	return ret0
 }
 
+// State indicates an expected call of State.
+func (mr *MockDaemonMockRecorder) State() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "State", reflect.TypeOf((*MockDaemon)(nil).State))
+}
+
+// Stop mocks base method.
+func (m *MockDaemon) Stop() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Stop")
+}
+
 // Stop indicates an expected call of Stop.
 func (mr *MockDaemonMockRecorder) Stop() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stop", reflect.TypeOf((*MockDaemon)(nil).Stop))
 }
 
+// Trigger mocks base method.
+func (m *MockDaemon) Trigger() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Trigger")
+}
+
+// Trigger indicates an expected call of Trigger.
+func (mr *MockDaemonMockRecorder) Trigger() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Trigger", reflect.TypeOf((*MockDaemon)(nil).Trigger))
+}
+
 // Update mocks base method.
-func (m *MockDaemon) Update(provider DDNSProvider) error {
+func (m *MockDaemon) Update() error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Update", provider)
+	ret := m.ctrl.Call(m, "Update")
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Update indicates an expected call of Update.
-func (mr *MockDaemonMockRecorder) Update(provider interface{}) *gomock.Call {
+func (mr *MockDaemonMockRecorder) Update() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockDaemon)(nil).Update), provider)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockDaemon)(nil).Update))
 }
@@ -0,0 +1,77 @@
+package ddns
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// FileStore is a Store kept in a JSON file, which is rewritten whenever anything changes
+type FileStore struct {
+	path  string
+	mu    sync.Mutex
+	state fileState
+}
+
+type fileState struct {
+	// LastPush is when each record was last written, by conf.RecordConfig.Key
+	LastPush map[string]time.Time `json:"last_push"`
+}
+
+// OpenFileStore reads the store at path. A missing file is an empty store, it is created on the first change.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, state: fileState{LastPush: map[string]time.Time{}}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to read state file '%s'", path)
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return nil, errors.Annotatef(err, "state file '%s' is corrupt", path)
+	}
+	if s.state.LastPush == nil {
+		s.state.LastPush = map[string]time.Time{}
+	}
+	return s, nil
+}
+
+// LastPush returns when the record was last written, or the zero time if that isn't known
+func (s *FileStore) LastPush(key string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.LastPush[key]
+}
+
+// SetLastPush records when the record was last written and saves the store
+func (s *FileStore) SetLastPush(key string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.LastPush[key] = t
+	return errors.Trace(s.save())
+}
+
+// save replaces the file in one step, so that it is never left half written
+func (s *FileStore) save() error {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return errors.Annotatef(err, "unable to create directory for state file '%s'", s.path)
+	}
+	tmp := s.path + ".new"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Annotatef(err, "unable to write state file '%s'", s.path)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return errors.Annotatef(err, "unable to write state file '%s'", s.path)
+	}
+	return nil
+}
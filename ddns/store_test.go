@@ -0,0 +1,36 @@
+package ddns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "state", "state.json")
+
+	store, err := OpenFileStore(path)
+	require.NoError(t, err, "a missing state file must be an empty store")
+	assert.True(store.LastPush("a.abc.com@abc.com").IsZero())
+	assert.NoFileExists(path, "the state file must not be created until something changes")
+
+	pushed := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, store.SetLastPush("a.abc.com@abc.com", pushed))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	store, err = OpenFileStore(path)
+	require.NoError(t, err)
+	assert.True(pushed.Equal(store.LastPush("a.abc.com@abc.com")), "the time must survive reopening the store")
+	assert.True(store.LastPush("b.abc.com@abc.com").IsZero())
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+	_, err = OpenFileStore(path)
+	assert.Error(err, "expected a corrupt state file to be an error")
+}
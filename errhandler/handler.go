@@ -1,18 +1,96 @@
 package errhandler
 
 import (
-	"os"
 	"strings"
 
 	"github.com/juju/errors"
 	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
 	"github.com/mattolenik/cloudflare-ddns-client/meta"
 	"github.com/rs/zerolog/log"
-	"github.com/spf13/viper"
 )
 
-// Handle checks an error and exits if non-nil, printing a stack trace if applicable.
-func Handle(err error) {
+// Process exit codes, these are documented and must not change
+const (
+	ExitOK = 0
+	// ExitError is any failure not covered by another code
+	ExitError = 1
+	// ExitConfig means the flags, environment variables or config file are invalid
+	ExitConfig = 2
+	// ExitAuth means the DNS provider rejected the credentials
+	ExitAuth = 3
+	// ExitIPDetection means no usable public IP could be found
+	ExitIPDetection = 4
+	// ExitProvider means the DNS provider failed for any reason other than the credentials
+	ExitProvider = 5
+	// ExitChanged means success, and that at least one record was changed. It is only used in
+	// one-shot mode with --exit-code-on-change, otherwise ExitOK is used.
+	ExitChanged = 6
+)
+
+// ExitCode returns the process exit code for err, ExitOK if it is nil. If err wraps several errors
+// with different codes, the code that comes first in the order above wins, e.g. ExitConfig over ExitAuth.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case wraps(err, isConfig):
+		return ExitConfig
+	case wraps(err, isAuth):
+		return ExitAuth
+	case wraps(err, isIPDetection):
+		return ExitIPDetection
+	case wraps(err, isProvider):
+		return ExitProvider
+	default:
+		return ExitError
+	}
+}
+
+func isConfig(err error) bool {
+	_, ok := err.(*conf.ConfigError)
+	return ok
+}
+
+func isAuth(err error) bool {
+	perr, ok := err.(*ddns.ProviderError)
+	return ok && perr.Class == ddns.ClassAuth
+}
+
+func isIPDetection(err error) bool {
+	_, ok := err.(*ddns.IPDetectionError)
+	return ok
+}
+
+func isProvider(err error) bool {
+	_, ok := err.(*ddns.ProviderError)
+	return ok
+}
+
+// wraps returns true if err, or any error it wraps, matches. Unlike errors.As it looks at every
+// error joined together, rather than stopping at the first of the right type.
+func wraps(err error, match func(error) bool) bool {
+	if err == nil {
+		return false
+	}
+	if match(err) {
+		return true
+	}
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		for _, inner := range e.Unwrap() {
+			if wraps(inner, match) {
+				return true
+			}
+		}
+	case interface{ Unwrap() error }:
+		return wraps(e.Unwrap(), match)
+	}
+	return false
+}
+
+// Log logs err, if non-nil, printing a stack trace if applicable
+func Log(err error) {
 	if err == nil {
 		return
 	}
@@ -21,10 +99,9 @@ func Handle(err error) {
 		// Remove name of module, makes stack traces shorter an easier to read
 		msg = strings.ReplaceAll(msg, meta.ModuleName+"/", "")
 	}
-	if viper.GetBool(conf.JSONOutput.Name) {
+	if conf.JSONOutput.Get() == "json" {
 		// If writing to JSON logs, collapse stack trace into one line
 		msg = strings.ReplaceAll(msg, "\n", " ↩ ")
 	}
 	log.Error().Msg(msg)
-	os.Exit(1)
 }
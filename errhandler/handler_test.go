@@ -0,0 +1,27 @@
+package errhandler
+
+import (
+	stderrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	assert := assert.New(t)
+	auth := &ddns.ProviderError{Class: ddns.ClassAuth, Err: fmt.Errorf("invalid token")}
+	transient := &ddns.ProviderError{Class: ddns.ClassTransient, Err: fmt.Errorf("bad gateway")}
+
+	assert.Equal(ExitOK, ExitCode(nil))
+	assert.Equal(ExitError, ExitCode(fmt.Errorf("something broke")))
+	assert.Equal(ExitConfig, ExitCode(errors.Annotate(&conf.ConfigError{Err: fmt.Errorf("no records")}, "startup")))
+	assert.Equal(ExitAuth, ExitCode(errors.Trace(auth)))
+	assert.Equal(ExitIPDetection, ExitCode(&ddns.IPDetectionError{Err: fmt.Errorf("no route to host")}))
+	assert.Equal(ExitProvider, ExitCode(fmt.Errorf("record 'a': %w", transient)))
+	assert.Equal(ExitAuth, ExitCode(errors.Annotate(stderrors.Join(transient, auth), "failed to update DNS")),
+		"an auth failure of any record must win over other provider failures")
+}
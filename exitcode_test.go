@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/mattolenik/cloudflare-ddns-client/errhandler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCloudFlare serves one zone, example.com, with at most one A record, or rejects every request if unauthorized is set
+type fakeCloudFlare struct {
+	mu           sync.Mutex
+	record       *cloudflare.DNSRecord
+	unauthorized bool
+}
+
+func (f *fakeCloudFlare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reply := func(status int, result interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": status == http.StatusOK, "result": result})
+	}
+	if f.unauthorized {
+		reply(http.StatusUnauthorized, nil)
+		return
+	}
+	switch {
+	case r.URL.Path == "/user/tokens/verify":
+		reply(http.StatusOK, cloudflare.APITokenVerifyBody{Status: "active"})
+	case r.URL.Path == "/zones":
+		reply(http.StatusOK, []cloudflare.Zone{{ID: "zone1", Name: "example.com"}})
+	case r.URL.Path == "/zones/zone1/dns_records" && r.Method == http.MethodGet:
+		records := []cloudflare.DNSRecord{}
+		if f.record != nil {
+			records = append(records, *f.record)
+		}
+		reply(http.StatusOK, records)
+	case r.URL.Path == "/zones/zone1/dns_records" && r.Method == http.MethodPost,
+		strings.HasPrefix(r.URL.Path, "/zones/zone1/dns_records/") && r.Method == http.MethodPatch:
+		var record cloudflare.DNSRecord
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			reply(http.StatusBadRequest, nil)
+			return
+		}
+		if f.record == nil {
+			f.record = &cloudflare.DNSRecord{ID: "record1", Type: "A", Name: record.Name}
+		}
+		f.record.Content = record.Content
+		reply(http.StatusOK, f.record)
+	case strings.HasPrefix(r.URL.Path, "/zones/zone1/dns_records/") && r.Method == http.MethodDelete:
+		f.record = nil
+		reply(http.StatusOK, cloudflare.DNSRecord{ID: "record1"})
+	default:
+		reply(http.StatusNotFound, nil)
+	}
+}
+
+var (
+	buildOnce   sync.Once
+	builtBinary string
+	buildErr    error
+)
+
+// testBinary returns the binary given by TEST_BINARY, or builds one the first time it is called
+func testBinary(t *testing.T) string {
+	if binary := os.Getenv("TEST_BINARY"); binary != "" {
+		return binary
+	}
+	buildOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "cloudflare-ddns-test")
+		if err != nil {
+			buildErr = err
+			return
+		}
+		builtBinary = filepath.Join(dir, "cloudflare-ddns")
+		out, err := exec.Command("go", "build", "-o", builtBinary, ".").CombinedOutput()
+		if err != nil {
+			buildErr = err
+			t.Log(string(out))
+		}
+	})
+	require.NoError(t, buildErr, "failed to build binary")
+	return builtBinary
+}
+
+// run runs the binary with args and returns its exit code. The environment is cleared and HOME points
+// at an empty directory, so that no config file or environment variable of the host is picked up.
+func run(t *testing.T, args ...string) int {
+	return runWithInput(t, "", args...)
+}
+
+// runWithInput is like run, giving the binary input on stdin
+func runWithInput(t *testing.T, input string, args ...string) int {
+	cmd := exec.Command(testBinary(t), args...)
+	cmd.Env = []string{"HOME=" + t.TempDir(), "PATH=" + os.Getenv("PATH")}
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	t.Logf("%v\n%s", args, out)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	require.NoError(t, err)
+	return 0
+}
+
+func TestExitCodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the binary")
+	}
+	fake := &fakeCloudFlare{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	args := func(extra ...string) []string {
+		return append([]string{
+			"--cloudflare-api-url", server.URL,
+			"--domain", "example.com",
+			"--record", "home.example.com",
+			"--token", "token",
+		}, extra...)
+	}
+
+	assert.Equal(t, errhandler.ExitOK, run(t, args("--ip", "1.1.1.1")...), "creating a record without --exit-code-on-change")
+	assert.Equal(t, errhandler.ExitOK, run(t, args("--ip", "1.1.1.1", "--exit-code-on-change")...), "record already up to date")
+	assert.Equal(t, errhandler.ExitChanged, run(t, args("--ip", "2.2.2.2", "--exit-code-on-change")...), "record changed")
+	fake.mu.Lock()
+	assert.Equal(t, "2.2.2.2", fake.record.Content)
+	fake.mu.Unlock()
+	assert.Equal(t, errhandler.ExitIPDetection, run(t, args("--ip", "10.0.0.1")...), "private IP rejected by the default policy")
+	assert.Equal(t, errhandler.ExitConfig, run(t, "--cloudflare-api-url", server.URL, "--domain", "example.com", "--record", "home.example.com"), "no token")
+	assert.Equal(t, errhandler.ExitConfig, run(t, args("--no-such-flag")...), "unknown flag")
+
+	fake.mu.Lock()
+	fake.unauthorized = true
+	fake.mu.Unlock()
+	assert.Equal(t, errhandler.ExitAuth, run(t, args("--ip", "1.1.1.1")...), "token rejected")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/errhandler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the binary")
+	}
+	assert := assert.New(t)
+	fake := &fakeCloudFlare{record: &cloudflare.DNSRecord{ID: "record1", Type: "A", Name: "home.example.com", Content: "1.1.1.1"}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	dir := t.TempDir()
+	readRecords := func(path string) []conf.RecordConfig {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(os.FileMode(0600), info.Mode().Perm(), "config files hold credentials and must only be readable by their owner")
+		files, err := conf.LoadConfigFiles(path)
+		require.NoError(t, err)
+		require.NoError(t, files.Apply(nil))
+		records, err := conf.Records()
+		require.NoError(t, err)
+		return records
+	}
+
+	path := filepath.Join(dir, "flags.yaml")
+	args := []string{"init", "--non-interactive", "--cloudflare-api-url", server.URL, "--ip", "1.1.1.1", "--output", path,
+		"--token", "token", "--domain", "example.com", "--record", "home.example.com", "--records", "office"}
+	require.Equal(t, errhandler.ExitOK, run(t, args...))
+	assert.Equal([]conf.RecordConfig{
+		{Domain: "example.com", Record: "home.example.com"},
+		{Domain: "example.com", Record: "office.example.com"},
+	}, readRecords(path))
+	assert.Equal(errhandler.ExitConfig, run(t, args...), "an existing config file must not be replaced without --force")
+	assert.Equal(errhandler.ExitOK, run(t, append(args, "--force")...))
+
+	path = filepath.Join(dir, "asked.toml")
+	// The token, then the first existing record and a new one, the only zone is chosen without asking
+	input := "token\n1, vpn\n"
+	require.Equal(t, errhandler.ExitOK, runWithInput(t, input, "init", "--cloudflare-api-url", server.URL, "--ip", "1.1.1.1", "--output", path))
+	assert.Equal([]conf.RecordConfig{
+		{Domain: "example.com", Record: "home.example.com"},
+		{Domain: "example.com", Record: "vpn.example.com"},
+	}, readRecords(path))
+
+	assert.Equal(errhandler.ExitConfig, run(t, "init", "--non-interactive", "--cloudflare-api-url", server.URL, "--token", "token", "--output", filepath.Join(dir, "none.toml")),
+		"a missing answer must fail in non-interactive mode")
+	assert.NoFileExists(filepath.Join(dir, "none.toml"))
+
+	fake.mu.Lock()
+	fake.unauthorized = true
+	fake.mu.Unlock()
+	assert.Equal(errhandler.ExitAuth, run(t, "init", "--non-interactive", "--cloudflare-api-url", server.URL, "--token", "bad", "--domain", "example.com", "--record", "home.example.com"))
+}
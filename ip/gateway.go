@@ -0,0 +1,142 @@
+package ip
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// NAT-PMP (RFC 6886) and PCP (RFC 6887) constants
+const (
+	natPMPPort               = 5351
+	natPMPVersion            = 0
+	natPMPOpExternalAddress  = 0
+	natPMPResponseLength     = 12
+	natPMPResultUnsupportVer = 1
+	pcpVersion               = 2
+	pcpOpMap                 = 1
+	pcpResponseBit           = 0x80
+	pcpMapLength             = 60
+	pcpResultSuccess         = 0
+	pcpProtocolUDP           = 17
+	pcpMapLifetime           = 60 // seconds, the mapping is deleted again right after
+	gatewayTimeout           = 3 * time.Second
+)
+
+// getPublicIPFromNATPMP asks the default gateway for its external address using NAT-PMP, or PCP if the gateway only speaks that
+func getPublicIPFromNATPMP() (string, bool, []error) {
+	gateway, err := defaultGateway()
+	if err != nil {
+		return "", false, []error{errors.Annotate(err, "unable to find default gateway")}
+	}
+	ip, err := getIPFromNATPMP(net.JoinHostPort(gateway.String(), strconv.Itoa(natPMPPort)), gatewayTimeout)
+	if err != nil {
+		return "", false, []error{errors.Trace(err)}
+	}
+	return ip, true, nil
+}
+
+// getIPFromNATPMP asks the NAT-PMP or PCP server at addr for the router's external address
+func getIPFromNATPMP(addr string, timeout time.Duration) (string, error) {
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return "", errors.Annotatef(err, "unable to reach gateway '%s'", addr)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", errors.Trace(err)
+	}
+	if _, err := conn.Write([]byte{natPMPVersion, natPMPOpExternalAddress}); err != nil {
+		return "", errors.Annotatef(err, "unable to send NAT-PMP request to '%s'", addr)
+	}
+	buf := make([]byte, 1100)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", errors.Annotatef(err, "no NAT-PMP response from '%s'", addr)
+	}
+	resp := buf[:n]
+	// A PCP-only server answers NAT-PMP requests with an unsupported version error
+	if len(resp) >= 4 && (resp[0] == pcpVersion || binary.BigEndian.Uint16(resp[2:4]) == natPMPResultUnsupportVer) {
+		return getIPFromPCP(conn)
+	}
+	if len(resp) < natPMPResponseLength {
+		return "", errors.Errorf("NAT-PMP response from '%s' is too short, %d bytes", addr, len(resp))
+	}
+	if resp[0] != natPMPVersion || resp[1] != natPMPOpExternalAddress|pcpResponseBit {
+		return "", errors.Errorf("unexpected NAT-PMP response from '%s'", addr)
+	}
+	if result := binary.BigEndian.Uint16(resp[2:4]); result != 0 {
+		return "", errors.Errorf("gateway '%s' refused NAT-PMP request with result code %d", addr, result)
+	}
+	return checkWANAddress(net.IP(resp[8:12]))
+}
+
+// getIPFromPCP learns the router's external address by briefly mapping the connection's own port with PCP.
+// PCP has no request for just the external address, so the mapping is deleted again immediately.
+func getIPFromPCP(conn net.Conn) (string, error) {
+	local := conn.LocalAddr().(*net.UDPAddr)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Annotate(err, "unable to generate PCP nonce")
+	}
+	request := func(lifetime uint32) []byte {
+		msg := make([]byte, pcpMapLength)
+		msg[0] = pcpVersion
+		msg[1] = pcpOpMap
+		binary.BigEndian.PutUint32(msg[4:8], lifetime)
+		copy(msg[8:24], local.IP.To16())
+		copy(msg[24:36], nonce)
+		msg[36] = pcpProtocolUDP
+		binary.BigEndian.PutUint16(msg[40:42], uint16(local.Port))
+		// Suggested external address is left as all zeros, IPv4-mapped
+		copy(msg[44:60], net.IPv4zero.To16())
+		return msg
+	}
+	if _, err := conn.Write(request(pcpMapLifetime)); err != nil {
+		return "", errors.Annotate(err, "unable to send PCP request")
+	}
+	buf := make([]byte, 1100)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", errors.Annotate(err, "no PCP response")
+	}
+	resp := buf[:n]
+	if len(resp) < pcpMapLength || resp[0] != pcpVersion || resp[1] != pcpOpMap|pcpResponseBit {
+		return "", errors.New("unexpected PCP response")
+	}
+	if result := resp[3]; result != pcpResultSuccess {
+		return "", errors.Errorf("gateway refused PCP request with result code %d", result)
+	}
+	if !bytes.Equal(resp[24:36], nonce) {
+		return "", errors.New("PCP response nonce does not match request")
+	}
+	external := net.IP(append([]byte{}, resp[44:60]...))
+	// Best effort clean up, the mapping expires on its own shortly anyway
+	conn.Write(request(0))
+	return checkWANAddress(external)
+}
+
+// checkWANAddress rejects a router's WAN address if it can't be the public IP, which
+// means the router itself is behind another NAT
+func checkWANAddress(ip net.IP) (string, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if !IsPublic(ip) {
+		return "", errors.Errorf("router's WAN address '%s' is not public, this network is behind double NAT", ip)
+	}
+	return ip.String(), nil
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, block, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return block
+}
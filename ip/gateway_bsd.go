@@ -0,0 +1,28 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package ip
+
+import (
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// defaultGateway asks the route command for the IPv4 default route
+func defaultGateway() (net.IP, error) {
+	out, err := exec.Command("route", "-n", "get", "default").Output()
+	if err != nil {
+		return nil, errors.Annotate(err, "unable to run 'route -n get default'")
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if found && key == "gateway" {
+			if ip := net.ParseIP(strings.TrimSpace(value)); ip != nil {
+				return ip, nil
+			}
+		}
+	}
+	return nil, errors.NotFoundf("default route")
+}
@@ -0,0 +1,58 @@
+package ip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"strings"
+	"unsafe"
+
+	"github.com/juju/errors"
+)
+
+// defaultGateway reads the IPv4 default route from the kernel's routing table
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Columns are: Iface Destination Gateway Flags ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != net.IPv4len {
+			continue
+		}
+		if gateway := routeAddr(raw, hostOrder); !gateway.IsUnspecified() {
+			return gateway, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return nil, errors.NotFoundf("default route")
+}
+
+// hostOrder is the byte order of this machine
+var hostOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// routeAddr decodes an address from the routing table. The kernel prints the address, which is stored in network
+// byte order, as a number in hex, so the digits are only in network order on big endian machines.
+func routeAddr(raw []byte, order binary.ByteOrder) net.IP {
+	addr := make(net.IP, net.IPv4len)
+	order.PutUint32(addr, binary.BigEndian.Uint32(raw))
+	return addr
+}
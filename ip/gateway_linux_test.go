@@ -0,0 +1,21 @@
+package ip
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteAddr(t *testing.T) {
+	// 192.168.1.1 as printed in /proc/net/route on little and big endian machines
+	little, err := hex.DecodeString("0101A8C0")
+	require.NoError(t, err)
+	big, err := hex.DecodeString("C0A80101")
+	require.NoError(t, err)
+	assert.Equal(t, net.IPv4(192, 168, 1, 1).To4(), routeAddr(little, binary.LittleEndian))
+	assert.Equal(t, net.IPv4(192, 168, 1, 1).To4(), routeAddr(big, binary.BigEndian))
+}
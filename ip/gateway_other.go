@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package ip
+
+import (
+	"net"
+
+	"github.com/juju/errors"
+)
+
+// defaultGateway is not implemented on this platform
+func defaultGateway() (net.IP, error) {
+	return nil, errors.NotImplementedf("finding the default gateway on this platform")
+}
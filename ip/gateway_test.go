@@ -0,0 +1,80 @@
+package ip
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startNATPMPResponder runs a minimal NAT-PMP server on localhost that reports external as the WAN address.
+// If pcpOnly is set it rejects NAT-PMP requests the way a PCP-only server does and answers PCP MAP requests instead.
+func startNATPMPResponder(t *testing.T, external net.IP, pcpOnly bool) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1100)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := buf[:n]
+			switch {
+			case n == 2 && req[0] == natPMPVersion && !pcpOnly:
+				resp := make([]byte, natPMPResponseLength)
+				resp[1] = natPMPOpExternalAddress | pcpResponseBit
+				copy(resp[8:12], external.To4())
+				conn.WriteTo(resp, from)
+			case n == 2 && req[0] == natPMPVersion:
+				resp := make([]byte, 8)
+				resp[0] = pcpVersion
+				resp[1] = natPMPOpExternalAddress | pcpResponseBit
+				binary.BigEndian.PutUint16(resp[2:4], natPMPResultUnsupportVer)
+				conn.WriteTo(resp, from)
+			case n == pcpMapLength && req[0] == pcpVersion && req[1] == pcpOpMap:
+				resp := make([]byte, pcpMapLength)
+				copy(resp, req)
+				resp[1] = pcpOpMap | pcpResponseBit
+				resp[3] = pcpResultSuccess
+				copy(resp[44:60], external.To16())
+				conn.WriteTo(resp, from)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestIPFromNATPMP(t *testing.T) {
+	ip, err := getIPFromNATPMP(startNATPMPResponder(t, net.ParseIP("93.184.216.34"), false), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "93.184.216.34", ip)
+}
+
+func TestIPFromPCP(t *testing.T) {
+	ip, err := getIPFromNATPMP(startNATPMPResponder(t, net.ParseIP("81.2.69.160"), true), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "81.2.69.160", ip)
+}
+
+func TestNATPMPDoubleNAT(t *testing.T) {
+	assert := assert.New(t)
+	for _, wan := range []string{"192.168.1.2", "10.0.0.1", "100.64.12.1"} {
+		_, err := getIPFromNATPMP(startNATPMPResponder(t, net.ParseIP(wan), false), time.Second)
+		if assert.Error(err, wan) {
+			assert.Contains(err.Error(), "double NAT")
+		}
+	}
+}
+
+func TestNATPMPNoResponse(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = getIPFromNATPMP(conn.LocalAddr().String(), 100*time.Millisecond)
+	assert.Error(t, err)
+}
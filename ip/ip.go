@@ -1,6 +1,8 @@
 package ip
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -9,6 +11,7 @@ import (
 
 	"github.com/juju/errors"
 	"github.com/lixiangzhong/dnsutil"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -34,44 +37,178 @@ var dnsLookupOpenDNS = dnsLookup{
 	RecordType: "A",
 }
 
-// GetPublicIPWithRetry calls GetPublicIP and with numRetries attempts waiting delayInSeconds after each attempt.
-func GetPublicIPWithRetry(numRetries int, delay time.Duration) (string, error) {
+// Sources of public IP addresses, reported along with the address
+const (
+	SourceDNS    = "dns"
+	SourceHTTP   = "http"
+	SourceSTUN   = "stun"
+	SourceNATPMP = "nat-pmp"
+	SourceUPnP   = "upnp"
+)
+
+// lookups maps each source to the function that retrieves the IP from it. Each returns the IP, whether it
+// succeeded, and any problems it ran into along the way, which may be non-empty even on success.
+var lookups = map[string]func(r *Resolver) (string, bool, []error){
+	SourceDNS:    (*Resolver).lookupDNS,
+	SourceHTTP:   (*Resolver).lookupHTTP,
+	SourceSTUN:   (*Resolver).lookupSTUN,
+	SourceNATPMP: func(*Resolver) (string, bool, []error) { return getPublicIPFromNATPMP() },
+	SourceUPnP:   func(*Resolver) (string, bool, []error) { return getPublicIPFromUPnP() },
+}
+
+// DefaultSources are the sources used unless configured otherwise. Asking the router, with SourceNATPMP
+// or SourceUPnP, is not included because most routers don't allow it.
+var DefaultSources = []string{SourceDNS, SourceHTTP, SourceSTUN}
+
+// Sources are tried in order by GetPublicIP until one succeeds
+var Sources = DefaultSources
+
+// defaultHTTPClient is used for HTTP lookups unless a Resolver is given its own client
+var defaultHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// ValidateSources checks that all given sources exist
+func ValidateSources(sources []string) error {
+	for _, source := range sources {
+		if _, ok := lookups[source]; !ok {
+			return errors.NotValidf("IP source '%s'", source)
+		}
+	}
+	return nil
+}
+
+// Resolver looks up the public IP without relying on any package level settings, for use as a library.
+// The zero value uses DefaultSources, the default STUN servers and the global logger.
+type Resolver struct {
+	// Sources are tried in order until one succeeds, DefaultSources if empty
+	Sources []string
+	// STUNServers are queried by SourceSTUN, the default servers if empty
+	STUNServers []string
+	// HTTPClient is used by SourceHTTP, a client with a 5 second timeout if nil
+	HTTPClient *http.Client
+	// Logger receives warnings about failed lookups, the global logger if nil
+	Logger *zerolog.Logger
+}
+
+func (r *Resolver) sources() []string {
+	if len(r.Sources) == 0 {
+		return DefaultSources
+	}
+	return r.Sources
+}
+
+func (r *Resolver) stunServers() []string {
+	if len(r.STUNServers) == 0 {
+		return defaultSTUNServers
+	}
+	return r.STUNServers
+}
+
+func (r *Resolver) httpClient() *http.Client {
+	if r.HTTPClient == nil {
+		return defaultHTTPClient
+	}
+	return r.HTTPClient
+}
+
+func (r *Resolver) logger() *zerolog.Logger {
+	if r.Logger == nil {
+		return &log.Logger
+	}
+	return r.Logger
+}
+
+func (r *Resolver) lookupDNS() (string, bool, []error) {
+	return getPublicIPFromDNS(dnsLookupOpenDNS, dnsLookupGoogle)
+}
+
+func (r *Resolver) lookupHTTP() (string, bool, []error) {
+	return getPublicIPFromAPIs(r.httpClient(), apiURLs...)
+}
+
+func (r *Resolver) lookupSTUN() (string, bool, []error) {
+	return getPublicIPFromSTUN(r.stunServers()...)
+}
+
+// GetPublicIPWithSource tries each of the resolver's sources in order, returning the IP from the first that succeeds
+func (r *Resolver) GetPublicIPWithSource() (ip, source string, err error) {
+	return r.getPublicIPFromSources(r.sources()...)
+}
+
+// GetPublicIPWithRetry calls GetPublicIPWithSource up to numRetries times, waiting delay after each failed attempt
+func (r *Resolver) GetPublicIPWithRetry(numRetries int, delay time.Duration) (ip, source string, err error) {
+	return r.GetPublicIPWithRetryContext(context.Background(), numRetries, delay, nil)
+}
+
+// GetPublicIPWithRetryContext is the same as GetPublicIPWithRetry, but gives up as soon as ctx is done. after is
+// used to wait between attempts, time.After if nil.
+func (r *Resolver) GetPublicIPWithRetryContext(ctx context.Context, numRetries int, delay time.Duration, after func(time.Duration) <-chan time.Time) (ip, source string, err error) {
+	if after == nil {
+		after = time.After
+	}
 	var i int
 	for i = 0; i < numRetries; i++ {
-		ip, err := GetPublicIP()
+		ip, source, err := r.GetPublicIPWithSource()
 		if err == nil {
-			return ip, nil
+			return ip, source, nil
+		}
+		r.logger().Warn().Msgf("failed to retrieve public IP, attempt #%d, retrying in %s", i+1, delay.String())
+		select {
+		case <-after(delay):
+		case <-ctx.Done():
+			return "", "", errors.Annotatef(ctx.Err(), "gave up retrieving public IP after %d attempts", i+1)
 		}
-		log.Warn().Msgf("failed to retrieve public IP, attempt #%d, retrying in %s", i+1, delay.String())
-		time.Sleep(delay)
 	}
-	return "", errors.Errorf("failed to retrieve public IP after %d attempts", i)
+	return "", "", errors.Errorf("failed to retrieve public IP after %d attempts", i)
 }
 
-// GetPublicIP tries to detect the public IP address of this machine. First using DNS, then using several public HTTP APIs.
-func GetPublicIP() (string, error) {
-	ip, success, errs := getPublicIPFromDNS(dnsLookupOpenDNS, dnsLookupGoogle)
-	if !success && len(errs) > 0 {
-		log.Warn().Msgf("failed to retrieve IP from DNS: %+v", errs)
-		ip, success, errs := getPublicIPFromAPIs(apiURLs...)
-		if !success && len(errs) > 0 {
-			return "", errors.Errorf("failed to retrieve IP from public API: %+v", errs)
-		} else if success && len(errs) > 0 {
-			log.Warn().Msgf("successfully retrieved IP from public API but ran into the following problems: %+v", errs)
-			return ip, nil
-		} else if success {
-			return ip, nil
+func (r *Resolver) getPublicIPFromSources(sources ...string) (ip, source string, err error) {
+	if len(sources) == 0 {
+		return "", "", errors.New("expected at least one IP source")
+	}
+	failures := []string{}
+	for _, source := range sources {
+		lookup, ok := lookups[source]
+		if !ok {
+			return "", "", errors.NotValidf("IP source '%s'", source)
 		}
-		// This shouldn't happen, if success is false, errs should always be len > 0
-		return "", errors.New("unexpected error when retrieving IP with API, indicates a bug")
-	} else if success && len(errs) > 0 {
-		log.Warn().Msgf("successfully retrieved IP from DNS but ran into the following problems: %+v", errs)
-		return ip, nil
-	} else if success {
-		return ip, nil
+		ip, success, errs := lookup(r)
+		if success {
+			if len(errs) > 0 {
+				r.logger().Warn().Msgf("successfully retrieved IP from %s but ran into the following problems: %+v", source, errs)
+			}
+			return ip, source, nil
+		}
+		r.logger().Warn().Msgf("failed to retrieve IP from %s: %+v", source, errs)
+		failures = append(failures, fmt.Sprintf("%s: %+v", source, errs))
 	}
-	// This shouldn't happen, if success is false, errs should always be len > 0
-	return "", errors.New("unexpected error when retrieving IP with DNS, indicates a bug")
+	return "", "", errors.Errorf("failed to retrieve IP from any source, %s", strings.Join(failures, "; "))
+}
+
+// globalResolver uses the package level Sources and STUNServers, as they are at the time of the call
+func globalResolver() *Resolver {
+	return &Resolver{Sources: Sources, STUNServers: STUNServers}
+}
+
+// GetPublicIPWithRetry calls GetPublicIPWithSource and with numRetries attempts waiting delayInSeconds after each attempt.
+func GetPublicIPWithRetry(numRetries int, delay time.Duration) (ip, source string, err error) {
+	return globalResolver().GetPublicIPWithRetry(numRetries, delay)
+}
+
+// GetPublicIP tries to detect the public IP address of this machine. By default first using DNS, then using several
+// public HTTP APIs, and finally using STUN, see Sources.
+func GetPublicIP() (string, error) {
+	ip, _, err := GetPublicIPWithSource()
+	return ip, err
+}
+
+// GetPublicIPWithSource is the same as GetPublicIP but also returns the source the address came from, e.g. SourceDNS.
+func GetPublicIPWithSource() (ip, source string, err error) {
+	return GetPublicIPFromSources(Sources...)
+}
+
+// GetPublicIPFromSources tries each of the given sources in order, returning the IP from the first that succeeds
+func GetPublicIPFromSources(sources ...string) (ip, source string, err error) {
+	return globalResolver().getPublicIPFromSources(sources...)
 }
 
 // getPublicIPFromDNS tries to detect the public IP address of this machine using DNS. First OpenDNS, then Google.
@@ -105,13 +242,10 @@ func getPublicIPFromDNS(lookups ...dnsLookup) (string, bool, []error) {
 
 // getPublicIPFromAPIs tries to detect the public IP address of this machine using several public HTTP APIs.
 // returns IP, success/fail, and one or more errors (one per API used)
-func getPublicIPFromAPIs(apiURLs ...string) (string, bool, []error) {
-	http.DefaultClient = &http.Client{
-		Timeout: 5 * time.Second,
-	}
+func getPublicIPFromAPIs(client *http.Client, apiURLs ...string) (string, bool, []error) {
 	failures := []error{}
 	for _, url := range apiURLs {
-		ip, err := getIPFromHTTP(url)
+		ip, err := getIPFromHTTP(client, url)
 		if err != nil {
 			failures = append(failures, errors.Trace(err))
 		} else {
@@ -122,11 +256,12 @@ func getPublicIPFromAPIs(apiURLs ...string) (string, bool, []error) {
 }
 
 // getIPFromHTTP performs and HTTP GET and returns the body as a string
-func getIPFromHTTP(url string) (string, error) {
-	resp, err := http.Get(url)
+func getIPFromHTTP(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
 	if err != nil {
 		return "", errors.Annotatef(err, "HTTP GET '%s' failed", url)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		return "", errors.Errorf("HTTP GET '%s' failed with status %s", url, resp.Status)
 	}
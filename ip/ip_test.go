@@ -17,7 +17,7 @@ func TestIPFromHTTPAPIs(t *testing.T) {
 	assert := assert.New(t)
 	ips := []string{}
 	for _, url := range apiURLs {
-		ip, success, err := getPublicIPFromAPIs(url)
+		ip, success, err := getPublicIPFromAPIs(defaultHTTPClient, url)
 		assert.Emptyf(err, "expected no errors from API with URL '%s'", url)
 		assert.Truef(success, "expected to get IP from API with URL '%s'", url)
 		ips = append(ips, ip)
@@ -30,7 +30,7 @@ func TestIPFromHTTPAPIs(t *testing.T) {
 func TestIPFromHTTPAPIs_MalformedResponse(t *testing.T) {
 	assert := assert.New(t)
 	testURLs := []string{"http://example.com/notanip", apiURLs[1], apiURLs[2]}
-	ip, success, errs := getPublicIPFromAPIs(testURLs...)
+	ip, success, errs := getPublicIPFromAPIs(defaultHTTPClient, testURLs...)
 	assert.Truef(success, "expected to get IP despite one malformed response")
 	assert.Truef(isValidIP(ip), "expected IP '%s' to be valid", ip)
 	assert.Lenf(errs, 1, "expected exactly one error")
@@ -39,7 +39,7 @@ func TestIPFromHTTPAPIs_MalformedResponse(t *testing.T) {
 func TestIPFromHTTPAPIs_InvalidURL(t *testing.T) {
 	assert := assert.New(t)
 	testURLs := []string{"sfkuer", apiURLs[1], apiURLs[2]}
-	ip, success, errs := getPublicIPFromAPIs(testURLs...)
+	ip, success, errs := getPublicIPFromAPIs(defaultHTTPClient, testURLs...)
 	assert.Truef(success, "expected to get IP despite one invalid URL")
 	assert.Truef(isValidIP(ip), "expected IP '%s' to be valid", ip)
 	assert.Lenf(errs, 1, "expected exactly one error")
@@ -48,7 +48,7 @@ func TestIPFromHTTPAPIs_InvalidURL(t *testing.T) {
 func TestIPFromHTTPAPIs_HostNotFound(t *testing.T) {
 	assert := assert.New(t)
 	testURLs := []string{"http://somethingthatdoesntexist55701230950.com", apiURLs[1], apiURLs[2]}
-	ip, success, errs := getPublicIPFromAPIs(testURLs...)
+	ip, success, errs := getPublicIPFromAPIs(defaultHTTPClient, testURLs...)
 	assert.Truef(success, "expected to get IP despite one host not found")
 	assert.Truef(isValidIP(ip), "expected IP '%s' to be valid", ip)
 	assert.Lenf(errs, 1, "expected exactly one error")
@@ -108,7 +108,7 @@ func TestIPFromDNSAndAPIsAgree(t *testing.T) {
 	assert.Truef(success, "expected success getting IP from DNS")
 	assert.Lenf(errs, 0, "expected no errors from DNS")
 
-	ipAPI, success, errs := getPublicIPFromAPIs(apiURLs...)
+	ipAPI, success, errs := getPublicIPFromAPIs(defaultHTTPClient, apiURLs...)
 	assert.Truef(success, "expected success getting IP from HTTP APIs")
 	assert.Lenf(errs, 0, "expected no errors from HTTP APIs")
 
@@ -118,7 +118,7 @@ func TestIPFromDNSAndAPIsAgree(t *testing.T) {
 
 func Test_getIPFromHTTP_MalformedResponse(t *testing.T) {
 	assert := assert.New(t)
-	ip, err := getIPFromHTTP("http://example.com/notanip")
+	ip, err := getIPFromHTTP(defaultHTTPClient, "http://example.com/notanip")
 	assert.Errorf(err, "expected error for malformed response")
 	assert.Falsef(isValidIP(ip), "expected IP '%s' to be invalid", ip)
 }
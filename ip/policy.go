@@ -0,0 +1,113 @@
+package ip
+
+import (
+	"net"
+
+	"github.com/juju/errors"
+)
+
+// reservedBlock is an address range that is never reachable from the internet
+type reservedBlock struct {
+	network *net.IPNet
+	reason  string
+}
+
+// reservedBlocks are the ranges a public IP can't be in, mostly from RFC 6890
+var reservedBlocks = []reservedBlock{
+	{mustParseCIDR("0.0.0.0/8"), "'this network'"},
+	{mustParseCIDR("10.0.0.0/8"), "private"},
+	{mustParseCIDR("100.64.0.0/10"), "CGNAT"},
+	{mustParseCIDR("127.0.0.0/8"), "loopback"},
+	{mustParseCIDR("169.254.0.0/16"), "link-local"},
+	{mustParseCIDR("172.16.0.0/12"), "private"},
+	{mustParseCIDR("192.0.0.0/24"), "IETF protocol assignment"},
+	{mustParseCIDR("192.0.2.0/24"), "documentation"},
+	{mustParseCIDR("192.168.0.0/16"), "private"},
+	{mustParseCIDR("198.18.0.0/15"), "benchmarking"},
+	{mustParseCIDR("198.51.100.0/24"), "documentation"},
+	{mustParseCIDR("203.0.113.0/24"), "documentation"},
+	{mustParseCIDR("224.0.0.0/4"), "multicast"},
+	{mustParseCIDR("240.0.0.0/4"), "reserved"},
+	{mustParseCIDR("::/128"), "unspecified"},
+	{mustParseCIDR("::1/128"), "loopback"},
+	{mustParseCIDR("fc00::/7"), "unique local"},
+	{mustParseCIDR("fe80::/10"), "link-local"},
+	{mustParseCIDR("ff00::/8"), "multicast"},
+	{mustParseCIDR("2001:db8::/32"), "documentation"},
+}
+
+// IsPublic returns false if the address is in a private, CGNAT, loopback, link-local, documentation,
+// multicast or otherwise reserved range
+func IsPublic(ip net.IP) bool {
+	return reservedReason(ip) == ""
+}
+
+func reservedReason(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, block := range reservedBlocks {
+		if block.network.Contains(ip) {
+			return block.reason
+		}
+	}
+	return ""
+}
+
+// Policy decides whether an address may be published to a record.
+// Denied ranges always win. If any ranges are allowed, only those are accepted and they may
+// include non-public addresses. Otherwise any public address is accepted.
+type Policy struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// NewPolicy parses lists of CIDRs, e.g. 203.0.113.0/24, into a Policy
+func NewPolicy(allow, deny []string) (Policy, error) {
+	p := Policy{}
+	var err error
+	if p.Allow, err = parseCIDRs(allow); err != nil {
+		return p, errors.Annotate(err, "invalid allowed range")
+	}
+	if p.Deny, err = parseCIDRs(deny); err != nil {
+		return p, errors.Annotate(err, "invalid denied range")
+	}
+	return p, nil
+}
+
+// Check returns an error explaining why the address may not be published, or nil if it may
+func (p Policy) Check(addr string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return errors.NotValidf("IP address '%s'", addr)
+	}
+	for _, block := range p.Deny {
+		if block.Contains(ip) {
+			return errors.Errorf("IP '%s' is in denied range %s", addr, block)
+		}
+	}
+	for _, block := range p.Allow {
+		if block.Contains(ip) {
+			return nil
+		}
+	}
+	if len(p.Allow) > 0 {
+		return errors.Errorf("IP '%s' is not in any allowed range", addr)
+	}
+	if reason := reservedReason(ip); reason != "" {
+		return errors.Errorf("IP '%s' is not publicly routable, it is a %s address", addr, reason)
+	}
+	return nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	blocks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, block, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
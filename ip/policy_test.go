@@ -0,0 +1,51 @@
+package ip
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPublic(t *testing.T) {
+	assert := assert.New(t)
+	for _, ip := range []string{"10.1.2.3", "172.16.0.1", "192.168.0.1", "100.64.0.1", "100.127.255.254", "127.0.0.1",
+		"169.254.1.1", "0.0.0.0", "192.0.2.1", "198.51.100.1", "203.0.113.1", "224.0.0.251", "255.255.255.255",
+		"::1", "fd00::1", "fe80::1", "ff02::1", "2001:db8::1", "::ffff:10.0.0.1"} {
+		assert.False(IsPublic(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"1.1.1.1", "100.128.0.1", "93.184.216.34", "2606:4700::1111"} {
+		assert.True(IsPublic(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestPolicyDefault(t *testing.T) {
+	assert := assert.New(t)
+	p, err := NewPolicy(nil, nil)
+	require.NoError(t, err)
+	assert.NoError(p.Check("1.1.1.1"))
+	if err := p.Check("100.64.3.4"); assert.Error(err) {
+		assert.Contains(err.Error(), "CGNAT")
+	}
+	assert.Error(p.Check("not-an-ip"))
+}
+
+func TestPolicyAllowDeny(t *testing.T) {
+	assert := assert.New(t)
+	p, err := NewPolicy([]string{"10.0.0.0/8", "1.1.0.0/16"}, []string{"10.9.0.0/16"})
+	require.NoError(t, err)
+	// Allowed ranges may contain non-public addresses, e.g. for records only used internally
+	assert.NoError(p.Check("10.1.2.3"))
+	assert.NoError(p.Check("1.1.1.1"))
+	assert.Error(p.Check("10.9.1.1"), "deny wins over allow")
+	assert.Error(p.Check("8.8.8.8"), "only allowed ranges are accepted once any are given")
+
+	p, err = NewPolicy(nil, []string{"2606:4700::/32"})
+	require.NoError(t, err)
+	assert.Error(p.Check("2606:4700::1111"))
+	assert.NoError(p.Check("2a00:1450::1"))
+
+	_, err = NewPolicy([]string{"10.0.0.0"}, nil)
+	assert.Error(err)
+}
@@ -0,0 +1,175 @@
+package ip
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// STUNServers are queried in order when the public IP can't be found using DNS or HTTP.
+// STUN uses UDP, which often still works on networks that block or intercept DNS and HTTP.
+var STUNServers = defaultSTUNServers
+
+var defaultSTUNServers = []string{"stun.l.google.com:19302", "stun.cloudflare.com:3478"}
+
+// STUN message constants from RFC 5389
+const (
+	stunBindingRequest         = 0x0001
+	stunBindingSuccess         = 0x0101
+	stunMagicCookie            = 0x2112A442
+	stunHeaderLength           = 20
+	stunAttrMappedAddress      = 0x0001
+	stunAttrXORMappedAddress   = 0x0020
+	stunFamilyIPv4             = 0x01
+	stunFamilyIPv6             = 0x02
+	stunTransactionIDLength    = 12
+	stunDefaultTimeout         = 5 * time.Second
+	stunMaxResponseLength      = 1500
+	stunTransactionIDOffset    = 8
+	stunAttributeHeaderLength  = 4
+	stunAddressAttributeLength = 4 // reserved byte, family, and port that precede the address
+)
+
+// getPublicIPFromSTUN tries each STUN server in turn, returning the first address found
+func getPublicIPFromSTUN(servers ...string) (string, bool, []error) {
+	if len(servers) == 0 {
+		return "", false, []error{errors.New("expected at least one STUN server")}
+	}
+	errs := []error{}
+	for _, server := range servers {
+		ip, err := getIPFromSTUN(server, stunDefaultTimeout)
+		if err != nil {
+			errs = append(errs, errors.Trace(err))
+			continue
+		}
+		return ip, true, errs
+	}
+	return "", false, errs
+}
+
+// getIPFromSTUN sends a STUN binding request to server and returns the address the server saw the request come from
+func getIPFromSTUN(server string, timeout time.Duration) (string, error) {
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return "", errors.Annotatef(err, "unable to reach STUN server '%s'", server)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", errors.Trace(err)
+	}
+
+	request, txID, err := newSTUNBindingRequest()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if _, err := conn.Write(request); err != nil {
+		return "", errors.Annotatef(err, "unable to send STUN request to '%s'", server)
+	}
+	buf := make([]byte, stunMaxResponseLength)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", errors.Annotatef(err, "no STUN response from '%s'", server)
+	}
+	ip, err := parseSTUNBindingResponse(buf[:n], txID)
+	if err != nil {
+		return "", errors.Annotatef(err, "invalid STUN response from '%s'", server)
+	}
+	return ip.String(), nil
+}
+
+// newSTUNBindingRequest creates a binding request without any attributes, along with its random transaction ID
+func newSTUNBindingRequest() ([]byte, []byte, error) {
+	msg := make([]byte, stunHeaderLength)
+	binary.BigEndian.PutUint16(msg[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint16(msg[2:4], 0)
+	binary.BigEndian.PutUint32(msg[4:8], stunMagicCookie)
+	txID := msg[stunTransactionIDOffset:stunHeaderLength]
+	if _, err := rand.Read(txID); err != nil {
+		return nil, nil, errors.Annotate(err, "unable to generate STUN transaction ID")
+	}
+	return msg, txID, nil
+}
+
+// parseSTUNBindingResponse returns the mapped address from a binding success response,
+// preferring XOR-MAPPED-ADDRESS over the legacy MAPPED-ADDRESS
+func parseSTUNBindingResponse(msg, txID []byte) (net.IP, error) {
+	if len(msg) < stunHeaderLength {
+		return nil, errors.Errorf("message too short, %d bytes", len(msg))
+	}
+	if msgType := binary.BigEndian.Uint16(msg[0:2]); msgType != stunBindingSuccess {
+		return nil, errors.Errorf("expected binding success response, got message type 0x%04x", msgType)
+	}
+	if cookie := binary.BigEndian.Uint32(msg[4:8]); cookie != stunMagicCookie {
+		return nil, errors.Errorf("wrong magic cookie 0x%08x", cookie)
+	}
+	if string(msg[stunTransactionIDOffset:stunHeaderLength]) != string(txID) {
+		return nil, errors.New("transaction ID does not match request")
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:4]))
+	if stunHeaderLength+length > len(msg) {
+		return nil, errors.Errorf("message length %d exceeds received data", length)
+	}
+
+	var mapped net.IP
+	attrs := msg[stunHeaderLength : stunHeaderLength+length]
+	for len(attrs) >= stunAttributeHeaderLength {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLength := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if stunAttributeHeaderLength+attrLength > len(attrs) {
+			return nil, errors.Errorf("attribute 0x%04x is truncated", attrType)
+		}
+		value := attrs[stunAttributeHeaderLength : stunAttributeHeaderLength+attrLength]
+		switch attrType {
+		case stunAttrXORMappedAddress:
+			return parseSTUNAddress(value, msg[4:stunHeaderLength])
+		case stunAttrMappedAddress:
+			ip, err := parseSTUNAddress(value, nil)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			mapped = ip
+		}
+		// Attributes are padded to a multiple of 4 bytes
+		padded := (attrLength + 3) &^ 3
+		if stunAttributeHeaderLength+padded > len(attrs) {
+			break
+		}
+		attrs = attrs[stunAttributeHeaderLength+padded:]
+	}
+	if mapped == nil {
+		return nil, errors.New("response has no mapped address")
+	}
+	return mapped, nil
+}
+
+// parseSTUNAddress decodes a (XOR-)MAPPED-ADDRESS value. For XOR-MAPPED-ADDRESS, xorKey is the magic cookie
+// followed by the transaction ID, for MAPPED-ADDRESS it is nil.
+func parseSTUNAddress(value, xorKey []byte) (net.IP, error) {
+	if len(value) < stunAddressAttributeLength {
+		return nil, errors.New("address attribute is too short")
+	}
+	var size int
+	switch family := value[1]; family {
+	case stunFamilyIPv4:
+		size = net.IPv4len
+	case stunFamilyIPv6:
+		size = net.IPv6len
+	default:
+		return nil, errors.Errorf("unknown address family 0x%02x", family)
+	}
+	addr := value[stunAddressAttributeLength:]
+	if len(addr) != size {
+		return nil, errors.Errorf("expected address of %d bytes, got %d", size, len(addr))
+	}
+	ip := make(net.IP, size)
+	for i := range addr {
+		ip[i] = addr[i]
+		if xorKey != nil {
+			ip[i] ^= xorKey[i]
+		}
+	}
+	return ip, nil
+}
@@ -0,0 +1,127 @@
+package ip
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSTUNResponder runs a minimal STUN server on localhost that answers binding requests with
+// the given address, or with the address the request came from if mapped is nil.
+func startSTUNResponder(t *testing.T, mapped net.IP) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, stunMaxResponseLength)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < stunHeaderLength || binary.BigEndian.Uint16(buf[0:2]) != stunBindingRequest {
+				continue
+			}
+			ip := mapped
+			if ip == nil {
+				ip = from.(*net.UDPAddr).IP
+			}
+			conn.WriteTo(stunResponse(buf[:stunHeaderLength], ip, 54321), from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// stunResponse builds a binding success response to request, with an unrelated attribute that needs padding
+// ahead of the XOR-MAPPED-ADDRESS
+func stunResponse(request []byte, ip net.IP, port uint16) []byte {
+	family := byte(stunFamilyIPv6)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, family = ip4, stunFamilyIPv4
+	}
+	key := request[4:stunHeaderLength]
+	addr := make([]byte, stunAddressAttributeLength+len(ip))
+	addr[1] = family
+	binary.BigEndian.PutUint16(addr[2:4], port^uint16(stunMagicCookie>>16))
+	for i := range ip {
+		addr[stunAddressAttributeLength+i] = ip[i] ^ key[i]
+	}
+
+	var attrs []byte
+	attrs = appendSTUNAttribute(attrs, 0x8022, []byte("odd"))
+	attrs = appendSTUNAttribute(attrs, stunAttrXORMappedAddress, addr)
+
+	msg := make([]byte, stunHeaderLength, stunHeaderLength+len(attrs))
+	binary.BigEndian.PutUint16(msg[0:2], stunBindingSuccess)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(attrs)))
+	copy(msg[4:], key)
+	return append(msg, attrs...)
+}
+
+func appendSTUNAttribute(attrs []byte, attrType uint16, value []byte) []byte {
+	header := make([]byte, stunAttributeHeaderLength)
+	binary.BigEndian.PutUint16(header[0:2], attrType)
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(value)))
+	attrs = append(attrs, header...)
+	attrs = append(attrs, value...)
+	for len(attrs)%4 != 0 {
+		attrs = append(attrs, 0)
+	}
+	return attrs
+}
+
+func TestIPFromSTUN(t *testing.T) {
+	assert := assert.New(t)
+
+	ip, err := getIPFromSTUN(startSTUNResponder(t, nil), time.Second)
+	assert.NoError(err)
+	assert.Equal("127.0.0.1", ip)
+
+	ip, err = getIPFromSTUN(startSTUNResponder(t, net.ParseIP("203.0.113.7")), time.Second)
+	assert.NoError(err)
+	assert.Equal("203.0.113.7", ip)
+
+	ip, err = getIPFromSTUN(startSTUNResponder(t, net.ParseIP("2001:db8::1234:5678")), time.Second)
+	assert.NoError(err)
+	assert.Equal("2001:db8::1234:5678", ip)
+}
+
+func TestIPFromSTUN_WithFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	// Nothing is listening on the first server, so it must time out and fall back to the second
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(err)
+	silentAddr := silent.LocalAddr().String()
+	silent.Close()
+
+	ip, success, errs := getPublicIPFromSTUN(silentAddr, startSTUNResponder(t, net.ParseIP("198.51.100.1")))
+	assert.True(success, "expected success despite one unreachable server")
+	assert.Equal("198.51.100.1", ip)
+	assert.Len(errs, 1, "expected exactly one error")
+}
+
+func Test_parseSTUNBindingResponse_Invalid(t *testing.T) {
+	assert := assert.New(t)
+	request, txID, err := newSTUNBindingRequest()
+	assert.NoError(err)
+
+	_, err = parseSTUNBindingResponse(request[:10], txID)
+	assert.Error(err, "expected error for truncated message")
+
+	_, err = parseSTUNBindingResponse(request, txID)
+	assert.Error(err, "expected error for a request instead of a response")
+
+	response := stunResponse(request, net.ParseIP("203.0.113.7"), 1)
+	otherID := append([]byte{}, txID...)
+	otherID[0]++
+	_, err = parseSTUNBindingResponse(response, otherID)
+	assert.Error(err, "expected error for mismatched transaction ID")
+
+	_, err = parseSTUNBindingResponse(response[:len(response)-4], txID)
+	assert.Error(err, "expected error for truncated attribute")
+}
@@ -0,0 +1,229 @@
+package ip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const ssdpAddress = "239.255.255.250:1900"
+
+// igdDeviceTypes are searched for in order, version 2 first
+var igdDeviceTypes = []string{
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:2",
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+}
+
+// wanServiceTypes are the UPnP services that can report the router's external address
+var wanServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// igdRoot is the subset of a UPnP device description needed to find the WAN connection service
+type igdRoot struct {
+	URLBase string    `xml:"URLBase"`
+	Device  igdDevice `xml:"device"`
+}
+
+type igdDevice struct {
+	DeviceType string       `xml:"deviceType"`
+	Services   []igdService `xml:"serviceList>service"`
+	Devices    []igdDevice  `xml:"deviceList>device"`
+}
+
+type igdService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// findService searches the device tree for the first service of the given type
+func (d igdDevice) findService(serviceType string) (igdService, bool) {
+	for _, s := range d.Services {
+		if s.ServiceType == serviceType {
+			return s, true
+		}
+	}
+	for _, child := range d.Devices {
+		if s, ok := child.findService(serviceType); ok {
+			return s, true
+		}
+	}
+	return igdService{}, false
+}
+
+// getPublicIPFromUPnP discovers the default gateway's Internet Gateway Device and asks it for its external address
+func getPublicIPFromUPnP() (string, bool, []error) {
+	gateway, err := defaultGateway()
+	if err != nil {
+		return "", false, []error{errors.Annotate(err, "unable to find default gateway")}
+	}
+	location, err := discoverIGD(gateway, gatewayTimeout)
+	if err != nil {
+		return "", false, []error{errors.Trace(err)}
+	}
+	ip, err := getIPFromIGD(location)
+	if err != nil {
+		return "", false, []error{errors.Trace(err)}
+	}
+	return ip, true, nil
+}
+
+// discoverIGD sends an SSDP search and returns the description URL of the default gateway's Internet Gateway Device.
+// Any host on the network can answer the search, so answers that don't come from the gateway, or that point
+// anywhere else, are ignored.
+func discoverIGD(gateway net.IP, timeout time.Duration) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", errors.Annotate(err, "unable to open socket for SSDP")
+	}
+	defer conn.Close()
+	dest, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	for _, deviceType := range igdDeviceTypes {
+		search := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\nHOST: %s\r\nST: %s\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\n\r\n", ssdpAddress, deviceType)
+		if _, err := conn.WriteTo([]byte(search), dest); err != nil {
+			return "", errors.Annotate(err, "unable to send SSDP search")
+		}
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", errors.Trace(err)
+	}
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", errors.Annotatef(err, "no UPnP Internet Gateway Device found on gateway '%s'", gateway)
+		}
+		if udp, ok := from.(*net.UDPAddr); !ok || !udp.IP.Equal(gateway) {
+			continue
+		}
+		if location := parseSSDPResponse(buf[:n]); location != "" && onGateway(location, gateway) {
+			return location, nil
+		}
+	}
+}
+
+// onGateway returns true if the URL location is served by gateway
+func onGateway(location string, gateway net.IP) bool {
+	u, err := url.Parse(location)
+	if err != nil {
+		return false
+	}
+	return net.ParseIP(u.Hostname()).Equal(gateway)
+}
+
+// parseSSDPResponse returns the LOCATION header of an SSDP response if it is from an Internet Gateway Device
+func parseSSDPResponse(msg []byte) string {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(msg)))
+	if _, err := reader.ReadLine(); err != nil {
+		return ""
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return ""
+	}
+	if !strings.Contains(header.Get("ST"), ":device:InternetGatewayDevice:") {
+		return ""
+	}
+	return header.Get("Location")
+}
+
+// getIPFromIGD reads the gateway's device description at location and asks its WAN connection service for the external address
+func getIPFromIGD(location string) (string, error) {
+	client := &http.Client{Timeout: gatewayTimeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return "", errors.Annotatef(err, "unable to fetch UPnP device description '%s'", location)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("fetching UPnP device description '%s' failed with status %s", location, resp.Status)
+	}
+	root := igdRoot{}
+	if err := xml.NewDecoder(resp.Body).Decode(&root); err != nil {
+		return "", errors.Annotatef(err, "malformed UPnP device description '%s'", location)
+	}
+	base := root.URLBase
+	if base == "" {
+		base = location
+	}
+	for _, serviceType := range wanServiceTypes {
+		service, ok := root.Device.findService(serviceType)
+		if !ok {
+			continue
+		}
+		controlURL, err := resolveURL(base, service.ControlURL)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		ip, err := getExternalIPAddress(client, controlURL, serviceType)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		return checkWANAddress(ip)
+	}
+	return "", errors.NotFoundf("WAN connection service in UPnP device '%s'", location)
+}
+
+// getExternalIPAddress calls the GetExternalIPAddress SOAP action on a WAN connection service
+func getExternalIPAddress(client *http.Client, controlURL, serviceType string) (net.IP, error) {
+	body := fmt.Sprintf(`<?xml version="1.0"?>`+
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`+
+		`<s:Body><u:GetExternalIPAddress xmlns:u="%s"></u:GetExternalIPAddress></s:Body></s:Envelope>`, serviceType)
+	req, err := http.NewRequest(http.MethodPost, controlURL, strings.NewReader(body))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#GetExternalIPAddress"`, serviceType))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Annotatef(err, "UPnP request to '%s' failed", controlURL)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed reading UPnP response from '%s'", controlURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("UPnP request to '%s' failed with status %s", controlURL, resp.Status)
+	}
+	envelope := struct {
+		Address string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}{}
+	if err := xml.Unmarshal(data, &envelope); err != nil {
+		return nil, errors.Annotatef(err, "malformed UPnP response from '%s'", controlURL)
+	}
+	ip := net.ParseIP(strings.TrimSpace(envelope.Address))
+	if ip == nil {
+		return nil, errors.Errorf("invalid external IP '%s' from UPnP gateway '%s'", envelope.Address, controlURL)
+	}
+	return ip, nil
+}
+
+// resolveURL resolves ref, which is usually a path, against base
+func resolveURL(base, ref string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", errors.Annotatef(err, "invalid UPnP base URL '%s'", base)
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", errors.Annotatef(err, "invalid UPnP control URL '%s'", ref)
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
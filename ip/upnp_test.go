@@ -0,0 +1,95 @@
+package ip
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const igdDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+// startIGD runs a fake Internet Gateway Device that reports external as its WAN address
+func startIGD(t *testing.T, external string) string {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, igdDescription)
+	})
+	mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(r.Header.Get("SOAPAction"), "#GetExternalIPAddress") || !strings.Contains(string(body), "GetExternalIPAddress") {
+			http.Error(w, "unexpected action", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+<NewExternalIPAddress>%s</NewExternalIPAddress>
+</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`, external)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL + "/rootDesc.xml"
+}
+
+func TestIPFromIGD(t *testing.T) {
+	ip, err := getIPFromIGD(startIGD(t, "93.184.216.34"))
+	require.NoError(t, err)
+	assert.Equal(t, "93.184.216.34", ip)
+}
+
+func TestIGDDoubleNAT(t *testing.T) {
+	_, err := getIPFromIGD(startIGD(t, "100.72.3.4"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "double NAT")
+}
+
+func TestParseSSDPResponse(t *testing.T) {
+	assert := assert.New(t)
+	igd := "HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=120\r\nST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\nLOCATION: http://192.168.1.1:5000/rootDesc.xml\r\n\r\n"
+	assert.Equal("http://192.168.1.1:5000/rootDesc.xml", parseSSDPResponse([]byte(igd)))
+	other := "HTTP/1.1 200 OK\r\nST: urn:schemas-upnp-org:device:MediaRenderer:1\r\nLOCATION: http://192.168.1.5/desc.xml\r\n\r\n"
+	assert.Empty(parseSSDPResponse([]byte(other)))
+}
+
+func TestValidateSources(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(ValidateSources([]string{SourceUPnP, SourceNATPMP, SourceDNS}))
+	assert.Error(ValidateSources([]string{"carrier-pigeon"}))
+}
+
+func TestOnGateway(t *testing.T) {
+	assert := assert.New(t)
+	gateway := net.ParseIP("192.168.1.1")
+	assert.True(onGateway("http://192.168.1.1:5000/rootDesc.xml", gateway))
+	assert.False(onGateway("http://192.168.1.66:5000/rootDesc.xml", gateway), "another host on the network must not be trusted as the gateway")
+	assert.False(onGateway("http://router.lan/rootDesc.xml", gateway))
+	assert.False(onGateway("://", gateway))
+}
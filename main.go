@@ -1,11 +1,11 @@
 package main
 
 import (
+	"os"
+
 	"github.com/mattolenik/cloudflare-ddns-client/cmd"
-	"github.com/mattolenik/cloudflare-ddns-client/errhandler"
 )
 
 func main() {
-	err := cmd.Root.Execute()
-	errhandler.Handle(err)
+	os.Exit(cmd.Execute())
 }
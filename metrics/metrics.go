@@ -0,0 +1,73 @@
+// Package metrics counts daemon events and exposes them over HTTP in expvar's JSON format,
+// at /debug/vars, alongside the Go runtime's memstats and cmdline.
+package metrics
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/task"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// Events counts every event published by the daemon, by kind
+	Events = expvar.NewMap("ddns_events")
+	// PropagationSeconds is how long the last update of each record took to reach all authoritative nameservers
+	PropagationSeconds = expvar.NewMap("ddns_propagation_seconds")
+)
+
+// Collect updates the metrics from a subscription to the daemon's events until it is closed
+func Collect(events *task.Subscription) {
+	for status := range events.Events() {
+		record(status)
+	}
+}
+
+func record(status task.Status) {
+	if status.Kind == "" {
+		return
+	}
+	Events.Add(string(status.Kind), 1)
+	if status.Kind == task.PropagationVerified {
+		seconds := new(expvar.Float)
+		seconds.Set(status.Duration.Seconds())
+		PropagationSeconds.Set(status.Record, seconds)
+	}
+}
+
+// Server serves the metrics over HTTP
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen starts listening for metrics requests on addr, e.g. localhost:9090
+func Listen(addr string) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to listen for metrics requests on '%s'", addr)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return &Server{listener: listener, server: &http.Server{Handler: mux}}, nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve handles requests until Close is called
+func (s *Server) Serve() {
+	if err := s.server.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+		log.Error().Msgf("Metrics server failed: %v", err)
+	}
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	return errors.Trace(s.server.Close())
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattolenik/cloudflare-ddns-client/task"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollect(t *testing.T) {
+	assert := assert.New(t)
+	Events.Init()
+	PropagationSeconds.Init()
+
+	bus := task.NewBus()
+	sub := bus.Subscribe("metrics", 10, task.DropOldest)
+	bus.Publish(task.InfoStatus("updated").WithKind(task.RecordUpdated).ForRecord("abc.com", "xyz.abc.com"))
+	bus.Publish(task.InfoStatus("updated").WithKind(task.RecordUpdated).ForRecord("abc.com", "xyz.abc.com"))
+	bus.Publish(task.InfoStatus("propagated").WithKind(task.PropagationVerified).
+		ForRecord("abc.com", "xyz.abc.com").
+		WithDuration(1500 * time.Millisecond))
+	bus.Close()
+	Collect(sub)
+
+	assert.Equal("2", Events.Get(string(task.RecordUpdated)).String())
+	assert.Equal("1.5", PropagationSeconds.Get("xyz.abc.com").String())
+}
+
+func TestServe(t *testing.T) {
+	Events.Init()
+	Events.Add(string(task.RecordUpdated), 3)
+
+	server, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+	go server.Serve()
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/debug/vars", server.Addr()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	vars := map[string]json.RawMessage{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&vars))
+	assert.JSONEq(t, `{"record_updated": 3}`, string(vars["ddns_events"]))
+}
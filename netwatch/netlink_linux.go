@@ -0,0 +1,93 @@
+package netwatch
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+)
+
+// How long a read from the netlink socket may block before checking whether the watch was cancelled
+const readTimeout = 1 * time.Second
+
+// Watch subscribes to rtnetlink address, route, and link notifications. A value is sent on the
+// returned channel for each batch of notifications received. Use Debounce to turn bursts of
+// events into a single notification. The channel is closed when ctx is done.
+func Watch(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, errors.Annotate(err, "unable to open netlink socket")
+	}
+	addr := &unix.SockaddrNetlink{
+		Family: unix.AF_NETLINK,
+		Groups: unix.RTMGRP_LINK |
+			unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR |
+			unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE,
+	}
+	if err := unix.Bind(fd, addr); err != nil {
+		unix.Close(fd)
+		return nil, errors.Annotate(err, "unable to subscribe to netlink route events")
+	}
+	tv := unix.NsecToTimeval(readTimeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, errors.Annotate(err, "unable to set netlink socket read timeout")
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		defer unix.Close(fd)
+		buf := make([]byte, unix.Getpagesize())
+		for ctx.Err() == nil {
+			n, _, err := unix.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if err == unix.EAGAIN || err == unix.EWOULDBLOCK || err == unix.EINTR {
+					continue
+				}
+				if err == unix.ENOBUFS {
+					// The kernel dropped messages because we weren't reading fast enough,
+					// something has certainly changed.
+					notify(events)
+					continue
+				}
+				log.Error().Msgf("Stopped watching for network changes, netlink read failed: %v", err)
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				log.Debug().Msgf("Ignoring malformed netlink message: %v", err)
+				continue
+			}
+			for _, m := range msgs {
+				if isChangeEvent(m.Header.Type) {
+					log.Debug().Msgf("Network change detected, netlink message type %d", m.Header.Type)
+					notify(events)
+					break
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+func isChangeEvent(msgType uint16) bool {
+	switch msgType {
+	case unix.RTM_NEWADDR, unix.RTM_DELADDR,
+		unix.RTM_NEWROUTE, unix.RTM_DELROUTE,
+		unix.RTM_NEWLINK, unix.RTM_DELLINK:
+		return true
+	}
+	return false
+}
+
+// notify sends an event without blocking, if one is already pending there is no need for another
+func notify(events chan<- struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package netwatch
+
+import "context"
+
+// Watch is only supported on Linux, other platforms must rely on polling.
+func Watch(ctx context.Context) (<-chan struct{}, error) {
+	return nil, ErrUnsupported
+}
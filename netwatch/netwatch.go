@@ -0,0 +1,56 @@
+// Package netwatch notifies about changes to the network configuration of this machine,
+// such as addresses being added or removed, so that IP checks can happen right away
+// instead of waiting for the next polling interval.
+package netwatch
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// DefaultQuietPeriod is how long the network must be free of changes before a debounced event is sent.
+// A single reconnect typically produces a burst of address, route, and link events within a second or two.
+const DefaultQuietPeriod = 3 * time.Second
+
+// ErrUnsupported is returned by Watch on platforms where network change events are not available.
+var ErrUnsupported = errors.New("watching for network changes is not supported on this platform")
+
+// Debounce collapses bursts of events from in into single events on the returned channel.
+// An event is sent once quiet has passed without any new events arriving. The returned
+// channel is closed when ctx is done or in is closed.
+func Debounce(ctx context.Context, in <-chan struct{}, quiet time.Duration) <-chan struct{} {
+	out := make(chan struct{}, 1)
+	go func() {
+		defer close(out)
+		timer := time.NewTimer(quiet)
+		timer.Stop()
+		pending := false
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case _, ok := <-in:
+				if !ok {
+					timer.Stop()
+					return
+				}
+				if pending && !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(quiet)
+				pending = true
+			case <-timer.C:
+				pending = false
+				select {
+				case out <- struct{}{}:
+				default:
+					// A notification is already waiting to be read, no need for another
+				}
+			}
+		}
+	}()
+	return out
+}
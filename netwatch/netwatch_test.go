@@ -0,0 +1,38 @@
+package netwatch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebounce(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan struct{})
+	out := Debounce(ctx, in, 100*time.Millisecond)
+
+	// A burst of events should produce exactly one notification
+	for i := 0; i < 5; i++ {
+		in <- struct{}{}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-out:
+	case <-time.After(2 * time.Second):
+		assert.Fail("expected a debounced event")
+	}
+	select {
+	case <-out:
+		assert.Fail("expected only one event for a burst")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	close(in)
+	_, ok := <-out
+	assert.False(ok, "expected output to be closed when input is closed")
+}
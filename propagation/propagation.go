@@ -0,0 +1,173 @@
+// Package propagation checks that DNS changes have reached a zone's authoritative nameservers
+package propagation
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/miekg/dns"
+)
+
+// DefaultTimeout is how long to wait for a change to propagate before giving up
+const DefaultTimeout = 2 * time.Minute
+
+// DefaultInterval is how long to wait between rounds of queries
+const DefaultInterval = 2 * time.Second
+
+// Verifier queries the authoritative nameservers of a zone directly, either to wait until they all serve
+// an expected value or to read a record without going through the DNS provider's API
+type Verifier struct {
+	Timeout  time.Duration
+	Interval time.Duration
+	// Nameservers returns the authoritative nameservers of a zone as host:port
+	Nameservers func(zone string) ([]string, error)
+	client      *dns.Client
+}
+
+// NewVerifier creates a Verifier that looks up nameservers with LookupNameservers
+func NewVerifier(timeout time.Duration) *Verifier {
+	return &Verifier{
+		Timeout:     timeout,
+		Interval:    DefaultInterval,
+		Nameservers: LookupNameservers,
+		client:      &dns.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// LookupNameservers finds the NS records of a zone using the system resolver
+func LookupNameservers(zone string) ([]string, error) {
+	records, err := net.LookupNS(zone)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to look up nameservers of '%s'", zone)
+	}
+	if len(records) == 0 {
+		return nil, errors.NotFoundf("nameservers of '%s'", zone)
+	}
+	servers := make([]string, 0, len(records))
+	for _, ns := range records {
+		servers = append(servers, net.JoinHostPort(strings.TrimSuffix(ns.Host, "."), "53"))
+	}
+	return servers, nil
+}
+
+// Verify waits until every authoritative nameserver of zone answers for record with ip, returning how long it took
+func (v *Verifier) Verify(ctx context.Context, zone, record, ip string) (time.Duration, error) {
+	started := time.Now()
+	servers, err := v.Nameservers(zone)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return 0, errors.NotValidf("IP address '%s'", ip)
+	}
+	ip = parsed.String()
+	qtype := dns.TypeAAAA
+	if parsed.To4() != nil {
+		qtype = dns.TypeA
+	}
+	ctx, cancel := context.WithTimeout(ctx, v.Timeout)
+	defer cancel()
+
+	// Last answer, or error, from each server that isn't serving the new value yet
+	pending := map[string]string{}
+	for _, server := range servers {
+		pending[server] = "no answer yet"
+	}
+	for {
+		for server := range pending {
+			answers, err := v.query(ctx, server, record, qtype)
+			switch {
+			case err != nil:
+				pending[server] = err.Error()
+			case contains(answers, ip):
+				delete(pending, server)
+			case len(answers) == 0:
+				pending[server] = "serving nothing"
+			default:
+				pending[server] = "serving " + strings.Join(answers, ", ")
+			}
+		}
+		if len(pending) == 0 {
+			return time.Since(started), nil
+		}
+		select {
+		case <-ctx.Done():
+			return time.Since(started), errors.Errorf("'%s' did not propagate to all nameservers within %s, still waiting on: %s",
+				record, v.Timeout, describe(pending))
+		case <-time.After(v.Interval):
+		}
+	}
+}
+
+// query asks a single nameserver for the record without recursion and returns the addresses it serves
+func (v *Verifier) query(ctx context.Context, server, record string, qtype uint16) ([]string, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(record), qtype)
+	msg.RecursionDesired = false
+	client := v.client
+	if client == nil {
+		client = &dns.Client{Timeout: 5 * time.Second}
+	}
+	resp, _, err := client.ExchangeContext(ctx, msg, server)
+	if err != nil {
+		return nil, errors.Annotatef(err, "query to '%s' failed", server)
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, errors.Errorf("'%s' answered %s", server, dns.RcodeToString[resp.Rcode])
+	}
+	answers := []string{}
+	for _, rr := range resp.Answer {
+		switch r := rr.(type) {
+		case *dns.A:
+			answers = append(answers, r.A.String())
+		case *dns.AAAA:
+			answers = append(answers, r.AAAA.String())
+		}
+	}
+	return answers, nil
+}
+
+// Lookup returns the A record value served by the zone's authoritative nameservers, or empty string if
+// there is none. Nameservers are tried in turn until one answers.
+func (v *Verifier) Lookup(ctx context.Context, zone, record string) (string, error) {
+	servers, err := v.Nameservers(zone)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	var errs []string
+	for _, server := range servers {
+		answers, err := v.query(ctx, server, record, dns.TypeA)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if len(answers) == 0 {
+			return "", nil
+		}
+		return answers[0], nil
+	}
+	return "", errors.Errorf("no authoritative nameserver of '%s' answered for '%s': %s", zone, record, strings.Join(errs, "; "))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(pending map[string]string) string {
+	parts := make([]string, 0, len(pending))
+	for server, last := range pending {
+		parts = append(parts, server+" ("+last+")")
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,78 @@
+package propagation
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startNameserver runs an authoritative nameserver on localhost that serves old for the first
+// staleQueries queries and current after that
+func startNameserver(t *testing.T, old, current string, staleQueries int32) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	var queries int32
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		resp.Authoritative = true
+		value := current
+		if atomic.AddInt32(&queries, 1) <= staleQueries {
+			value = old
+		}
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(value),
+		})
+		w.WriteMsg(resp)
+	})}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return conn.LocalAddr().String()
+}
+
+func newTestVerifier(timeout time.Duration, servers ...string) *Verifier {
+	v := NewVerifier(timeout)
+	v.Interval = 10 * time.Millisecond
+	v.Nameservers = func(zone string) ([]string, error) { return servers, nil }
+	return v
+}
+
+func TestVerify(t *testing.T) {
+	v := newTestVerifier(5*time.Second,
+		startNameserver(t, "1.1.1.1", "2.2.2.2", 0),
+		startNameserver(t, "1.1.1.1", "2.2.2.2", 3))
+	took, err := v.Verify(context.Background(), "abc.com", "xyz.abc.com", "2.2.2.2")
+	require.NoError(t, err)
+	assert.Greater(t, took, time.Duration(0))
+}
+
+func TestVerifyTimeout(t *testing.T) {
+	stale := startNameserver(t, "1.1.1.1", "2.2.2.2", 1000)
+	v := newTestVerifier(100*time.Millisecond, startNameserver(t, "1.1.1.1", "2.2.2.2", 0), stale)
+	_, err := v.Verify(context.Background(), "abc.com", "xyz.abc.com", "2.2.2.2")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), stale+" (serving 1.1.1.1)")
+}
+
+func TestLookup(t *testing.T) {
+	down, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	down.Close()
+	v := newTestVerifier(time.Second, down.LocalAddr().String(), startNameserver(t, "1.1.1.1", "2.2.2.2", 0))
+	v.client.Timeout = 100 * time.Millisecond
+	ip, err := v.Lookup(context.Background(), "abc.com", "xyz.abc.com")
+	require.NoError(t, err)
+	assert.Equal(t, "2.2.2.2", ip)
+
+	v = newTestVerifier(time.Second, down.LocalAddr().String())
+	v.client.Timeout = 100 * time.Millisecond
+	_, err = v.Lookup(context.Background(), "abc.com", "xyz.abc.com")
+	assert.Error(t, err)
+}
@@ -2,67 +2,186 @@ package providers
 
 import (
 	"context"
+	"net/http"
+	"sort"
+	"sync"
 
 	"github.com/cloudflare/cloudflare-go"
 	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 type CloudFlareProvider struct {
-	client *cloudflare.API
-	ctx    context.Context
+	mu         sync.Mutex
+	client     *cloudflare.API
+	httpClient *http.Client
+	opts       []cloudflare.Option
+	log        *zerolog.Logger
+	ctx        context.Context
+	// zones caches zone IDs by domain, entries are dropped when CloudFlare no longer finds them
+	zones map[string]string
+	// proxied remembers whether each record read or written so far is proxied through CloudFlare
+	proxied map[string]bool
 }
 
-func NewCloudFlareProvider(ctx context.Context, apiToken string) (*CloudFlareProvider, error) {
-	api, err := cloudflare.NewWithAPIToken(apiToken)
+// NewCloudFlareProvider creates a provider that authenticates with creds. All requests are made with httpClient,
+// or with a default client if it is nil. Any opts are passed on to the API client, e.g. cloudflare.BaseURL.
+func NewCloudFlareProvider(ctx context.Context, creds conf.Credentials, httpClient *http.Client, opts ...cloudflare.Option) (*CloudFlareProvider, error) {
+	api, err := newClient(creds, httpClient, opts...)
 	if err != nil {
-		return nil, errors.Annotate(err, "unable to connect to CloudFlare, token may be invalid")
+		return nil, errors.Annotatef(err, "unable to connect to CloudFlare, %s may be invalid", creds)
 	}
-	return &CloudFlareProvider{client: api, ctx: ctx}, nil
+	return &CloudFlareProvider{client: api, httpClient: httpClient, opts: opts, ctx: ctx}, nil
+}
+
+// SetLogger replaces the global logger as the destination of the provider's logs, and those of its targets
+func (p *CloudFlareProvider) SetLogger(logger zerolog.Logger) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.log = &logger
+}
+
+// logger returns the logger given to SetLogger, or the global logger
+func (p *CloudFlareProvider) logger() *zerolog.Logger {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.log == nil {
+		return &log.Logger
+	}
+	return p.log
+}
+
+// SetCredentials replaces the credentials used for all further requests
+func (p *CloudFlareProvider) SetCredentials(creds conf.Credentials) error {
+	api, err := newClient(creds, p.httpClient, p.opts...)
+	if err != nil {
+		return errors.Annotatef(err, "unable to connect to CloudFlare, %s may be invalid", creds)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.client = api
+	// New credentials may belong to a different account
+	p.zones = nil
+	return nil
+}
+
+// api returns the current client, which may be replaced at any time by SetCredentials
+func (p *CloudFlareProvider) api() *cloudflare.API {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.client
+}
+
+// zoneID returns the ID of the domain's zone, looking it up only if it isn't cached
+func (p *CloudFlareProvider) zoneID(client *cloudflare.API, domain string) (string, error) {
+	p.mu.Lock()
+	id, ok := p.zones[domain]
+	p.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+	id, err := client.ZoneIDByName(domain)
+	if err != nil {
+		return "", errors.Annotatef(classify(err), "unable to retrieve zone ID for domain '%s' from CloudFlare", domain)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.zones == nil {
+		p.zones = map[string]string{}
+	}
+	p.zones[domain] = id
+	return id, nil
+}
+
+// checkZone classifies err, and forgets the domain's zone ID if CloudFlare no longer finds it, so that
+// it is looked up again on the next attempt
+func (p *CloudFlareProvider) checkZone(domain string, err error) error {
+	err = classify(err)
+	if class, _ := ddns.Classify(err); err != nil && class == ddns.ClassNotFound {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.zones, domain)
+	}
+	return err
+}
+
+// Proxied returns whether a record is proxied through CloudFlare, known is false if the record hasn't been
+// read or written through the provider yet
+func (p *CloudFlareProvider) Proxied(domain, record string) (proxied, known bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proxied, known = p.proxied[record]
+	return proxied, known
+}
+
+// seen remembers whether r is proxied
+func (p *CloudFlareProvider) seen(r cloudflare.DNSRecord) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.proxied == nil {
+		p.proxied = map[string]bool{}
+	}
+	p.proxied[r.Name] = r.Proxied != nil && *r.Proxied
 }
 
 // Get fetches the IP of the given record, returning empty string if it doesn't exist
 func (p *CloudFlareProvider) Get(domain, record string) (string, error) {
+	client := p.api()
 	// Get the zone ID for the domain
-	zoneID, err := p.client.ZoneIDByName(domain)
+	zoneID, err := p.zoneID(client, domain)
 	if err != nil {
-		return "", errors.Annotatef(err, "unable to retrieve zone ID for domain '%s' from CloudFlare", domain)
+		return "", errors.Trace(err)
 	}
 	// Get the record ID
-	records, _, err := p.client.ListDNSRecords(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{Type: "A"})
+	records, _, err := client.ListDNSRecords(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{Type: "A"})
 	if err != nil {
-		return "", errors.Annotate(err, "unable to retrieve zone ID from CloudFlare")
+		return "", errors.Annotate(p.checkZone(domain, err), "unable to retrieve zone ID from CloudFlare")
 	}
 	// Find the specific record
 	for _, r := range records {
-		log.Debug().Msgf("Examining DNS record ID '%s' with name '%s'", r.ID, r.Name)
+		p.logger().Debug().Msgf("Examining DNS record ID '%s' with name '%s'", r.ID, r.Name)
 		if r.Name == record {
+			p.seen(r)
 			return r.Content, nil
 		}
 	}
 	return "", nil
 }
 
-// Update updates the CloudFlare DNS record
+// Update updates the CloudFlare DNS record, doing nothing if it already points at ip
 func (p *CloudFlareProvider) Update(domain, record, ip string) error {
+	return p.update(domain, record, ip, false)
+}
+
+// ForceUpdate updates the CloudFlare DNS record, even if it already points at ip
+func (p *CloudFlareProvider) ForceUpdate(domain, record, ip string) error {
+	return p.update(domain, record, ip, true)
+}
+
+func (p *CloudFlareProvider) update(domain, record, ip string, force bool) error {
+	client := p.api()
 	// Get the zone ID for the domain
-	zoneID, err := p.client.ZoneIDByName(domain)
+	zoneID, err := p.zoneID(client, domain)
 	if err != nil {
-		return errors.Annotatef(err, "unable to retrieve zone ID for domain '%s' from CloudFlare", domain)
+		return errors.Trace(err)
 	}
 	// Get the record ID
-	records, _, err := p.client.ListDNSRecords(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{Type: "A"})
+	records, _, err := client.ListDNSRecords(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{Type: "A"})
 	if err != nil {
-		return errors.Annotate(err, "unable to retrieve zone ID from CloudFlare")
+		return errors.Annotate(p.checkZone(domain, err), "unable to retrieve zone ID from CloudFlare")
 	}
 	// Find the specific record
 	var recordID string
 	for _, r := range records {
-		log.Debug().Msgf("Examining DNS record ID '%s' with name '%s'", r.ID, r.Name)
+		p.logger().Debug().Msgf("Examining DNS record ID '%s' with name '%s'", r.ID, r.Name)
 		if r.Name == record {
+			p.seen(r)
 			recordID = r.ID
-			if r.Content == ip {
-				log.Info().Msgf("DNS record '%s' is already set to IP '%s'", record, ip)
+			if r.Content == ip && !force {
+				p.logger().Info().Msgf("DNS record '%s' is already set to IP '%s'", record, ip)
 				return nil
 			}
 			break
@@ -70,26 +189,165 @@ func (p *CloudFlareProvider) Update(domain, record, ip string) error {
 	}
 	// Create the record if it's not already there
 	if recordID == "" {
-		log.Info().Msgf("No DNS record '%s' found for domain '%s', creating now", record, domain)
-		_, err := p.client.CreateDNSRecord(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.CreateDNSRecordParams{
+		p.logger().Info().Msgf("No DNS record '%s' found for domain '%s', creating now", record, domain)
+		created, err := client.CreateDNSRecord(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.CreateDNSRecordParams{
 			Content: ip,
 			Type:    "A",
 			Name:    record,
 		})
 		if err != nil {
-			return errors.Annotatef(err, "failed to create DNS record '%s' on domain '%s'", record, domain)
+			return errors.Annotatef(p.checkZone(domain, err), "failed to create DNS record '%s' on domain '%s'", record, domain)
 		}
+		created.Name = record
+		p.seen(created)
 	} else {
-		_, err = p.client.UpdateDNSRecord(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.UpdateDNSRecordParams{
+		_, err = client.UpdateDNSRecord(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.UpdateDNSRecordParams{
 			ID:      recordID,
 			Content: ip,
 			Type:    "A",
 		})
 		if err != nil {
-			return errors.Annotatef(err, "failed to update DNS record '%s' to IP address '%s'", record, ip)
+			return errors.Annotatef(p.checkZone(domain, err), "failed to update DNS record '%s' to IP address '%s'", record, ip)
 		}
 	}
 
-	log.Info().Msgf("Successfully updated DNS record '%s' to point to '%s'", record, ip)
+	p.logger().Info().Msgf("Successfully updated DNS record '%s' to point to '%s'", record, ip)
 	return nil
 }
+
+// Delete removes the A record, doing nothing if it doesn't exist
+func (p *CloudFlareProvider) Delete(domain, record string) error {
+	client := p.api()
+	zoneID, err := p.zoneID(client, domain)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	records, _, err := client.ListDNSRecords(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{Type: "A", Name: record})
+	if err != nil {
+		return errors.Annotatef(p.checkZone(domain, err), "unable to retrieve DNS record '%s' from CloudFlare", record)
+	}
+	for _, r := range records {
+		if r.Name != record {
+			continue
+		}
+		if err := client.DeleteDNSRecord(p.ctx, cloudflare.ZoneIdentifier(zoneID), r.ID); err != nil {
+			return errors.Annotatef(p.checkZone(domain, err), "failed to delete DNS record '%s' on domain '%s'", record, domain)
+		}
+		p.logger().Info().Msgf("Deleted DNS record '%s' pointing to '%s'", record, r.Content)
+	}
+	return nil
+}
+
+// ListTXT returns every TXT record with the given name, ordered by ID
+func (p *CloudFlareProvider) ListTXT(domain, record string) ([]ddns.TXTRecord, error) {
+	client := p.api()
+	zoneID, err := p.zoneID(client, domain)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return p.listTXT(client, zoneID, domain, record)
+}
+
+func (p *CloudFlareProvider) listTXT(client *cloudflare.API, zoneID, domain, record string) ([]ddns.TXTRecord, error) {
+	records, _, err := client.ListDNSRecords(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{Type: "TXT", Name: record})
+	if err != nil {
+		return nil, errors.Annotatef(p.checkZone(domain, err), "unable to retrieve TXT record '%s' from CloudFlare", record)
+	}
+	var txt []ddns.TXTRecord
+	for _, r := range records {
+		if r.Name == record {
+			txt = append(txt, ddns.TXTRecord{ID: r.ID, Content: r.Content})
+		}
+	}
+	sort.Slice(txt, func(i, j int) bool { return txt[i].ID < txt[j].ID })
+	return txt, nil
+}
+
+// UpdateTXT creates or replaces the content of a TXT record. If there are several with the name, the one with
+// the lowest ID is replaced.
+func (p *CloudFlareProvider) UpdateTXT(domain, record, content string) error {
+	client := p.api()
+	zoneID, err := p.zoneID(client, domain)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	records, err := p.listTXT(client, zoneID, domain, record)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(records) == 0 {
+		_, err := client.CreateDNSRecord(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.CreateDNSRecordParams{
+			Content: content,
+			Type:    "TXT",
+			Name:    record,
+		})
+		return errors.Annotatef(p.checkZone(domain, err), "failed to create TXT record '%s' on domain '%s'", record, domain)
+	}
+	_, err = client.UpdateDNSRecord(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.UpdateDNSRecordParams{
+		ID:      records[0].ID,
+		Content: content,
+		Type:    "TXT",
+	})
+	return errors.Annotatef(p.checkZone(domain, err), "failed to update TXT record '%s'", record)
+}
+
+// DeleteTXT deletes the TXT record with the given name and ID
+func (p *CloudFlareProvider) DeleteTXT(domain, record, id string) error {
+	client := p.api()
+	zoneID, err := p.zoneID(client, domain)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = client.DeleteDNSRecord(p.ctx, cloudflare.ZoneIdentifier(zoneID), id)
+	return errors.Annotatef(p.checkZone(domain, err), "failed to delete TXT record '%s' on domain '%s'", record, domain)
+}
+
+// Verify checks that CloudFlare accepts the credentials, returning a ddns.ProviderError of class ddns.ClassAuth if not
+func (p *CloudFlareProvider) Verify() error {
+	client := p.api()
+	if client.APIToken == "" {
+		_, err := client.UserDetails(p.ctx)
+		return errors.Annotate(classify(err), "CloudFlare did not accept the Global API Key")
+	}
+	result, err := client.VerifyAPIToken(p.ctx)
+	if err != nil {
+		return errors.Annotate(classify(err), "CloudFlare did not accept the API token")
+	}
+	if result.Status != "active" {
+		return &ddns.ProviderError{Class: ddns.ClassAuth, Err: errors.Errorf("API token is %s", result.Status)}
+	}
+	return nil
+}
+
+// Zones returns the names of every zone that the credentials can access, sorted
+func (p *CloudFlareProvider) Zones() ([]string, error) {
+	zones, err := p.api().ListZones(p.ctx)
+	if err != nil {
+		return nil, errors.Annotate(classify(err), "unable to list zones")
+	}
+	names := make([]string, len(zones))
+	for i, zone := range zones {
+		names[i] = zone.Name
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Records returns the names of the A records in the zone of domain, sorted
+func (p *CloudFlareProvider) Records(domain string) ([]string, error) {
+	client := p.api()
+	zoneID, err := p.zoneID(client, domain)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	records, _, err := client.ListDNSRecords(p.ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{Type: "A"})
+	if err != nil {
+		return nil, errors.Annotatef(p.checkZone(domain, err), "unable to list DNS records of domain '%s'", domain)
+	}
+	names := make([]string, len(records))
+	for i, r := range records {
+		names[i] = r.Name
+	}
+	sort.Strings(names)
+	return names, nil
+}
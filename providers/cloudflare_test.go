@@ -0,0 +1,171 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountQueries(t *testing.T) {
+	assert := assert.New(t)
+	tokenStatus := "active"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result interface{}
+		switch r.URL.Path {
+		case "/user/tokens/verify":
+			result = cloudflare.APITokenVerifyBody{Status: tokenStatus}
+		case "/user":
+			result = cloudflare.User{Email: "me@example.com"}
+		case "/zones":
+			zones := []cloudflare.Zone{{ID: "zone2", Name: "example.org"}, {ID: "zone1", Name: "example.com"}}
+			if name := r.URL.Query().Get("name"); name != "" {
+				for _, zone := range zones {
+					if zone.Name == name {
+						zones = []cloudflare.Zone{zone}
+					}
+				}
+			}
+			result = zones
+		case "/zones/zone1/dns_records":
+			result = []cloudflare.DNSRecord{{Name: "www.example.com"}, {Name: "home.example.com"}}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+	}))
+	defer server.Close()
+
+	provider, err := NewCloudFlareProvider(context.Background(), conf.Credentials{Token: "token"}, nil, cloudflare.BaseURL(server.URL))
+	require.NoError(t, err)
+	assert.NoError(provider.Verify())
+	zones, err := provider.Zones()
+	require.NoError(t, err)
+	assert.Equal([]string{"example.com", "example.org"}, zones)
+	records, err := provider.Records("example.com")
+	require.NoError(t, err)
+	assert.Equal([]string{"home.example.com", "www.example.com"}, records)
+
+	tokenStatus = "disabled"
+	err = provider.Verify()
+	class, _ := ddns.Classify(err)
+	assert.Equal(ddns.ClassAuth, class, "an inactive token must be an auth error")
+
+	provider, err = NewCloudFlareProvider(context.Background(), conf.Credentials{Method: conf.AuthKey, Key: "key", Email: "me@example.com"}, nil, cloudflare.BaseURL(server.URL))
+	require.NoError(t, err)
+	assert.NoError(provider.Verify())
+}
+
+func TestUpdate(t *testing.T) {
+	assert := assert.New(t)
+	var updated []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result interface{}
+		switch {
+		case r.URL.Path == "/zones":
+			result = []cloudflare.Zone{{ID: "zone1", Name: "example.com"}}
+		case r.URL.Path == "/zones/zone1/dns_records":
+			proxied := true
+			result = []cloudflare.DNSRecord{
+				{ID: "rec1", Type: "A", Name: "home.example.com", Content: "1.1.1.1"},
+				{ID: "rec2", Type: "A", Name: "www.example.com", Content: "1.1.1.1", Proxied: &proxied},
+			}
+		case r.Method == http.MethodPut || r.Method == http.MethodPatch:
+			updated = append(updated, r.URL.Path)
+			result = cloudflare.DNSRecord{ID: "rec1"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+	}))
+	defer server.Close()
+
+	provider, err := NewCloudFlareProvider(context.Background(), conf.Credentials{Token: "token"}, nil, cloudflare.BaseURL(server.URL))
+	require.NoError(t, err)
+	assert.NoError(provider.Update("example.com", "home.example.com", "1.1.1.1"))
+	assert.Empty(updated, "a record that already points at the IP must not be written")
+	assert.NoError(provider.ForceUpdate("example.com", "home.example.com", "1.1.1.1"))
+	assert.Equal([]string{"/zones/zone1/dns_records/rec1"}, updated, "a forced update must be written even though the IP is unchanged")
+	assert.NoError(provider.Update("example.com", "home.example.com", "2.2.2.2"))
+	assert.Len(updated, 2)
+
+	proxied, known := provider.Proxied("example.com", "home.example.com")
+	assert.True(known)
+	assert.False(proxied)
+	_, known = provider.Proxied("example.com", "www.example.com")
+	assert.False(known, "a record that hasn't been read yet can't be known to be proxied")
+	_, err = provider.Get("example.com", "www.example.com")
+	require.NoError(t, err)
+	proxied, known = provider.Proxied("example.com", "www.example.com")
+	assert.True(known)
+	assert.True(proxied)
+}
+
+func TestDelete(t *testing.T) {
+	assert := assert.New(t)
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result interface{}
+		switch {
+		case r.URL.Path == "/zones":
+			result = []cloudflare.Zone{{ID: "zone1", Name: "example.com"}}
+		case r.URL.Path == "/zones/zone1/dns_records":
+			records := []cloudflare.DNSRecord{}
+			if name := r.URL.Query().Get("name"); name == "home.example.com" {
+				records = append(records, cloudflare.DNSRecord{ID: "rec1", Name: name, Content: "1.1.1.1"})
+			}
+			result = records
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			result = cloudflare.DNSRecord{ID: "rec1"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+	}))
+	defer server.Close()
+
+	provider, err := NewCloudFlareProvider(context.Background(), conf.Credentials{Token: "token"}, nil, cloudflare.BaseURL(server.URL))
+	require.NoError(t, err)
+	assert.NoError(provider.Delete("example.com", "home.example.com"))
+	assert.Equal([]string{"/zones/zone1/dns_records/rec1"}, deleted)
+	assert.NoError(provider.Delete("example.com", "gone.example.com"), "a record that doesn't exist must not be an error")
+	assert.Len(deleted, 1)
+}
+
+func TestTXT(t *testing.T) {
+	assert := assert.New(t)
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result interface{}
+		switch {
+		case r.URL.Path == "/zones":
+			result = []cloudflare.Zone{{ID: "zone1", Name: "example.com"}}
+		case r.URL.Path == "/zones/zone1/dns_records":
+			records := []cloudflare.DNSRecord{}
+			if r.URL.Query().Get("type") == "TXT" && r.URL.Query().Get("name") == "_ddns-lease.home.example.com" {
+				records = append(records,
+					cloudflare.DNSRecord{ID: "rec2", Type: "TXT", Name: "_ddns-lease.home.example.com", Content: "holder=gateway-2"},
+					cloudflare.DNSRecord{ID: "rec1", Type: "TXT", Name: "_ddns-lease.home.example.com", Content: "holder=gateway-1"})
+			}
+			result = records
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			result = cloudflare.DNSRecord{ID: "rec2"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+	}))
+	defer server.Close()
+
+	provider, err := NewCloudFlareProvider(context.Background(), conf.Credentials{Token: "token"}, nil, cloudflare.BaseURL(server.URL))
+	require.NoError(t, err)
+	records, err := provider.ListTXT("example.com", "_ddns-lease.home.example.com")
+	assert.NoError(err)
+	assert.Equal([]ddns.TXTRecord{{ID: "rec1", Content: "holder=gateway-1"}, {ID: "rec2", Content: "holder=gateway-2"}}, records, "records must be ordered by ID")
+	records, err = provider.ListTXT("example.com", "_ddns-lease.other.example.com")
+	assert.NoError(err)
+	assert.Empty(records)
+	assert.NoError(provider.DeleteTXT("example.com", "_ddns-lease.home.example.com", "rec2"))
+	assert.Equal([]string{"/zones/zone1/dns_records/rec2"}, deleted)
+}
@@ -0,0 +1,93 @@
+package providers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Provider reads and updates DNS records through a DNS provider's API
+type Provider interface {
+	Get(domain, record string) (string, error)
+	Update(domain, record, ip string) error
+	ForceUpdate(domain, record, ip string) error
+	Delete(domain, record string) error
+	// Proxied returns whether the record is proxied, known is false if the provider hasn't seen the record yet
+	Proxied(domain, record string) (proxied, known bool)
+}
+
+// LookupFunc reads the current value of a record over DNS
+type LookupFunc func(ctx context.Context, domain, record string) (string, error)
+
+// DNSReader reads records over DNS instead of the provider's API, so that the API is only called when
+// an update is actually needed. If the DNS lookup fails, the API is used instead.
+// Proxied records are served with the proxy's addresses rather than their own, so they are always read
+// through the API. Each record is first read through the API too, to find out whether it is proxied.
+type DNSReader struct {
+	mu       sync.Mutex
+	provider Provider
+	lookup   LookupFunc
+	ctx      context.Context
+	log      *zerolog.Logger
+}
+
+// NewDNSReader wraps provider so that Get uses lookup first, see DNSReader.
+// Lookups are cancelled once ctx is done, after which Get only uses the API.
+func NewDNSReader(ctx context.Context, provider Provider, lookup LookupFunc) *DNSReader {
+	return &DNSReader{provider: provider, lookup: lookup, ctx: ctx}
+}
+
+// SetLogger replaces the global logger as the destination of the reader's logs
+func (r *DNSReader) SetLogger(logger zerolog.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.log = &logger
+}
+
+// logger returns the logger given to SetLogger, or the global logger
+func (r *DNSReader) logger() *zerolog.Logger {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.log == nil {
+		return &log.Logger
+	}
+	return r.log
+}
+
+// Get returns the IP the record is served as, returning empty string if it doesn't exist
+func (r *DNSReader) Get(domain, record string) (string, error) {
+	if proxied, known := r.provider.Proxied(domain, record); known && !proxied && r.ctx.Err() == nil {
+		ip, err := r.lookup(r.ctx, domain, record)
+		if err == nil {
+			return ip, nil
+		}
+		if r.ctx.Err() == nil {
+			r.logger().Warn().Msgf("Unable to read DNS record '%s' over DNS, falling back to the API: %v", record, err)
+		}
+	}
+	ip, err := r.provider.Get(domain, record)
+	return ip, errors.Trace(err)
+}
+
+// Update always goes through the provider's API
+func (r *DNSReader) Update(domain, record, ip string) error {
+	return errors.Trace(r.provider.Update(domain, record, ip))
+}
+
+// ForceUpdate always goes through the provider's API
+func (r *DNSReader) ForceUpdate(domain, record, ip string) error {
+	return errors.Trace(r.provider.ForceUpdate(domain, record, ip))
+}
+
+// Proxied returns whether the provider has found the record to be proxied
+func (r *DNSReader) Proxied(domain, record string) (proxied, known bool) {
+	return r.provider.Proxied(domain, record)
+}
+
+// Delete always goes through the provider's API
+func (r *DNSReader) Delete(domain, record string) error {
+	return errors.Trace(r.provider.Delete(domain, record))
+}
@@ -0,0 +1,108 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProvider counts API calls
+type fakeProvider struct {
+	ip      string
+	gets    int
+	updates int
+	deletes int
+	// proxied lists the proxied records, seen the records that have been read
+	proxied map[string]bool
+	seen    map[string]bool
+}
+
+func (p *fakeProvider) Get(domain, record string) (string, error) {
+	p.gets++
+	if p.seen == nil {
+		p.seen = map[string]bool{}
+	}
+	p.seen[record] = true
+	return p.ip, nil
+}
+
+func (p *fakeProvider) Update(domain, record, ip string) error {
+	p.updates++
+	p.ip = ip
+	return nil
+}
+
+func (p *fakeProvider) ForceUpdate(domain, record, ip string) error {
+	return p.Update(domain, record, ip)
+}
+
+func (p *fakeProvider) Delete(domain, record string) error {
+	p.deletes++
+	p.ip = ""
+	return nil
+}
+
+func (p *fakeProvider) Proxied(domain, record string) (bool, bool) {
+	return p.proxied[record], p.seen[record]
+}
+
+func TestDNSReader(t *testing.T) {
+	assert := assert.New(t)
+	api := &fakeProvider{ip: "1.1.1.1", proxied: map[string]bool{"proxied.abc.com": true}}
+	failing := false
+	lookups := 0
+	lookup := func(ctx context.Context, domain, record string) (string, error) {
+		lookups++
+		if failing {
+			return "", errors.New("no nameserver answered")
+		}
+		return "2.2.2.2", nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := NewDNSReader(ctx, api, lookup)
+	var logs bytes.Buffer
+	reader.SetLogger(zerolog.New(&logs))
+
+	ip, err := reader.Get("abc.com", "xyz.abc.com")
+	assert.NoError(err)
+	assert.Equal("1.1.1.1", ip, "a record must first be read through the API to find out if it is proxied")
+	assert.Equal(1, api.gets)
+
+	ip, err = reader.Get("abc.com", "xyz.abc.com")
+	assert.NoError(err)
+	assert.Equal("2.2.2.2", ip)
+	assert.Equal(1, api.gets, "the API must not be used while DNS works")
+
+	for i := 0; i < 2; i++ {
+		ip, err = reader.Get("abc.com", "proxied.abc.com")
+		assert.NoError(err)
+		assert.Equal("1.1.1.1", ip, "proxied records must always be read through the API")
+	}
+	assert.Equal(3, api.gets)
+	assert.Equal(1, lookups)
+
+	failing = true
+	ip, err = reader.Get("abc.com", "xyz.abc.com")
+	assert.NoError(err)
+	assert.Equal("1.1.1.1", ip)
+	assert.Equal(4, api.gets)
+	assert.Contains(logs.String(), "falling back to the API", "the fallback must be logged to the reader's logger")
+
+	assert.NoError(reader.Update("abc.com", "xyz.abc.com", "3.3.3.3"))
+	assert.Equal(1, api.updates)
+
+	assert.NoError(reader.Delete("abc.com", "xyz.abc.com"))
+	assert.Equal(1, api.deletes)
+
+	failing = false
+	cancel()
+	_, err = reader.Get("abc.com", "xyz.abc.com")
+	assert.NoError(err)
+	assert.Equal(2, lookups, "no lookups must be made once the context is done")
+	assert.Equal(5, api.gets, "the API must still be used, e.g. by shutdown actions")
+}
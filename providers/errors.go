@@ -0,0 +1,133 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+)
+
+// CloudFlare error codes that mean the token itself is invalid, rather than lacking a permission
+var authErrorCodes = map[int]bool{
+	6003: true, // Invalid request headers
+	6111: true, // Invalid format for Authorization header
+	9106: true, // Missing X-Auth-Key, X-Auth-Email or Authorization headers
+	9109: true, // Invalid access token
+}
+
+// cloudflareError is implemented by all of the typed errors that cloudflare-go returns for 4xx responses
+type cloudflareError interface {
+	Type() cloudflare.ErrorType
+	ErrorCodes() []int
+}
+
+// newClient creates a CloudFlare API client that makes requests with httpClient, if not nil, and reports errors
+// as a ddns.ProviderError. The client's own retries are disabled so that the daemon can decide how to retry,
+// based on the class of error.
+func newClient(creds conf.Credentials, httpClient *http.Client, opts ...cloudflare.Option) (*cloudflare.API, error) {
+	classifying := &http.Client{}
+	if httpClient != nil {
+		*classifying = *httpClient
+	}
+	base := classifying.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	classifying.Transport = &classifyingTransport{base: base}
+	opts = append([]cloudflare.Option{
+		cloudflare.UsingRetryPolicy(0, 0, 0),
+		cloudflare.HTTPClient(classifying),
+	}, opts...)
+	if creds.Method == conf.AuthKey {
+		return cloudflare.New(creds.Key, creds.Email, opts...)
+	}
+	return cloudflare.NewWithAPIToken(creds.Token, opts...)
+}
+
+// classify converts an error returned by cloudflare-go into a ddns.ProviderError, if it can be classified
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var perr *ddns.ProviderError
+	if errors.As(err, &perr) {
+		return err
+	}
+	var cfErr cloudflareError
+	if !errors.As(err, &cfErr) {
+		return err
+	}
+	class := ddns.ClassValidation
+	switch cfErr.Type() {
+	case cloudflare.ErrorTypeAuthorization:
+		// cloudflare-go calls a 401 an authorization error
+		class = ddns.ClassAuth
+	case cloudflare.ErrorTypeAuthentication:
+		// and a 403 an authentication error, which is usually a missing permission
+		class = ddns.ClassPermission
+	case cloudflare.ErrorTypeNotFound:
+		class = ddns.ClassNotFound
+	case cloudflare.ErrorTypeRateLimit:
+		class = ddns.ClassRateLimited
+	case cloudflare.ErrorTypeService:
+		class = ddns.ClassTransient
+	}
+	for _, code := range cfErr.ErrorCodes() {
+		if authErrorCodes[code] {
+			class = ddns.ClassAuth
+		}
+	}
+	return &ddns.ProviderError{Class: class, Err: err}
+}
+
+// classifyingTransport turns 429 and 5xx responses into errors before cloudflare-go sees them,
+// as it otherwise discards the status code and Retry-After header.
+type classifyingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *classifyingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		resp.Body.Close()
+		return nil, &ddns.ProviderError{
+			Class:      ddns.ClassRateLimited,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			Err:        fmt.Errorf("CloudFlare responded with HTTP %d", resp.StatusCode),
+		}
+	case resp.StatusCode >= http.StatusInternalServerError:
+		resp.Body.Close()
+		return nil, &ddns.ProviderError{
+			Class: ddns.ClassTransient,
+			Err:   fmt.Errorf("CloudFlare responded with HTTP %d", resp.StatusCode),
+		}
+	}
+	return resp, nil
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of seconds or an HTTP date.
+// Returns zero if it is missing or malformed.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,154 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/mattolenik/cloudflare-ddns-client/ddns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeZoneAPI serves a single zone with no records, failing record requests with the given status until it is cleared
+type fakeZoneAPI struct {
+	mu          sync.Mutex
+	status      int
+	codes       []int
+	retryAfter  string
+	zoneLookups int
+	requests    int
+}
+
+func (f *fakeZoneAPI) fail(status int, codes ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+	f.codes = codes
+}
+
+func (f *fakeZoneAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/zones" {
+		f.zoneLookups++
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": []cloudflare.Zone{{ID: "zone1", Name: "example.com"}}})
+		return
+	}
+	f.requests++
+	if f.status != 0 {
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		w.WriteHeader(f.status)
+		var errs []cloudflare.ResponseInfo
+		for _, code := range f.codes {
+			errs = append(errs, cloudflare.ResponseInfo{Code: code, Message: "failed"})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "errors": errs})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": []cloudflare.DNSRecord{}})
+}
+
+func TestErrorClassification(t *testing.T) {
+	fake := &fakeZoneAPI{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	api, err := newClient(conf.Credentials{Token: "token"}, nil, cloudflare.BaseURL(server.URL))
+	require.NoError(t, err)
+	provider := &CloudFlareProvider{client: api, ctx: context.Background()}
+
+	for _, tc := range []struct {
+		status int
+		codes  []int
+		class  ddns.ErrorClass
+	}{
+		{http.StatusUnauthorized, nil, ddns.ClassAuth},
+		{http.StatusForbidden, []int{9109}, ddns.ClassAuth},
+		{http.StatusForbidden, []int{10000}, ddns.ClassPermission},
+		{http.StatusNotFound, nil, ddns.ClassNotFound},
+		{http.StatusBadRequest, []int{9005}, ddns.ClassValidation},
+		{http.StatusBadGateway, nil, ddns.ClassTransient},
+		{http.StatusTooManyRequests, nil, ddns.ClassRateLimited},
+	} {
+		fake.fail(tc.status, tc.codes...)
+		requests := fake.requests
+		_, err := provider.Get("example.com", "sub.example.com")
+		require.Error(t, err)
+		class, _ := ddns.Classify(err)
+		assert.Equal(t, tc.class, class, "HTTP %d with codes %v", tc.status, tc.codes)
+		assert.Equal(t, requests+1, fake.requests, "HTTP %d must not be retried by the client", tc.status)
+	}
+
+	fake.retryAfter = "30"
+	_, err = provider.Get("example.com", "sub.example.com")
+	class, retryAfter := ddns.Classify(err)
+	assert.Equal(t, ddns.ClassRateLimited, class)
+	assert.Equal(t, 30*time.Second, retryAfter)
+}
+
+func TestZoneIDIsCachedUntilNotFound(t *testing.T) {
+	assert := assert.New(t)
+	fake := &fakeZoneAPI{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	api, err := newClient(conf.Credentials{Token: "token"}, nil, cloudflare.BaseURL(server.URL))
+	require.NoError(t, err)
+	provider := &CloudFlareProvider{client: api, ctx: context.Background()}
+
+	for i := 0; i < 3; i++ {
+		_, err := provider.Get("example.com", "sub.example.com")
+		require.NoError(t, err)
+	}
+	assert.Equal(1, fake.zoneLookups)
+
+	fake.fail(http.StatusNotFound)
+	_, err = provider.Get("example.com", "sub.example.com")
+	assert.Error(err)
+	fake.fail(0)
+	_, err = provider.Get("example.com", "sub.example.com")
+	require.NoError(t, err)
+	assert.Equal(2, fake.zoneLookups, "the zone ID must be looked up again after a not found error")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2023, 7, 30, 18, 0, 0, 0, time.UTC)
+	assert.Equal(2*time.Minute, parseRetryAfter("120", now))
+	assert.Equal(90*time.Second, parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	assert.Zero(parseRetryAfter("", now))
+	assert.Zero(parseRetryAfter("soon", now))
+	assert.Zero(parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+}
+
+func TestNewClientAuthMethods(t *testing.T) {
+	assert := assert.New(t)
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": []cloudflare.Zone{{ID: "zone1"}}})
+	}))
+	defer server.Close()
+
+	api, err := newClient(conf.Credentials{Token: "token"}, nil, cloudflare.BaseURL(server.URL))
+	require.NoError(t, err)
+	_, err = api.ZoneIDByName("example.com")
+	require.NoError(t, err)
+	assert.Equal("Bearer token", headers.Get("Authorization"))
+	assert.Empty(headers.Get("X-Auth-Key"))
+
+	api, err = newClient(conf.Credentials{Method: conf.AuthKey, Key: "key", Email: "me@example.com"}, nil, cloudflare.BaseURL(server.URL))
+	require.NoError(t, err)
+	_, err = api.ZoneIDByName("example.com")
+	require.NoError(t, err)
+	assert.Equal("key", headers.Get("X-Auth-Key"))
+	assert.Equal("me@example.com", headers.Get("X-Auth-Email"))
+	assert.Empty(headers.Get("Authorization"))
+}
@@ -0,0 +1,122 @@
+package providers
+
+import (
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+)
+
+// IPListTarget keeps an item in a CloudFlare account level IP list pointed at the public IP, e.g. for WAF rules.
+// The item belonging to this host is identified by its comment, which is the record's name.
+type IPListTarget struct {
+	provider *CloudFlareProvider
+}
+
+// NewIPListTarget creates an IPListTarget that uses the provider's API client and token
+func NewIPListTarget(provider *CloudFlareProvider) *IPListTarget {
+	return &IPListTarget{provider: provider}
+}
+
+// Get returns the IP of the record's item in the list, returning empty string if it doesn't exist
+func (t *IPListTarget) Get(r conf.RecordConfig) (string, error) {
+	client := t.provider.api()
+	listID, err := t.findList(client, r)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	items, err := t.findItems(client, r, listID)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(items) == 0 {
+		return "", nil
+	}
+	return *items[0].IP, nil
+}
+
+// Update adds ip to the list under the record's comment and then removes any other IPs with that comment.
+// Adding first means the host is never missing from the list, even briefly.
+func (t *IPListTarget) Update(r conf.RecordConfig, ip string) error {
+	return t.update(r, ip, false)
+}
+
+// ForceUpdate is the same as Update, but writes ip to the list even if it is already there
+func (t *IPListTarget) ForceUpdate(r conf.RecordConfig, ip string) error {
+	return t.update(r, ip, true)
+}
+
+func (t *IPListTarget) update(r conf.RecordConfig, ip string, force bool) error {
+	client := t.provider.api()
+	rc := cloudflare.AccountIdentifier(r.Account)
+	listID, err := t.findList(client, r)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	items, err := t.findItems(client, r, listID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	stale := cloudflare.ListItemDeleteRequest{}
+	found := false
+	for _, item := range items {
+		if *item.IP == ip {
+			found = true
+		} else {
+			stale.Items = append(stale.Items, cloudflare.ListItemDeleteItemRequest{ID: item.ID})
+		}
+	}
+	if !found || force {
+		// Adding an IP that is already in the list replaces its item
+		_, err := client.CreateListItem(t.provider.ctx, rc, cloudflare.ListCreateItemParams{
+			ID:   listID,
+			Item: cloudflare.ListItemCreateRequest{IP: &ip, Comment: r.Record},
+		})
+		if err != nil {
+			return errors.Annotatef(classify(err), "failed to add '%s' to IP list '%s'", ip, r.List)
+		}
+	}
+	if len(stale.Items) > 0 {
+		_, err := client.DeleteListItems(t.provider.ctx, rc, cloudflare.ListDeleteItemsParams{ID: listID, Items: stale})
+		if err != nil {
+			return errors.Annotatef(classify(err), "failed to remove previous IPs of '%s' from IP list '%s'", r.Record, r.List)
+		}
+	}
+	if found && len(stale.Items) == 0 && !force {
+		t.provider.logger().Info().Msgf("IP list '%s' already has '%s' for '%s'", r.List, ip, r.Record)
+		return nil
+	}
+	t.provider.logger().Info().Msgf("Successfully updated '%s' in IP list '%s' to '%s'", r.Record, r.List, ip)
+	return nil
+}
+
+// findList returns the ID of the record's IP list
+func (t *IPListTarget) findList(client *cloudflare.API, r conf.RecordConfig) (string, error) {
+	lists, err := client.ListLists(t.provider.ctx, cloudflare.AccountIdentifier(r.Account), cloudflare.ListListsParams{})
+	if err != nil {
+		return "", errors.Annotatef(classify(err), "unable to retrieve lists of account '%s' from CloudFlare", r.Account)
+	}
+	for _, list := range lists {
+		if list.Name == r.List {
+			if list.Kind != cloudflare.ListTypeIP {
+				return "", errors.Errorf("list '%s' holds %s items, not IPs", r.List, list.Kind)
+			}
+			return list.ID, nil
+		}
+	}
+	return "", errors.NotFoundf("IP list '%s' in account '%s'", r.List, r.Account)
+}
+
+// findItems returns the IP items in the list that belong to the record
+func (t *IPListTarget) findItems(client *cloudflare.API, r conf.RecordConfig, listID string) ([]cloudflare.ListItem, error) {
+	items, err := client.ListListItems(t.provider.ctx, cloudflare.AccountIdentifier(r.Account), cloudflare.ListListItemsParams{ID: listID})
+	if err != nil {
+		return nil, errors.Annotatef(classify(err), "unable to retrieve items of IP list '%s' from CloudFlare", r.List)
+	}
+	var matching []cloudflare.ListItem
+	for _, item := range items {
+		if item.Comment == r.Record && item.IP != nil {
+			matching = append(matching, item)
+		}
+	}
+	return matching, nil
+}
@@ -0,0 +1,119 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeListAPI serves the parts of CloudFlare's Lists API used by IPListTarget, completing bulk operations immediately
+type fakeListAPI struct {
+	mu     sync.Mutex
+	items  []cloudflare.ListItem
+	nextID int
+	posts  int
+}
+
+func (f *fakeListAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result interface{}
+	switch {
+	case r.URL.Path == "/accounts/123/rules/lists":
+		result = []cloudflare.List{{ID: "other", Name: "other", Kind: "ip"}, {ID: "list1", Name: "allow", Kind: "ip"}}
+	case strings.HasPrefix(r.URL.Path, "/accounts/123/rules/lists/bulk_operations/"):
+		result = cloudflare.ListBulkOperation{Status: "completed"}
+	case r.URL.Path == "/accounts/123/rules/lists/list1/items" && r.Method == http.MethodGet:
+		result = f.items
+	case r.URL.Path == "/accounts/123/rules/lists/list1/items" && r.Method == http.MethodPost:
+		var created []cloudflare.ListItemCreateRequest
+		json.NewDecoder(r.Body).Decode(&created)
+		f.posts++
+	create:
+		for _, c := range created {
+			// Like CloudFlare, an IP that is already in the list replaces its item
+			for i, item := range f.items {
+				if *item.IP == *c.IP {
+					f.items[i].Comment = c.Comment
+					continue create
+				}
+			}
+			f.nextID++
+			f.items = append(f.items, cloudflare.ListItem{ID: fmt.Sprint(f.nextID), IP: c.IP, Comment: c.Comment})
+		}
+		result = map[string]string{"operation_id": "op"}
+	case r.URL.Path == "/accounts/123/rules/lists/list1/items" && r.Method == http.MethodDelete:
+		var deleted cloudflare.ListItemDeleteRequest
+		json.NewDecoder(r.Body).Decode(&deleted)
+		for _, d := range deleted.Items {
+			for i, item := range f.items {
+				if item.ID == d.ID {
+					f.items = append(f.items[:i], f.items[i+1:]...)
+					break
+				}
+			}
+		}
+		result = map[string]string{"operation_id": "op"}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+}
+
+func (f *fakeListAPI) ips(comment string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ips []string
+	for _, item := range f.items {
+		if item.Comment == comment {
+			ips = append(ips, *item.IP)
+		}
+	}
+	return ips
+}
+
+func TestIPListTarget(t *testing.T) {
+	assert := assert.New(t)
+	other := "9.9.9.9"
+	fake := &fakeListAPI{items: []cloudflare.ListItem{{ID: "keep", IP: &other, Comment: "branch"}}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	api, err := cloudflare.NewWithAPIToken("token", cloudflare.BaseURL(server.URL))
+	require.NoError(t, err)
+	target := NewIPListTarget(&CloudFlareProvider{client: api, ctx: context.Background()})
+	r := conf.RecordConfig{Type: conf.TypeIPList, Record: "office", Account: "123", List: "allow"}
+
+	ip, err := target.Get(r)
+	require.NoError(t, err)
+	assert.Empty(ip)
+
+	require.NoError(t, target.Update(r, "1.1.1.1"))
+	require.NoError(t, target.Update(r, "2.2.2.2"))
+	assert.Equal([]string{"2.2.2.2"}, fake.ips("office"), "the previous IP must be replaced")
+	assert.Equal([]string{"9.9.9.9"}, fake.ips("branch"), "other hosts' items must be left alone")
+
+	ip, err = target.Get(r)
+	require.NoError(t, err)
+	assert.Equal("2.2.2.2", ip)
+
+	posts := fake.posts
+	require.NoError(t, target.Update(r, "2.2.2.2"))
+	assert.Equal(posts, fake.posts, "an IP that is already in the list must not be written again")
+	require.NoError(t, target.ForceUpdate(r, "2.2.2.2"))
+	assert.Equal(posts+1, fake.posts, "a forced update must write the IP even though it is already in the list")
+	assert.Equal([]string{"2.2.2.2"}, fake.ips("office"))
+
+	_, err = target.Get(conf.RecordConfig{Type: conf.TypeIPList, Record: "office", Account: "123", List: "missing"})
+	assert.Error(err)
+}
@@ -0,0 +1,119 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/juju/errors"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+)
+
+// LBOriginTarget keeps the address of an origin in a CloudFlare load balancer pool pointed at the public IP.
+// The origin is identified by its name, which is the record's name.
+type LBOriginTarget struct {
+	provider *CloudFlareProvider
+}
+
+// NewLBOriginTarget creates an LBOriginTarget that uses the provider's API client and token
+func NewLBOriginTarget(provider *CloudFlareProvider) *LBOriginTarget {
+	return &LBOriginTarget{provider: provider}
+}
+
+// Get returns the address of the record's origin
+func (t *LBOriginTarget) Get(r conf.RecordConfig) (string, error) {
+	client := t.provider.api()
+	poolID, err := t.findPool(client, r)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	origins, err := t.getOrigins(client, r, poolID)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	i, err := findOrigin(origins, r)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	var address string
+	if err := json.Unmarshal(origins[i]["address"], &address); err != nil {
+		return "", errors.Annotatef(err, "malformed address of origin '%s'", r.Record)
+	}
+	return address, nil
+}
+
+// Update changes the address of the record's origin. The pool's origins are sent back as they were
+// received with only that one address changed, so that every other setting is left exactly as it was,
+// including any the API client doesn't know about.
+func (t *LBOriginTarget) Update(r conf.RecordConfig, ip string) error {
+	client := t.provider.api()
+	poolID, err := t.findPool(client, r)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	origins, err := t.getOrigins(client, r, poolID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	i, err := findOrigin(origins, r)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	address, _ := json.Marshal(ip)
+	origins[i]["address"] = address
+	_, err = client.Raw(t.provider.ctx, http.MethodPatch, poolPath(r, poolID), map[string]interface{}{"origins": origins}, nil)
+	if err != nil {
+		return errors.Annotatef(classify(err), "failed to update origin '%s' in pool '%s' to '%s'", r.Record, r.Pool, ip)
+	}
+	t.provider.logger().Info().Msgf("Successfully updated origin '%s' in pool '%s' to '%s'", r.Record, r.Pool, ip)
+	return nil
+}
+
+// ForceUpdate is the same as Update, which always writes the address
+func (t *LBOriginTarget) ForceUpdate(r conf.RecordConfig, ip string) error {
+	return t.Update(r, ip)
+}
+
+// findPool returns the ID of the record's pool
+func (t *LBOriginTarget) findPool(client *cloudflare.API, r conf.RecordConfig) (string, error) {
+	pools, err := client.ListLoadBalancerPools(t.provider.ctx, cloudflare.AccountIdentifier(r.Account), cloudflare.ListLoadBalancerPoolParams{})
+	if err != nil {
+		return "", errors.Annotatef(classify(err), "unable to retrieve load balancer pools of account '%s' from CloudFlare", r.Account)
+	}
+	for _, pool := range pools {
+		if pool.Name == r.Pool {
+			return pool.ID, nil
+		}
+	}
+	return "", errors.NotFoundf("load balancer pool '%s' in account '%s'", r.Pool, r.Account)
+}
+
+// getOrigins fetches the pool's origins as raw JSON objects
+func (t *LBOriginTarget) getOrigins(client *cloudflare.API, r conf.RecordConfig, poolID string) ([]map[string]json.RawMessage, error) {
+	res, err := client.Raw(t.provider.ctx, http.MethodGet, poolPath(r, poolID), nil, nil)
+	if err != nil {
+		return nil, errors.Annotatef(classify(err), "unable to retrieve load balancer pool '%s' from CloudFlare", r.Pool)
+	}
+	pool := struct {
+		Origins []map[string]json.RawMessage `json:"origins"`
+	}{}
+	if err := json.Unmarshal(res, &pool); err != nil {
+		return nil, errors.Annotatef(err, "malformed load balancer pool '%s'", r.Pool)
+	}
+	return pool.Origins, nil
+}
+
+func findOrigin(origins []map[string]json.RawMessage, r conf.RecordConfig) (int, error) {
+	for i, origin := range origins {
+		var name string
+		if json.Unmarshal(origin["name"], &name) == nil && name == r.Record {
+			return i, nil
+		}
+	}
+	return 0, errors.NotFoundf("origin '%s' in load balancer pool '%s'", r.Record, r.Pool)
+}
+
+func poolPath(r conf.RecordConfig, poolID string) string {
+	return fmt.Sprintf("/accounts/%s/load_balancers/pools/%s", r.Account, poolID)
+}
@@ -0,0 +1,67 @@
+package providers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/mattolenik/cloudflare-ddns-client/conf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPool = `{
+	"id": "pool1",
+	"name": "web",
+	"monitor": "mon1",
+	"origins": [
+		{"name": "home", "address": "1.1.1.1", "enabled": true, "weight": 0.7, "virtual_network_id": "vnet"},
+		{"name": "cloud", "address": "9.9.9.9", "enabled": true, "weight": 0.3, "header": {"Host": ["example.com"]}}
+	]
+}`
+
+func TestLBOriginTarget(t *testing.T) {
+	assert := assert.New(t)
+	var patched []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result string
+		switch {
+		case r.URL.Path == "/accounts/123/load_balancers/pools":
+			result = `[{"id": "pool0", "name": "other"}, {"id": "pool1", "name": "web"}]`
+		case r.URL.Path == "/accounts/123/load_balancers/pools/pool1" && r.Method == http.MethodGet:
+			result = testPool
+		case r.URL.Path == "/accounts/123/load_balancers/pools/pool1" && r.Method == http.MethodPatch:
+			patched, _ = ioutil.ReadAll(r.Body)
+			result = testPool
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"success": true, "result": ` + result + `}`))
+	}))
+	defer server.Close()
+	api, err := cloudflare.NewWithAPIToken("token", cloudflare.BaseURL(server.URL))
+	require.NoError(t, err)
+	target := NewLBOriginTarget(&CloudFlareProvider{client: api, ctx: context.Background()})
+	r := conf.RecordConfig{Type: conf.TypeLBOrigin, Record: "home", Account: "123", Pool: "web"}
+
+	ip, err := target.Get(r)
+	require.NoError(t, err)
+	assert.Equal("1.1.1.1", ip)
+
+	require.NoError(t, target.Update(r, "2.2.2.2"))
+	// Only the address of the one origin may change, everything else must be sent back untouched
+	assert.JSONEq(`{"origins": [
+		{"name": "home", "address": "2.2.2.2", "enabled": true, "weight": 0.7, "virtual_network_id": "vnet"},
+		{"name": "cloud", "address": "9.9.9.9", "enabled": true, "weight": 0.3, "header": {"Host": ["example.com"]}}
+	]}`, string(patched))
+
+	r.Record = "missing"
+	_, err = target.Get(r)
+	assert.Error(err)
+	r.Record, r.Pool = "home", "missing"
+	assert.Error(target.Update(r, "2.2.2.2"))
+}
@@ -0,0 +1,171 @@
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// How far ahead to look for a time matching a cron expression, e.g. "0 0 29 2 *" is due only every four years
+const cronSearchYears = 5
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+// Cron is due at the times matched by a standard five field cron expression
+type Cron struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	// As in cron, if both days of the month and of the week are restricted, matching either is enough
+	anyDay     bool
+	anyWeekday bool
+}
+
+// ParseCron parses a cron expression with the fields minute, hour, day of month, month and day of week, e.g.
+// "*/15 8-18 * * Mon-Fri". Fields may be *, numbers, names of months and days, ranges, lists and steps.
+// The macros @hourly, @daily, @weekly, @monthly and @yearly are supported too.
+func ParseCron(expr string) (*Cron, error) {
+	text := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(text)]; ok {
+		text = macro
+	}
+	fields := strings.Fields(text)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("invalid cron expression '%s', expected 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+	c := &Cron{anyDay: strings.HasPrefix(fields[2], "*"), anyWeekday: strings.HasPrefix(fields[4], "*")}
+	var err error
+	if c.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, errors.Annotatef(err, "invalid minute in cron expression '%s'", expr)
+	}
+	if c.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, errors.Annotatef(err, "invalid hour in cron expression '%s'", expr)
+	}
+	if c.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, errors.Annotatef(err, "invalid day of month in cron expression '%s'", expr)
+	}
+	if c.months, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, errors.Annotatef(err, "invalid month in cron expression '%s'", expr)
+	}
+	weekdays := map[string]int{}
+	for name, day := range dayNames {
+		weekdays[name] = int(day)
+	}
+	// 7 is Sunday too
+	if c.weekdays, err = parseCronField(fields[4], 0, 7, weekdays); err != nil {
+		return nil, errors.Annotatef(err, "invalid day of week in cron expression '%s'", expr)
+	}
+	if c.weekdays[7] {
+		c.weekdays[0] = true
+	}
+	if c.Next(time.Now()).IsZero() {
+		return nil, errors.Errorf("cron expression '%s' never matches", expr)
+	}
+	return c, nil
+}
+
+// parseCronField returns the set of values matched by a comma separated list of *, values or ranges, each with an optional step
+func parseCronField(field string, min, max int, names map[string]int) (map[int]bool, error) {
+	values := map[int]bool{}
+	value := func(text string) (int, error) {
+		if n, ok := names[strings.ToLower(text)]; ok {
+			return n, nil
+		}
+		n, err := strconv.Atoi(text)
+		if err != nil || n < min || n > max {
+			return 0, errors.Errorf("'%s' is not a value from %d to %d", text, min, max)
+		}
+		return n, nil
+	}
+	for _, part := range strings.Split(field, ",") {
+		rangeText, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return nil, errors.Errorf("invalid step '%s'", stepText)
+			}
+		}
+		from, to := min, max
+		if rangeText != "*" {
+			first, last, isRange := strings.Cut(rangeText, "-")
+			var err error
+			if from, err = value(first); err != nil {
+				return nil, errors.Trace(err)
+			}
+			to = from
+			if isRange {
+				if to, err = value(last); err != nil {
+					return nil, errors.Trace(err)
+				}
+			} else if hasStep {
+				// As in cron, 5/15 means from 5 to the end, every 15
+				to = max
+			}
+			if to < from {
+				return nil, errors.Errorf("invalid range '%s'", rangeText)
+			}
+		}
+		for n := from; n <= to; n += step {
+			values[n] = true
+		}
+	}
+	return values, nil
+}
+
+// Next returns the first minute after the given time that matches the expression, or the zero time if there is none
+func (c *Cron) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !c.months[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !c.hours[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.minutes[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Allows returns true if the minute of t matches the expression
+func (c *Cron) Allows(t time.Time) bool {
+	return c.months[int(t.Month())] && c.dayMatches(t) && c.hours[t.Hour()] && c.minutes[t.Minute()]
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	day, weekday := c.days[t.Day()], c.weekdays[int(t.Weekday())]
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
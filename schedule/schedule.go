@@ -0,0 +1,92 @@
+// Package schedule decides when a record is next due to be checked: at a fixed interval, at times given by a
+// cron expression, or at a fixed interval limited to windows of time. All times are in the location of the
+// time they are given, usually the local time of the host.
+package schedule
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Schedule returns the first time after the given one that a check is due, and whether a check that wasn't
+// asked for by the schedule itself, e.g. when starting up, may be made at a given time
+type Schedule interface {
+	Next(after time.Time) time.Time
+	Allows(t time.Time) bool
+}
+
+// Every is due at a fixed interval
+type Every time.Duration
+
+// Next returns after plus the interval
+func (e Every) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(e))
+}
+
+// Allows returns true, a check may be made at any time
+func (e Every) Allows(t time.Time) bool {
+	return true
+}
+
+// Windowed is due at a fixed interval, but only within the windows. Once outside of them,
+// it is next due at the start of the next window.
+type Windowed struct {
+	Interval time.Duration
+	Windows  []Window
+}
+
+// Next returns after plus the interval, or the start of the next window if that is outside all of them
+func (w Windowed) Next(after time.Time) time.Time {
+	next := after.Add(w.Interval)
+	var earliest time.Time
+	for _, window := range w.Windows {
+		if window.Contains(next) {
+			return next
+		}
+		if start := window.NextStart(next); earliest.IsZero() || start.Before(earliest) {
+			earliest = start
+		}
+	}
+	return earliest
+}
+
+// Allows returns true if t is within one of the windows
+func (w Windowed) Allows(t time.Time) bool {
+	for _, window := range w.Windows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// New returns the schedule described by a record's options. cron, if given, is a cron expression, see ParseCron.
+// Otherwise the schedule is due every interval, or every defaultInterval if interval is 0, limited to the windows
+// if any are given, see ParseWindow.
+func New(interval time.Duration, cron string, windows []string, defaultInterval time.Duration) (Schedule, error) {
+	if interval < 0 {
+		return nil, errors.Errorf("invalid interval '%s', it must not be negative", interval)
+	}
+	if cron != "" {
+		if interval != 0 || len(windows) > 0 {
+			return nil, errors.New("a cron schedule can't be combined with an interval or windows, the cron expression already says when to check")
+		}
+		return ParseCron(cron)
+	}
+	if interval == 0 {
+		interval = defaultInterval
+	}
+	if len(windows) == 0 {
+		return Every(interval), nil
+	}
+	w := Windowed{Interval: interval}
+	for _, text := range windows {
+		window, err := ParseWindow(text)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		w.Windows = append(w.Windows, window)
+	}
+	return w, nil
+}
@@ -0,0 +1,112 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// at returns a time in UTC on the given day of August 2023, which starts on a Tuesday
+func at(day, hour, minute int) time.Time {
+	return time.Date(2023, time.August, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestEvery(t *testing.T) {
+	assert.Equal(t, at(1, 12, 30), Every(30*time.Minute).Next(at(1, 12, 0)))
+	assert.True(t, Every(30*time.Minute).Allows(at(1, 3, 7)))
+}
+
+func TestCron(t *testing.T) {
+	for _, tc := range []struct {
+		expr  string
+		after time.Time
+		next  time.Time
+	}{
+		{"*/15 * * * *", at(1, 12, 0), at(1, 12, 15)},
+		{"*/15 * * * *", at(1, 12, 14), at(1, 12, 15)},
+		{"0 * * * *", at(1, 12, 0), at(1, 13, 0)},
+		{"@hourly", at(1, 23, 59), at(2, 0, 0)},
+		{"30 9 * * Mon-Fri", at(4, 10, 0), at(7, 9, 30)},
+		{"0 8-18/2 * * *", at(1, 18, 0), at(2, 8, 0)},
+		{"0 0 1 * *", at(1, 0, 0), time.Date(2023, time.September, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", at(1, 0, 0), time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", at(1, 0, 0), at(6, 12, 0)},
+		// Either the day of the month or of the week
+		{"0 0 15 * Fri", at(1, 0, 0), at(4, 0, 0)},
+		{"5,10 1 * * *", at(1, 1, 5), at(1, 1, 10)},
+	} {
+		c, err := ParseCron(tc.expr)
+		require.NoError(t, err, tc.expr)
+		assert.Equal(t, tc.next, c.Next(tc.after), "%s after %s", tc.expr, tc.after)
+	}
+
+	c, err := ParseCron("*/15 9-17 * * Mon-Fri")
+	require.NoError(t, err)
+	assert.True(t, c.Allows(at(1, 9, 15).Add(30*time.Second)))
+	assert.False(t, c.Allows(at(1, 9, 16)))
+	assert.False(t, c.Allows(at(1, 3, 0)))
+	assert.False(t, c.Allows(at(5, 9, 15)), "Saturday doesn't match")
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "* * * * Someday", "0 0 30 2 *"} {
+		_, err := ParseCron(expr)
+		assert.Error(t, err, "expected '%s' to be invalid", expr)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	assert := assert.New(t)
+	business, err := ParseWindow("Mon-Fri 09:00-17:00")
+	require.NoError(t, err)
+	assert.True(business.Contains(at(1, 9, 0)))
+	assert.True(business.Contains(at(1, 16, 59)))
+	assert.False(business.Contains(at(1, 17, 0)))
+	assert.False(business.Contains(at(5, 12, 0)), "Saturday is outside the window")
+	assert.Equal(at(2, 9, 0), business.NextStart(at(1, 9, 0)))
+	assert.Equal(at(7, 9, 0), business.NextStart(at(4, 17, 0)), "after Friday comes Monday")
+
+	night, err := ParseWindow("Fri,Sat 22:00-06:00")
+	require.NoError(t, err)
+	assert.True(night.Contains(at(4, 23, 0)))
+	assert.True(night.Contains(at(5, 5, 59)), "the window crosses into Saturday")
+	assert.True(night.Contains(at(6, 1, 0)), "Saturday's window crosses into Sunday")
+	assert.False(night.Contains(at(7, 1, 0)), "Sunday's night belongs to Sunday, which isn't included")
+	assert.False(night.Contains(at(4, 1, 0)), "Thursday's night isn't included")
+
+	daily, err := ParseWindow("00:00-24:00")
+	require.NoError(t, err)
+	assert.True(daily.Contains(at(5, 23, 59)))
+
+	for _, text := range []string{"", "9-17", "Mon-Fri", "Mon-Fri 09:00", "Someday 09:00-17:00", "25:00-26:00", "09:00-09:00", "a b c"} {
+		_, err := ParseWindow(text)
+		assert.Error(err, "expected '%s' to be invalid", text)
+	}
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+	s, err := New(0, "", nil, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(Every(time.Minute), s, "records without a schedule use the default interval")
+
+	s, err = New(time.Hour, "", []string{"Mon-Fri 09:00-17:00"}, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(at(1, 13, 0), s.Next(at(1, 12, 0)))
+	assert.Equal(at(2, 9, 0), s.Next(at(1, 16, 30)), "once outside the window, the next check is at the start of the next one")
+	assert.True(s.Allows(at(1, 12, 0)))
+	assert.False(s.Allows(at(1, 3, 0)))
+
+	s, err = New(0, "0 * * * *", nil, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(at(1, 13, 0), s.Next(at(1, 12, 1)))
+
+	_, err = New(time.Hour, "0 * * * *", nil, time.Minute)
+	assert.Error(err, "expected cron and interval together to be invalid")
+	_, err = New(0, "0 * * * *", []string{"09:00-17:00"}, time.Minute)
+	assert.Error(err, "expected cron and windows together to be invalid")
+	_, err = New(-time.Second, "", nil, time.Minute)
+	assert.Error(err)
+	_, err = New(0, "", []string{"never"}, time.Minute)
+	assert.Error(err)
+}
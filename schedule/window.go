@@ -0,0 +1,118 @@
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const minutesPerDay = 24 * 60
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Window is a time of day, on some days of the week, e.g. business hours. A window that ends
+// before it starts crosses midnight, and belongs to the day it starts on.
+type Window struct {
+	Days  [7]bool // Indexed by time.Weekday
+	Start int     // Minutes since midnight
+	End   int     // Minutes since midnight, up to 24:00
+}
+
+// ParseWindow parses a window such as "09:00-17:00" for every day, or "Mon-Fri 09:00-17:00" or "Sat,Sun 22:00-06:00"
+func ParseWindow(text string) (Window, error) {
+	var w Window
+	fields := strings.Fields(text)
+	times := ""
+	switch len(fields) {
+	case 1:
+		times = fields[0]
+		for i := range w.Days {
+			w.Days[i] = true
+		}
+	case 2:
+		times = fields[1]
+		if err := w.parseDays(fields[0]); err != nil {
+			return w, errors.Annotatef(err, "invalid window '%s'", text)
+		}
+	default:
+		return w, errors.Errorf("invalid window '%s', expected days and times such as 'Mon-Fri 09:00-17:00'", text)
+	}
+	start, end, ok := strings.Cut(times, "-")
+	if !ok {
+		return w, errors.Errorf("invalid window '%s', expected times such as '09:00-17:00'", text)
+	}
+	var err error
+	if w.Start, err = parseTimeOfDay(start); err != nil {
+		return w, errors.Annotatef(err, "invalid window '%s'", text)
+	}
+	if w.End, err = parseTimeOfDay(end); err != nil {
+		return w, errors.Annotatef(err, "invalid window '%s'", text)
+	}
+	if w.Start == w.End || w.Start == minutesPerDay {
+		return w, errors.Errorf("invalid window '%s', it is empty", text)
+	}
+	return w, nil
+}
+
+func (w *Window) parseDays(text string) error {
+	for _, part := range strings.Split(text, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, ok := dayNames[strings.ToLower(first)]
+		if !ok {
+			return errors.Errorf("unknown day '%s', expected one of Mon, Tue, Wed, Thu, Fri, Sat or Sun", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = dayNames[strings.ToLower(last)]; !ok {
+				return errors.Errorf("unknown day '%s', expected one of Mon, Tue, Wed, Thu, Fri, Sat or Sun", last)
+			}
+		}
+		// Ranges may wrap around the end of the week, e.g. Fri-Mon
+		for day := from; ; day = (day + 1) % 7 {
+			w.Days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseTimeOfDay parses HH:MM into minutes since midnight, allowing 24:00 for the end of the day
+func parseTimeOfDay(text string) (int, error) {
+	hours, minutes, ok := strings.Cut(text, ":")
+	h, herr := strconv.Atoi(hours)
+	m, merr := strconv.Atoi(minutes)
+	if !ok || herr != nil || merr != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, errors.Errorf("invalid time '%s', expected HH:MM", text)
+	}
+	return h*60 + m, nil
+}
+
+// Contains returns true if t is within the window
+func (w Window) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.Start < w.End {
+		return w.Days[day] && minute >= w.Start && minute < w.End
+	}
+	yesterday := (day + 6) % 7
+	return (w.Days[day] && minute >= w.Start) || (w.Days[yesterday] && minute < w.End)
+}
+
+// NextStart returns the first start of the window after t
+func (w Window) NextStart(t time.Time) time.Time {
+	for i := 0; i <= 7; i++ {
+		start := time.Date(t.Year(), t.Month(), t.Day()+i, w.Start/60, w.Start%60, 0, 0, t.Location())
+		if w.Days[start.Weekday()] && start.After(t) {
+			return start
+		}
+	}
+	// Unreachable for a window with at least one day
+	return t.Add(7 * 24 * time.Hour)
+}
@@ -0,0 +1,100 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdownDeletesRecord(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the binary")
+	}
+	fake := &fakeCloudFlare{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	home := t.TempDir()
+	config := filepath.Join(home, "cloudflare-ddns.toml")
+	require.NoError(t, os.WriteFile(config, []byte(`token = "token"
+[[records]]
+domain = "example.com"
+record = "home.example.com"
+on_shutdown = "delete"
+`), 0600))
+
+	cmd := exec.Command(testBinary(t), "--config", config, "--cloudflare-api-url", server.URL, "--ip", "1.1.1.1", "--daemon")
+	cmd.Env = []string{"HOME=" + home, "PATH=" + os.Getenv("PATH")}
+	// Only read once the process has exited
+	out := &bytes.Buffer{}
+	cmd.Stdout, cmd.Stderr = out, out
+	require.NoError(t, cmd.Start())
+	defer func() { t.Log(out.String()) }()
+
+	assert.Eventually(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return fake.record != nil && fake.record.Content == "1.1.1.1"
+	}, 10*time.Second, 10*time.Millisecond, "expected the daemon to create the record")
+
+	require.NoError(t, cmd.Process.Signal(syscall.SIGTERM))
+	assert.NoError(t, cmd.Wait(), "expected a graceful shutdown to exit with code 0")
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	assert.Nil(t, fake.record, "expected the record to be deleted on shutdown")
+}
+
+func TestLeaderTakeover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the binary")
+	}
+	fake := &fakeCloudFlare{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	leaseFile := filepath.Join(t.TempDir(), "cloudflare-ddns.lease")
+	content := func() string {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		if fake.record == nil {
+			return ""
+		}
+		return fake.record.Content
+	}
+	// Each instance sees a different public IP, as if they were on different WAN links
+	start := func(id, ip string) (*exec.Cmd, *bytes.Buffer) {
+		cmd := exec.Command(testBinary(t),
+			"--cloudflare-api-url", server.URL, "--domain", "example.com", "--record", "home.example.com", "--token", "token",
+			"--ip", ip, "--daemon", "--poll-interval", "200ms",
+			"--lease", "file", "--lease-file", leaseFile, "--lease-ttl", "3s", "--instance-id", id)
+		cmd.Env = []string{"HOME=" + t.TempDir(), "PATH=" + os.Getenv("PATH")}
+		out := &bytes.Buffer{}
+		cmd.Stdout, cmd.Stderr = out, out
+		require.NoError(t, cmd.Start())
+		return cmd, out
+	}
+
+	active, activeOut := start("active", "1.1.1.1")
+	defer func() { t.Log(activeOut.String()) }()
+	require.Eventually(t, func() bool { return content() == "1.1.1.1" }, 10*time.Second, 10*time.Millisecond, "expected the first instance to update the record")
+
+	standby, standbyOut := start("standby", "2.2.2.2")
+	defer func() { t.Log(standbyOut.String()) }()
+	time.Sleep(2 * time.Second)
+	assert.Equal(t, "1.1.1.1", content(), "the standby instance must not update the record while the lease is held")
+
+	require.NoError(t, active.Process.Signal(syscall.SIGTERM))
+	assert.NoError(t, active.Wait())
+	assert.Eventually(t, func() bool { return content() == "2.2.2.2" }, 10*time.Second, 10*time.Millisecond, "expected the standby instance to take over")
+
+	require.NoError(t, standby.Process.Signal(syscall.SIGTERM))
+	assert.NoError(t, standby.Wait())
+}
@@ -0,0 +1,120 @@
+package task
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy decides what happens to an event when a subscriber's buffer is full.
+// Publishing never waits for a subscriber, so a slow subscriber only ever loses its own events.
+type DropPolicy int
+
+const (
+	// DropNewest discards the event being published, keeping what is already buffered
+	DropNewest DropPolicy = iota
+	// DropOldest discards the oldest buffered event to make room for the new one
+	DropOldest
+)
+
+// Bus delivers status events to any number of independent subscribers
+type Bus struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewBus creates an event bus without any subscribers
+func NewBus() *Bus {
+	return &Bus{subs: map[*Subscription]struct{}{}}
+}
+
+// Subscription receives events published to a Bus
+type Subscription struct {
+	Name    string
+	bus     *Bus
+	events  chan Status
+	policy  DropPolicy
+	dropped atomic.Uint64
+}
+
+// Subscribe adds a subscriber that buffers up to buffer events, handling overflow according to policy.
+// Subscribing to a closed bus returns a subscription whose channel is already closed.
+func (b *Bus) Subscribe(name string, buffer int, policy DropPolicy) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	sub := &Subscription{Name: name, bus: b, events: make(chan Status, buffer), policy: policy}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.events)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Publish delivers an event to all subscribers without blocking
+func (b *Bus) Publish(status Status) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		sub.offer(status)
+	}
+}
+
+// Close closes the channels of all subscribers, after any buffered events have been read.
+// Events published after Close are discarded.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.events)
+		delete(b.subs, sub)
+	}
+}
+
+// Events returns the channel that events are delivered on, it is closed when the bus is closed or on Unsubscribe
+func (s *Subscription) Events() <-chan Status {
+	return s.events
+}
+
+// Dropped returns how many events this subscriber has missed because its buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Unsubscribe stops delivery of events and closes the subscription's channel
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.events)
+	}
+}
+
+func (s *Subscription) offer(status Status) {
+	select {
+	case s.events <- status:
+		return
+	default:
+	}
+	if s.policy == DropOldest {
+		select {
+		case <-s.events:
+			s.dropped.Add(1)
+		default:
+		}
+		select {
+		case s.events <- status:
+			return
+		default:
+		}
+	}
+	s.dropped.Add(1)
+}
@@ -0,0 +1,42 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	assert := assert.New(t)
+	bus := NewBus()
+	all := bus.Subscribe("all", 10, DropNewest)
+	newest := bus.Subscribe("newest", 2, DropNewest)
+	oldest := bus.Subscribe("oldest", 2, DropOldest)
+	gone := bus.Subscribe("gone", 10, DropNewest)
+	gone.Unsubscribe()
+
+	// Nobody is reading, publishing must still never block
+	for _, msg := range []string{"a", "b", "c", "d"} {
+		bus.Publish(InfoStatus(msg))
+	}
+	bus.Close()
+	bus.Publish(InfoStatus("after close"))
+
+	read := func(sub *Subscription) []string {
+		var msgs []string
+		for s := range sub.Events() {
+			msgs = append(msgs, s.Message)
+		}
+		return msgs
+	}
+	assert.Equal([]string{"a", "b", "c", "d"}, read(all))
+	assert.Equal([]string{"a", "b"}, read(newest), "expected new events to be dropped when full")
+	assert.Equal([]string{"c", "d"}, read(oldest), "expected old events to be dropped when full")
+	assert.Empty(read(gone), "expected no events after unsubscribing")
+	assert.EqualValues(0, all.Dropped())
+	assert.EqualValues(2, newest.Dropped())
+	assert.EqualValues(2, oldest.Dropped())
+
+	_, ok := <-bus.Subscribe("late", 1, DropNewest).Events()
+	assert.False(ok, "expected subscribing to a closed bus to return a closed subscription")
+}
@@ -2,8 +2,10 @@ package task
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
 )
 
 type StatusType int
@@ -14,11 +16,135 @@ const (
 	Fatal            = 2
 )
 
+// Kind identifies what happened, so that events can be processed without parsing their message
+type Kind string
+
+const (
+	DaemonStarted       Kind = "daemon_started"
+	DaemonStopped       Kind = "daemon_stopped"
+	RecordAdded         Kind = "record_added"
+	RecordRemoved       Kind = "record_removed"
+	IPDetected          Kind = "ip_detected"
+	IPDetectionFailed   Kind = "ip_detection_failed"
+	IPChanged           Kind = "ip_changed"
+	RecordInSync        Kind = "record_in_sync"
+	RecordOutOfSync     Kind = "record_out_of_sync"
+	RecordUpdated       Kind = "record_updated"
+	RecordForced        Kind = "record_forced"
+	RecordParked        Kind = "record_parked"
+	RecordRestored      Kind = "record_restored"
+	RecordDeleted       Kind = "record_deleted"
+	LookupFailed        Kind = "lookup_failed"
+	UpdateFailed        Kind = "update_failed"
+	AddressRejected     Kind = "address_rejected"
+	UpdateDamped        Kind = "update_damped"
+	PropagationVerified Kind = "propagation_verified"
+	PropagationFailed   Kind = "propagation_failed"
+	PropagationSkipped  Kind = "propagation_skipped"
+	HeartbeatUpdated    Kind = "heartbeat_updated"
+	HeartbeatFailed     Kind = "heartbeat_failed"
+	RateLimited         Kind = "rate_limited"
+	StateSaveFailed     Kind = "state_save_failed"
+	LeadershipChanged   Kind = "leadership_changed"
+	LeaseFailed         Kind = "lease_failed"
+)
+
 type Status struct {
 	Type    StatusType
+	Kind    Kind
 	Message string
 	Error   error
 	IsDone  bool
+
+	// Optional details about the event, left empty when not applicable
+	Record   string
+	Zone     string
+	OldIP    string
+	NewIP    string
+	Source   string
+	Duration time.Duration
+	Attempt  int
+	// ErrorClass is the kind of failure, as classified by the provider, e.g. rate_limited
+	ErrorClass string
+}
+
+// WithKind sets the kind of event
+func (s Status) WithKind(kind Kind) Status {
+	s.Kind = kind
+	return s
+}
+
+// ForRecord sets the DNS record and zone the event is about
+func (s Status) ForRecord(zone, record string) Status {
+	s.Zone, s.Record = zone, record
+	return s
+}
+
+// WithIPs sets the previous and new IP addresses
+func (s Status) WithIPs(oldIP, newIP string) Status {
+	s.OldIP, s.NewIP = oldIP, newIP
+	return s
+}
+
+// WithSource sets where an IP address came from
+func (s Status) WithSource(source string) Status {
+	s.Source = source
+	return s
+}
+
+// WithDuration sets how long the operation took
+func (s Status) WithDuration(d time.Duration) Status {
+	s.Duration = d
+	return s
+}
+
+// WithAttempt sets how many consecutive attempts have been made, starting from 1
+func (s Status) WithAttempt(attempt int) Status {
+	s.Attempt = attempt
+	return s
+}
+
+// WithErrorClass sets the kind of failure
+func (s Status) WithErrorClass(class string) Status {
+	s.ErrorClass = class
+	return s
+}
+
+// Log writes the event to logger, at the level matching its type
+func (s Status) Log(logger *zerolog.Logger) {
+	switch s.Type {
+	case Info:
+		logger.Info().EmbedObject(s).Msg(s.Message)
+	case Error:
+		logger.Error().EmbedObject(s).Msg(s.Message)
+	case Fatal:
+		logger.Error().EmbedObject(s).Msg("FATAL: " + s.Message)
+	}
+}
+
+// MarshalZerologObject adds the event's details as log fields, omitting those that are not set
+func (s Status) MarshalZerologObject(e *zerolog.Event) {
+	if s.Kind != "" {
+		e.Str("event", string(s.Kind))
+	}
+	for _, f := range []struct{ key, value string }{
+		{"record", s.Record},
+		{"zone", s.Zone},
+		{"old_ip", s.OldIP},
+		{"new_ip", s.NewIP},
+		{"source", s.Source},
+		{"error_class", s.ErrorClass},
+	} {
+		if f.value != "" {
+			e.Str(f.key, f.value)
+		}
+	}
+	if s.Duration > 0 {
+		e.Dur("duration", s.Duration)
+	}
+	if s.Attempt > 0 {
+		e.Int("attempt", s.Attempt)
+	}
 }
 
 func InfoStatus(message string) Status {
@@ -0,0 +1,35 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusLogFields(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+
+	status := InfoStatusf("DNS record '%s' now points to '%s'", "sub.example.com", "1.1.1.2").
+		WithKind(RecordUpdated).
+		ForRecord("example.com", "sub.example.com").
+		WithIPs("1.1.1.1", "1.1.1.2").
+		WithDuration(1500 * time.Millisecond)
+	logger.Info().EmbedObject(status).Msg(status.Message)
+
+	var fields map[string]any
+	assert.NoError(json.Unmarshal(buf.Bytes(), &fields))
+	assert.Equal("record_updated", fields["event"])
+	assert.Equal("sub.example.com", fields["record"])
+	assert.Equal("example.com", fields["zone"])
+	assert.Equal("1.1.1.1", fields["old_ip"])
+	assert.Equal("1.1.1.2", fields["new_ip"])
+	assert.Equal(1500.0, fields["duration"])
+	assert.NotContains(fields, "source", "expected unset fields to be omitted")
+	assert.NotContains(fields, "attempt", "expected unset fields to be omitted")
+}